@@ -67,8 +67,15 @@ if [ -f "/home/$username/private/.kubelab/kubelab_key" ]; then
   chown "$username":"$username" /home/"$username"/.ssh/kubelab_key
 fi
 
-# set hostkey in sshd_config
-sed -i "s/#HostKey \/etc\/ssh\/ssh_host_rsa_key/HostKey \/home\/$username\/private\/.kubelab\/ssh_host_rsa_key/" /etc/ssh/sshd_config
+# set hostkey in sshd_config, the key mounted by the operator survives resets of the workspace
+if [ -f "/etc/ssh/kubelab/ssh_host_ecdsa_key" ]; then
+  install -m 600 -o root -g root /etc/ssh/kubelab/ssh_host_ecdsa_key /etc/ssh/ssh_host_ecdsa_key
+  install -m 644 -o root -g root /etc/ssh/kubelab/ssh_host_ecdsa_key.pub /etc/ssh/ssh_host_ecdsa_key.pub
+  sed -i "/^#\?HostKey /d" /etc/ssh/sshd_config
+  echo "HostKey /etc/ssh/ssh_host_ecdsa_key" >> /etc/ssh/sshd_config
+else
+  sed -i "s/#HostKey \/etc\/ssh\/ssh_host_rsa_key/HostKey \/home\/$username\/private\/.kubelab\/ssh_host_rsa_key/" /etc/ssh/sshd_config
+fi
 # set new paths for authorized_keys
 sed -i "s/.*AuthorizedKeysFile.*/AuthorizedKeysFile\t\.ssh\/authorized_keys .ssh\/kubelab_key /g" /etc/ssh/sshd_config
 # allow key auth
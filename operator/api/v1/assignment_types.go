@@ -0,0 +1,69 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AssignmentSpec defines the desired state of Assignment
+type AssignmentSpec struct {
+	// Folder or tarball (.tar, .tar.gz, .tgz) inside the class share containing the starter files
+	Source string `json:"source,omitempty"`
+	// Time at which the work of the students gets collected
+	Due metav1.Time `json:"due,omitempty"`
+}
+
+// AssignmentStudentStatus defines the observed state of the assignment for one student
+type AssignmentStudentStatus struct {
+	Id        string `json:"id"`
+	HandedOut bool   `json:"handedOut,omitempty"`
+	Collected bool   `json:"collected,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// AssignmentStatus defines the observed state of Assignment
+type AssignmentStatus struct {
+	Conditions []metav1.Condition        `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	Students   []AssignmentStudentStatus `json:"students,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// Assignment is the Schema for the assignments API.
+// It is handed out to the classroom whose namespace it lives in, so only the staff of the classroom can manage it
+type Assignment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AssignmentSpec   `json:"spec,omitempty"`
+	Status AssignmentStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AssignmentList contains a list of Assignment
+type AssignmentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Assignment `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Assignment{}, &AssignmentList{})
+}
@@ -17,27 +17,133 @@ limitations under the License.
 package v1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// ClassroomStaff is a member of the teaching staff of a classroom
+type ClassroomStaff struct {
+	Id string `json:"id"`
+	// owner and teacher may change the classroom, assistants may only view and restart the labs
+	//+kubebuilder:validation:Enum=owner;teacher;assistant
+	Role string `json:"role"`
+}
+
+// ClassroomEnrollment allows students to enroll themselves with a join code
+type ClassroomEnrollment struct {
+	// Code the students have to present in their EnrollmentRequest
+	JoinCode string `json:"joinCode"`
+	// Maximum number of students, 0 means unlimited
+	//+kubebuilder:validation:Minimum=0
+	Capacity int32 `json:"capacity,omitempty"`
+	// Requests have to be approved by the staff before the student gets enrolled
+	RequireApproval bool `json:"requireApproval,omitempty"`
+}
+
+// ClassroomSecurity hardens the labs of a classroom
+type ClassroomSecurity struct {
+	// restricted runs the lab as non-root without privilege escalation, baseline drops NET_RAW and sets the default seccomp profile,
+	// privileged-lab keeps the capabilities sshd needs to switch users. The namespaces of the users get the matching Pod Security Admission level.
+	// restricted needs a template, which runs sshd as user 1000 and reads the host key from /etc/ssh/kubelab,
+	// the default image needs root and does not start with it.
+	// privileged-lab if not set
+	//+kubebuilder:validation:Enum=restricted;baseline;privileged-lab
+	Profile string `json:"profile,omitempty"`
+	// RuntimeClass of the labs, e.g. gvisor
+	RuntimeClassName string `json:"runtimeClassName,omitempty"`
+	// Runs the labs in a user namespace, so root inside the lab is not root on the node
+	UserNamespace bool `json:"userNamespace,omitempty"`
+}
+
+// ClassroomPlacement selects the nodes the labs of a classroom run on
+type ClassroomPlacement struct {
+	// Labs only run on nodes with these labels, e.g. a dedicated node pool for exams
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations for the taints of a dedicated node pool
+	Tolerations []v1.Toleration `json:"tolerations,omitempty"`
+	// Node label the labs of the classroom are spread evenly across, e.g. kubernetes.io/hostname or topology.kubernetes.io/zone
+	SpreadTopologyKey string `json:"spreadTopologyKey,omitempty"`
+}
+
+// LabReset records the last reset of the lab of a student
+type LabReset struct {
+	Student string `json:"student"`
+	// The workspace was wiped in addition to the lab container
+	Workspace bool `json:"workspace,omitempty"`
+	// Time the reset finished
+	Time metav1.Time `json:"time"`
+	// Result of the reset
+	Message string `json:"message,omitempty"`
+}
+
 // ClassroomSpec defines the desired state of Classroom
 type ClassroomSpec struct {
-	Teacher           KubelabUser   `json:"teacher,omitempty"`
-	EnrolledStudents  []KubelabUser `json:"enrolledStudents,omitempty"`
-	TemplateContainer string        `json:"templateContainer,omitempty"`
-	AllowUserRoot     string        `json:"allowUserRoot,omitempty"`
-	RootPass          string        `json:"rootPass,omitempty"`
-	EnableExamMode    string        `json:"enableExamMode,omitempty"`
+	// Teacher is kept for existing classrooms and is handled like a staff member with the role owner
+	Teacher           KubelabUser      `json:"teacher,omitempty"`
+	Staff             []ClassroomStaff `json:"staff,omitempty"`
+	EnrolledStudents  []KubelabUser    `json:"enrolledStudents,omitempty"`
+	TemplateContainer string           `json:"templateContainer,omitempty"`
+	AllowUserRoot     string           `json:"allowUserRoot,omitempty"`
+	RootPass          string           `json:"rootPass,omitempty"`
+	EnableExamMode    string           `json:"enableExamMode,omitempty"`
+	// Users with matching labels are enrolled in addition to the enrolled students, e.g. kubelab.local/course: java-ws26
+	StudentSelector *metav1.LabelSelector `json:"studentSelector,omitempty"`
+	// Self-service enrollment, disabled if not set
+	Enrollment *ClassroomEnrollment `json:"enrollment,omitempty"`
+	// Draft, Active, Closed or Archived. If empty, the phase follows the start and end date
+	//+kubebuilder:validation:Enum=Draft;Active;Closed;Archived
+	Phase string `json:"phase,omitempty"`
+	// Students get their labs from the start date on, before the classroom is a draft
+	StartDate *metav1.Time `json:"startDate,omitempty"`
+	// The classroom is closed from the end date on
+	EndDate *metav1.Time `json:"endDate,omitempty"`
+	// Maximum number of labs of the classroom running at the same time, 0 means unlimited.
+	// Labs scaled up beyond the limit are scaled down again
+	//+kubebuilder:validation:Minimum=0
+	MaxRunningLabs int32 `json:"maxRunningLabs,omitempty"`
+	// Security profile of the labs, privileged-lab if not set
+	Security *ClassroomSecurity `json:"security,omitempty"`
+	// Nodes of the labs, any linux node if not set
+	Placement *ClassroomPlacement `json:"placement,omitempty"`
+	// Pull policy of the template container, Always if not set
+	//+kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	ImagePullPolicy v1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// Secrets of private registries inside the namespace of the operator, they are copied into the namespaces of the users
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+	// Pulls the template container on the nodes of the labs from an hour before the start date on, until the classroom is closed
+	PrePull bool `json:"prePull,omitempty"`
+	// Number of stopped labs of students kept pre-started while the classroom is active, a started lab takes over its warm pod
+	//+kubebuilder:validation:Minimum=0
+	WarmPool int32 `json:"warmPool,omitempty"`
 }
 
 // ClassroomStatus defines the observed state of Classroom
 type ClassroomStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	// Ids of all students, the enrolled and the selected ones
+	Students []string `json:"students,omitempty"`
+	// Current phase of the classroom
+	Phase string `json:"phase,omitempty"`
+	// Time the archive was written, the classroom may only be deleted afterwards
+	ArchivedAt *metav1.Time `json:"archivedAt,omitempty"`
+	// Path of the archive on the NFS share
+	Archive string `json:"archive,omitempty"`
+	// Last reset per student, requested with the annotation reset.kubelab.local/<student>
+	Resets []LabReset `json:"resets,omitempty"`
+	// Enrollment requests waiting for the approval of the staff, as <student>/<request>
+	PendingEnrollments []string `json:"pendingEnrollments,omitempty"`
+	// Students whose lab can not start, because the quota of their namespace is exceeded
+	QuotaExceeded []string `json:"quotaExceeded,omitempty"`
+	// Ready warm pods of stopped labs
+	WarmPool int32 `json:"warmPool,omitempty"`
+	// Seconds from scaling up until the last started lab was ready
+	LabReadySeconds int32 `json:"labReadySeconds,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
 
 // Classroom is the Schema for the classrooms API
 type Classroom struct {
@@ -0,0 +1,107 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KeycloakDirectory configures the admin API of a Keycloak realm
+type KeycloakDirectory struct {
+	// URL of Keycloak, e.g. https://keycloak.example.com
+	URL   string `json:"url"`
+	Realm string `json:"realm"`
+	// Secret with the keys clientId and clientSecret of a client allowed to view users and groups
+	CredentialsSecret v1.SecretReference `json:"credentialsSecret"`
+	// Attribute of the user used as id, the username if empty
+	IdAttribute string `json:"idAttribute,omitempty"`
+}
+
+// LDAPDirectory configures an LDAP server
+type LDAPDirectory struct {
+	// URL of the server, e.g. ldaps://ldap.example.com:636
+	URL string `json:"url"`
+	// Secret with the keys bindDN and password, an anonymous bind is used if empty
+	CredentialsSecret *v1.SecretReference `json:"credentialsSecret,omitempty"`
+	// Base DN of the groups, e.g. ou=groups,dc=example,dc=com
+	GroupBaseDN string `json:"groupBaseDN"`
+	// Attribute of the group containing the DN of its members, member if empty
+	MemberAttribute string `json:"memberAttribute,omitempty"`
+	// Attribute of the user used as id, uid if empty
+	IdAttribute string `json:"idAttribute,omitempty"`
+}
+
+// DirectoryGroupMapping maps a group of the directory to roles and a classroom
+type DirectoryGroupMapping struct {
+	// Name of the group inside the directory
+	Group string `json:"group"`
+	// Roles given to the members of the group
+	Roles []UserRole `json:"roles,omitempty"`
+	// Classroom the members of the group are added to
+	Classroom string `json:"classroom,omitempty"`
+	// Members are enrolled as students or added to the staff with the role
+	//+kubebuilder:validation:Enum=student;owner;teacher;assistant
+	ClassroomRole string `json:"classroomRole,omitempty"`
+}
+
+// DirectorySyncSpec defines the desired state of DirectorySync
+type DirectorySyncSpec struct {
+	// Exactly one directory has to be set
+	Keycloak *KeycloakDirectory `json:"keycloak,omitempty"`
+	LDAP     *LDAPDirectory     `json:"ldap,omitempty"`
+	// Time between two syncs, 15 minutes if empty
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Only members of the groups are synced, users leaving all groups are disabled
+	Groups []DirectoryGroupMapping `json:"groups,omitempty"`
+}
+
+// DirectorySyncStatus defines the observed state of DirectorySync
+type DirectorySyncStatus struct {
+	Conditions   []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	LastSyncTime *metav1.Time       `json:"lastSyncTime,omitempty"`
+	// Number of synced users, which are enabled
+	Users int32 `json:"users,omitempty"`
+	// Number of synced users, which are disabled
+	Disabled int32 `json:"disabled,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// DirectorySync is the Schema for the directorysyncs API
+type DirectorySync struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DirectorySyncSpec   `json:"spec,omitempty"`
+	Status DirectorySyncStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DirectorySyncList contains a list of DirectorySync
+type DirectorySyncList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DirectorySync `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DirectorySync{}, &DirectorySyncList{})
+}
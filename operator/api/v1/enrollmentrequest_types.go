@@ -0,0 +1,68 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EnrollmentRequestSpec defines the desired state of EnrollmentRequest
+type EnrollmentRequestSpec struct {
+	// Name of the classroom to join
+	Classroom string `json:"classroom"`
+	// Join code published by the staff of the classroom
+	JoinCode string `json:"joinCode"`
+}
+
+// EnrollmentRequestStatus defines the observed state of EnrollmentRequest
+type EnrollmentRequestStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	// Pending, Enrolled or Rejected
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Decision of the staff, only needed if the classroom requires approval.
+	// Only the owners and teachers of the classroom may update the status of the request
+	Approved *bool `json:"approved,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Classroom",type=string,JSONPath=`.spec.classroom`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// EnrollmentRequest is the Schema for the enrollmentrequests API.
+// It lives in the namespace of the student, so a student can only ask to enroll themselves
+type EnrollmentRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EnrollmentRequestSpec   `json:"spec,omitempty"`
+	Status EnrollmentRequestStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// EnrollmentRequestList contains a list of EnrollmentRequest
+type EnrollmentRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EnrollmentRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EnrollmentRequest{}, &EnrollmentRequestList{})
+}
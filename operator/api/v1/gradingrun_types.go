@@ -0,0 +1,79 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GradingRunSpec defines the desired state of GradingRun
+type GradingRunSpec struct {
+	// Name of the assignment inside the same namespace whose collected work gets graded
+	Assignment string `json:"assignment,omitempty"`
+	// Image of the grader, the submission is mounted read only at $SUBMISSION_DIR
+	// and the grader is expected to write a JSON score to $SCORE_FILE
+	Image string `json:"image,omitempty"`
+	// Overrides the entrypoint of the grader image
+	Command []string `json:"command,omitempty"`
+}
+
+// GradingResult defines the result of the grader for one student
+type GradingResult struct {
+	Id string `json:"id"`
+	// Pending, Running, Succeeded or Failed
+	Phase    string `json:"phase,omitempty"`
+	ExitCode *int32 `json:"exitCode,omitempty"`
+	// Content of the score file as written by the grader, cut after 256 bytes
+	Score string `json:"score,omitempty"`
+	// ConfigMap inside the classroom namespace containing the tail of the output of the grader as output.log.
+	// The output is kept out of the status, so runs of large classrooms do not exceed the size of an object
+	OutputConfigMap string `json:"outputConfigMap,omitempty"`
+}
+
+// GradingRunStatus defines the observed state of GradingRun
+type GradingRunStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	Results    []GradingResult    `json:"results,omitempty"`
+	// ConfigMap inside the classroom namespace containing the results as results.csv
+	ResultsConfigMap string `json:"resultsConfigMap,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// GradingRun is the Schema for the gradingruns API.
+// It lives in the namespace of the classroom, so only the staff of the classroom can run graders against its work
+type GradingRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GradingRunSpec   `json:"spec,omitempty"`
+	Status GradingRunStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// GradingRunList contains a list of GradingRun
+type GradingRunList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GradingRun `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&GradingRun{}, &GradingRunList{})
+}
@@ -20,16 +20,55 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// ExternalIdentity links the user to an account inside an identity provider
+type ExternalIdentity struct {
+	// Name of the identity provider, e.g. keycloak or ldap
+	Provider string `json:"provider"`
+	// Id of the user inside the identity provider
+	Subject string `json:"subject"`
+}
+
+// UserRole is a role the permissions of a user are derived from
+// +kubebuilder:validation:Enum=student;teacher;assistant;admin;auditor
+type UserRole string
+
+// UserRetention defines what happens to the private folder once the user is deleted
+type UserRetention struct {
+	// Retain keeps the volume for the given days, so it is re-attached if the user is created again.
+	// Archive writes the folder into a tarball before it is deleted, Delete removes it right away
+	//+kubebuilder:validation:Enum=Retain;Archive;Delete
+	Policy string `json:"policy,omitempty"`
+	// Days a retained volume is kept, 30 if not set
+	//+kubebuilder:validation:Minimum=0
+	Days int32 `json:"days,omitempty"`
+}
+
 // KubelabUserSpec defines the desired state of KubelabUser
 type KubelabUserSpec struct {
 	// Normally StudentID, otherwise TeacherID
-	Id        string `json:"id,omitempty"`
-	IsTeacher bool   `json:"isTeacher,omitempty"`
+	Id string `json:"id,omitempty"`
+	// Deprecated: use Roles, the operator converts it into the role teacher
+	IsTeacher bool `json:"isTeacher,omitempty"`
+	// Roles of the user, users without roles are students
+	Roles            []UserRole        `json:"roles,omitempty"`
+	DisplayName      string            `json:"displayName,omitempty"`
+	Email            string            `json:"email,omitempty"`
+	ExternalIdentity *ExternalIdentity `json:"externalIdentity,omitempty"`
+	// Disabled users keep their namespace and data, but lose all permissions
+	Disabled bool `json:"disabled,omitempty"`
+	// The private folder is retained for 30 days if not set
+	Retention *UserRetention `json:"retention,omitempty"`
+	// Profile sizing the ResourceQuota and LimitRange of the namespace, student or teacher depending on the roles if not set
+	QuotaProfile string `json:"quotaProfile,omitempty"`
 }
 
 // KubelabUserStatus defines the observed state of KubelabUser
 type KubelabUserStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	// Roles the permissions of the user are derived from
+	Roles []UserRole `json:"roles,omitempty"`
+	// Retained volume of a former user with the same id, which was re-attached as private folder
+	RestoredVolume string `json:"restoredVolume,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,64 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabRestoreSpec defines the desired state of LabRestore
+type LabRestoreSpec struct {
+	// Name of the LabSnapshot inside the same namespace the lab is rolled back to
+	Snapshot string `json:"snapshot"`
+}
+
+// LabRestoreStatus defines the observed state of LabRestore
+type LabRestoreStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	// Pending, Stopping, Restoring, Starting, Restored or Failed
+	Phase string `json:"phase,omitempty"`
+	// Replicas of the lab before the restore, the lab is started again afterwards
+	Replicas int32 `json:"replicas,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Snapshot",type=string,JSONPath=`.spec.snapshot`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// LabRestore is the Schema for the labrestores API.
+// It lives in the namespace of the classroom, so only the staff of the classroom can roll back its labs
+type LabRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LabRestoreSpec   `json:"spec,omitempty"`
+	Status LabRestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LabRestoreList contains a list of LabRestore
+type LabRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LabRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LabRestore{}, &LabRestoreList{})
+}
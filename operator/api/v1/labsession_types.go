@@ -0,0 +1,69 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabSessionSpec defines the desired state of LabSession
+type LabSessionSpec struct {
+	// Name of the classroom of the lab, the student is given by the namespace
+	Classroom string `json:"classroom"`
+	// Running or Stopped
+	//+kubebuilder:validation:Enum=Running;Stopped
+	State string `json:"state"`
+}
+
+// LabSessionStatus defines the observed state of LabSession
+type LabSessionStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	// Starting, Running, Stopped or Denied
+	Phase string `json:"phase,omitempty"`
+	// Why the lab may not run
+	Message string `json:"message,omitempty"`
+	// Generation of the spec the lab was last scaled for, the lab is only scaled again if the spec changes
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Classroom",type=string,JSONPath=`.spec.classroom`
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.spec.state`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// LabSession is the Schema for the labsessions API
+type LabSession struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LabSessionSpec   `json:"spec,omitempty"`
+	Status LabSessionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LabSessionList contains a list of LabSession
+type LabSessionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LabSession `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LabSession{}, &LabSessionList{})
+}
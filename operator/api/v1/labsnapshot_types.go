@@ -0,0 +1,76 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabSnapshotSpec defines the desired state of LabSnapshot
+type LabSnapshotSpec struct {
+	// Id of the student owning the lab, the student has to be enrolled in the classroom of the namespace
+	Student string `json:"student"`
+	// Packs the filesystem of the running lab container into the workspace before the snapshot is taken
+	IncludeContainer bool `json:"includeContainer,omitempty"`
+	// VolumeSnapshotClass to use, by default a class of the CSI driver of the workspace.
+	// Without a class the workspace is copied by a Job
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+}
+
+// LabSnapshotStatus defines the observed state of LabSnapshot
+type LabSnapshotStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	// Pending, Ready or Failed
+	Phase string `json:"phase,omitempty"`
+	// VolumeSnapshot or Copy
+	Method string `json:"method,omitempty"`
+	// Name of the VolumeSnapshot inside the namespace of the student
+	VolumeSnapshot string `json:"volumeSnapshot,omitempty"`
+	// Folder of the copy on the NFS share
+	Path string `json:"path,omitempty"`
+	// The filesystem of the lab container is part of the snapshot
+	ContainerIncluded bool `json:"containerIncluded,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Student",type=string,JSONPath=`.spec.student`
+//+kubebuilder:printcolumn:name="Method",type=string,JSONPath=`.status.method`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// LabSnapshot is the Schema for the labsnapshots API.
+// It lives in the namespace of the classroom, so only the staff of the classroom can take snapshots of its labs
+type LabSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LabSnapshotSpec   `json:"spec,omitempty"`
+	Status LabSnapshotStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LabSnapshotList contains a list of LabSnapshot
+type LabSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LabSnapshot `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LabSnapshot{}, &LabSnapshotList{})
+}
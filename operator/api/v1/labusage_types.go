@@ -0,0 +1,76 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabUsageDay is the time a lab was scaled up on a day
+type LabUsageDay struct {
+	// Day in UTC, formatted as 2006-01-02
+	Date    string `json:"date"`
+	Seconds int64  `json:"seconds"`
+}
+
+// LabUsageSpec defines the desired state of LabUsage
+type LabUsageSpec struct {
+	// Name of the classroom of the lab
+	Classroom string `json:"classroom"`
+	// Id of the user owning the lab, a student or a member of the staff
+	Student string `json:"student"`
+}
+
+// LabUsageStatus defines the observed state of LabUsage
+type LabUsageStatus struct {
+	// Start of the current session, empty while the lab is scaled down
+	Since *metav1.Time `json:"since,omitempty"`
+	// Time the lab was scaled up per day, the current session is added once it ends or every hour
+	Days []LabUsageDay `json:"days,omitempty"`
+	// Sum of all days
+	TotalSeconds int64 `json:"totalSeconds,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Classroom",type=string,JSONPath=`.spec.classroom`
+//+kubebuilder:printcolumn:name="Student",type=string,JSONPath=`.spec.student`
+//+kubebuilder:printcolumn:name="Seconds",type=integer,JSONPath=`.status.totalSeconds`
+//+kubebuilder:printcolumn:name="Since",type=date,JSONPath=`.status.since`
+
+// LabUsage is the Schema for the labusages API
+type LabUsage struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LabUsageSpec   `json:"spec,omitempty"`
+	Status LabUsageStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LabUsageList contains a list of LabUsage
+type LabUsageList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LabUsage `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LabUsage{}, &LabUsageList{})
+}
@@ -22,10 +22,128 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Assignment) DeepCopyInto(out *Assignment) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Assignment.
+func (in *Assignment) DeepCopy() *Assignment {
+	if in == nil {
+		return nil
+	}
+	out := new(Assignment)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Assignment) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AssignmentList) DeepCopyInto(out *AssignmentList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Assignment, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AssignmentList.
+func (in *AssignmentList) DeepCopy() *AssignmentList {
+	if in == nil {
+		return nil
+	}
+	out := new(AssignmentList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AssignmentList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AssignmentSpec) DeepCopyInto(out *AssignmentSpec) {
+	*out = *in
+	in.Due.DeepCopyInto(&out.Due)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AssignmentSpec.
+func (in *AssignmentSpec) DeepCopy() *AssignmentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AssignmentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AssignmentStatus) DeepCopyInto(out *AssignmentStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Students != nil {
+		in, out := &in.Students, &out.Students
+		*out = make([]AssignmentStudentStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AssignmentStatus.
+func (in *AssignmentStatus) DeepCopy() *AssignmentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AssignmentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AssignmentStudentStatus) DeepCopyInto(out *AssignmentStudentStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AssignmentStudentStatus.
+func (in *AssignmentStudentStatus) DeepCopy() *AssignmentStudentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AssignmentStudentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Classroom) DeepCopyInto(out *Classroom) {
 	*out = *in
@@ -53,6 +171,21 @@ func (in *Classroom) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClassroomEnrollment) DeepCopyInto(out *ClassroomEnrollment) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClassroomEnrollment.
+func (in *ClassroomEnrollment) DeepCopy() *ClassroomEnrollment {
+	if in == nil {
+		return nil
+	}
+	out := new(ClassroomEnrollment)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClassroomList) DeepCopyInto(out *ClassroomList) {
 	*out = *in
@@ -85,10 +218,59 @@ func (in *ClassroomList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClassroomPlacement) DeepCopyInto(out *ClassroomPlacement) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClassroomPlacement.
+func (in *ClassroomPlacement) DeepCopy() *ClassroomPlacement {
+	if in == nil {
+		return nil
+	}
+	out := new(ClassroomPlacement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClassroomSecurity) DeepCopyInto(out *ClassroomSecurity) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClassroomSecurity.
+func (in *ClassroomSecurity) DeepCopy() *ClassroomSecurity {
+	if in == nil {
+		return nil
+	}
+	out := new(ClassroomSecurity)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClassroomSpec) DeepCopyInto(out *ClassroomSpec) {
 	*out = *in
 	in.Teacher.DeepCopyInto(&out.Teacher)
+	if in.Staff != nil {
+		in, out := &in.Staff, &out.Staff
+		*out = make([]ClassroomStaff, len(*in))
+		copy(*out, *in)
+	}
 	if in.EnrolledStudents != nil {
 		in, out := &in.EnrolledStudents, &out.EnrolledStudents
 		*out = make([]KubelabUser, len(*in))
@@ -96,6 +278,39 @@ func (in *ClassroomSpec) DeepCopyInto(out *ClassroomSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.StudentSelector != nil {
+		in, out := &in.StudentSelector, &out.StudentSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Enrollment != nil {
+		in, out := &in.Enrollment, &out.Enrollment
+		*out = new(ClassroomEnrollment)
+		**out = **in
+	}
+	if in.StartDate != nil {
+		in, out := &in.StartDate, &out.StartDate
+		*out = (*in).DeepCopy()
+	}
+	if in.EndDate != nil {
+		in, out := &in.EndDate, &out.EndDate
+		*out = (*in).DeepCopy()
+	}
+	if in.Security != nil {
+		in, out := &in.Security, &out.Security
+		*out = new(ClassroomSecurity)
+		**out = **in
+	}
+	if in.Placement != nil {
+		in, out := &in.Placement, &out.Placement
+		*out = new(ClassroomPlacement)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClassroomSpec.
@@ -108,6 +323,21 @@ func (in *ClassroomSpec) DeepCopy() *ClassroomSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClassroomStaff) DeepCopyInto(out *ClassroomStaff) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClassroomStaff.
+func (in *ClassroomStaff) DeepCopy() *ClassroomStaff {
+	if in == nil {
+		return nil
+	}
+	out := new(ClassroomStaff)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClassroomStatus) DeepCopyInto(out *ClassroomStatus) {
 	*out = *in
@@ -118,6 +348,32 @@ func (in *ClassroomStatus) DeepCopyInto(out *ClassroomStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Students != nil {
+		in, out := &in.Students, &out.Students
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ArchivedAt != nil {
+		in, out := &in.ArchivedAt, &out.ArchivedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Resets != nil {
+		in, out := &in.Resets, &out.Resets
+		*out = make([]LabReset, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PendingEnrollments != nil {
+		in, out := &in.PendingEnrollments, &out.PendingEnrollments
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.QuotaExceeded != nil {
+		in, out := &in.QuotaExceeded, &out.QuotaExceeded
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClassroomStatus.
@@ -131,26 +387,46 @@ func (in *ClassroomStatus) DeepCopy() *ClassroomStatus {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KubelabUser) DeepCopyInto(out *KubelabUser) {
+func (in *DirectoryGroupMapping) DeepCopyInto(out *DirectoryGroupMapping) {
+	*out = *in
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]UserRole, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DirectoryGroupMapping.
+func (in *DirectoryGroupMapping) DeepCopy() *DirectoryGroupMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(DirectoryGroupMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DirectorySync) DeepCopyInto(out *DirectorySync) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubelabUser.
-func (in *KubelabUser) DeepCopy() *KubelabUser {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DirectorySync.
+func (in *DirectorySync) DeepCopy() *DirectorySync {
 	if in == nil {
 		return nil
 	}
-	out := new(KubelabUser)
+	out := new(DirectorySync)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *KubelabUser) DeepCopyObject() runtime.Object {
+func (in *DirectorySync) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -158,31 +434,31 @@ func (in *KubelabUser) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KubelabUserList) DeepCopyInto(out *KubelabUserList) {
+func (in *DirectorySyncList) DeepCopyInto(out *DirectorySyncList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]KubelabUser, len(*in))
+		*out = make([]DirectorySync, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubelabUserList.
-func (in *KubelabUserList) DeepCopy() *KubelabUserList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DirectorySyncList.
+func (in *DirectorySyncList) DeepCopy() *DirectorySyncList {
 	if in == nil {
 		return nil
 	}
-	out := new(KubelabUserList)
+	out := new(DirectorySyncList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *KubelabUserList) DeepCopyObject() runtime.Object {
+func (in *DirectorySyncList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -190,22 +466,40 @@ func (in *KubelabUserList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KubelabUserSpec) DeepCopyInto(out *KubelabUserSpec) {
+func (in *DirectorySyncSpec) DeepCopyInto(out *DirectorySyncSpec) {
 	*out = *in
+	if in.Keycloak != nil {
+		in, out := &in.Keycloak, &out.Keycloak
+		*out = new(KeycloakDirectory)
+		**out = **in
+	}
+	if in.LDAP != nil {
+		in, out := &in.LDAP, &out.LDAP
+		*out = new(LDAPDirectory)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Interval = in.Interval
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]DirectoryGroupMapping, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubelabUserSpec.
-func (in *KubelabUserSpec) DeepCopy() *KubelabUserSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DirectorySyncSpec.
+func (in *DirectorySyncSpec) DeepCopy() *DirectorySyncSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(KubelabUserSpec)
+	out := new(DirectorySyncSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KubelabUserStatus) DeepCopyInto(out *KubelabUserStatus) {
+func (in *DirectorySyncStatus) DeepCopyInto(out *DirectorySyncStatus) {
 	*out = *in
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
@@ -214,14 +508,846 @@ func (in *KubelabUserStatus) DeepCopyInto(out *KubelabUserStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DirectorySyncStatus.
+func (in *DirectorySyncStatus) DeepCopy() *DirectorySyncStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DirectorySyncStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnrollmentRequest) DeepCopyInto(out *EnrollmentRequest) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnrollmentRequest.
+func (in *EnrollmentRequest) DeepCopy() *EnrollmentRequest {
+	if in == nil {
+		return nil
+	}
+	out := new(EnrollmentRequest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EnrollmentRequest) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnrollmentRequestList) DeepCopyInto(out *EnrollmentRequestList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EnrollmentRequest, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubelabUserStatus.
-func (in *KubelabUserStatus) DeepCopy() *KubelabUserStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnrollmentRequestList.
+func (in *EnrollmentRequestList) DeepCopy() *EnrollmentRequestList {
 	if in == nil {
 		return nil
 	}
-	out := new(KubelabUserStatus)
+	out := new(EnrollmentRequestList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EnrollmentRequestList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnrollmentRequestSpec) DeepCopyInto(out *EnrollmentRequestSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnrollmentRequestSpec.
+func (in *EnrollmentRequestSpec) DeepCopy() *EnrollmentRequestSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EnrollmentRequestSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnrollmentRequestStatus) DeepCopyInto(out *EnrollmentRequestStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Approved != nil {
+		in, out := &in.Approved, &out.Approved
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnrollmentRequestStatus.
+func (in *EnrollmentRequestStatus) DeepCopy() *EnrollmentRequestStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EnrollmentRequestStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalIdentity) DeepCopyInto(out *ExternalIdentity) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalIdentity.
+func (in *ExternalIdentity) DeepCopy() *ExternalIdentity {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalIdentity)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GradingResult) DeepCopyInto(out *GradingResult) {
+	*out = *in
+	if in.ExitCode != nil {
+		in, out := &in.ExitCode, &out.ExitCode
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GradingResult.
+func (in *GradingResult) DeepCopy() *GradingResult {
+	if in == nil {
+		return nil
+	}
+	out := new(GradingResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GradingRun) DeepCopyInto(out *GradingRun) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GradingRun.
+func (in *GradingRun) DeepCopy() *GradingRun {
+	if in == nil {
+		return nil
+	}
+	out := new(GradingRun)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *GradingRun) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GradingRunList) DeepCopyInto(out *GradingRunList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]GradingRun, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GradingRunList.
+func (in *GradingRunList) DeepCopy() *GradingRunList {
+	if in == nil {
+		return nil
+	}
+	out := new(GradingRunList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *GradingRunList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GradingRunSpec) DeepCopyInto(out *GradingRunSpec) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GradingRunSpec.
+func (in *GradingRunSpec) DeepCopy() *GradingRunSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GradingRunSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GradingRunStatus) DeepCopyInto(out *GradingRunStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Results != nil {
+		in, out := &in.Results, &out.Results
+		*out = make([]GradingResult, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GradingRunStatus.
+func (in *GradingRunStatus) DeepCopy() *GradingRunStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(GradingRunStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeycloakDirectory) DeepCopyInto(out *KeycloakDirectory) {
+	*out = *in
+	out.CredentialsSecret = in.CredentialsSecret
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeycloakDirectory.
+func (in *KeycloakDirectory) DeepCopy() *KeycloakDirectory {
+	if in == nil {
+		return nil
+	}
+	out := new(KeycloakDirectory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubelabUser) DeepCopyInto(out *KubelabUser) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubelabUser.
+func (in *KubelabUser) DeepCopy() *KubelabUser {
+	if in == nil {
+		return nil
+	}
+	out := new(KubelabUser)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KubelabUser) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubelabUserList) DeepCopyInto(out *KubelabUserList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KubelabUser, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubelabUserList.
+func (in *KubelabUserList) DeepCopy() *KubelabUserList {
+	if in == nil {
+		return nil
+	}
+	out := new(KubelabUserList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KubelabUserList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubelabUserSpec) DeepCopyInto(out *KubelabUserSpec) {
+	*out = *in
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]UserRole, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExternalIdentity != nil {
+		in, out := &in.ExternalIdentity, &out.ExternalIdentity
+		*out = new(ExternalIdentity)
+		**out = **in
+	}
+	if in.Retention != nil {
+		in, out := &in.Retention, &out.Retention
+		*out = new(UserRetention)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubelabUserSpec.
+func (in *KubelabUserSpec) DeepCopy() *KubelabUserSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KubelabUserSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubelabUserStatus) DeepCopyInto(out *KubelabUserStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]UserRole, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubelabUserStatus.
+func (in *KubelabUserStatus) DeepCopy() *KubelabUserStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KubelabUserStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LDAPDirectory) DeepCopyInto(out *LDAPDirectory) {
+	*out = *in
+	if in.CredentialsSecret != nil {
+		in, out := &in.CredentialsSecret, &out.CredentialsSecret
+		*out = new(corev1.SecretReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LDAPDirectory.
+func (in *LDAPDirectory) DeepCopy() *LDAPDirectory {
+	if in == nil {
+		return nil
+	}
+	out := new(LDAPDirectory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabReset) DeepCopyInto(out *LabReset) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabReset.
+func (in *LabReset) DeepCopy() *LabReset {
+	if in == nil {
+		return nil
+	}
+	out := new(LabReset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabRestore) DeepCopyInto(out *LabRestore) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabRestore.
+func (in *LabRestore) DeepCopy() *LabRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(LabRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabRestore) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabRestoreList) DeepCopyInto(out *LabRestoreList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]LabRestore, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabRestoreList.
+func (in *LabRestoreList) DeepCopy() *LabRestoreList {
+	if in == nil {
+		return nil
+	}
+	out := new(LabRestoreList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabRestoreList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabRestoreSpec) DeepCopyInto(out *LabRestoreSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabRestoreSpec.
+func (in *LabRestoreSpec) DeepCopy() *LabRestoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LabRestoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabRestoreStatus) DeepCopyInto(out *LabRestoreStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabRestoreStatus.
+func (in *LabRestoreStatus) DeepCopy() *LabRestoreStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LabRestoreStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabSession) DeepCopyInto(out *LabSession) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSession.
+func (in *LabSession) DeepCopy() *LabSession {
+	if in == nil {
+		return nil
+	}
+	out := new(LabSession)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabSession) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabSessionList) DeepCopyInto(out *LabSessionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]LabSession, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSessionList.
+func (in *LabSessionList) DeepCopy() *LabSessionList {
+	if in == nil {
+		return nil
+	}
+	out := new(LabSessionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabSessionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabSessionSpec) DeepCopyInto(out *LabSessionSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSessionSpec.
+func (in *LabSessionSpec) DeepCopy() *LabSessionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LabSessionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabSessionStatus) DeepCopyInto(out *LabSessionStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSessionStatus.
+func (in *LabSessionStatus) DeepCopy() *LabSessionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LabSessionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabSnapshot) DeepCopyInto(out *LabSnapshot) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSnapshot.
+func (in *LabSnapshot) DeepCopy() *LabSnapshot {
+	if in == nil {
+		return nil
+	}
+	out := new(LabSnapshot)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabSnapshot) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabSnapshotList) DeepCopyInto(out *LabSnapshotList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]LabSnapshot, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSnapshotList.
+func (in *LabSnapshotList) DeepCopy() *LabSnapshotList {
+	if in == nil {
+		return nil
+	}
+	out := new(LabSnapshotList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabSnapshotList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabSnapshotSpec) DeepCopyInto(out *LabSnapshotSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSnapshotSpec.
+func (in *LabSnapshotSpec) DeepCopy() *LabSnapshotSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LabSnapshotSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabSnapshotStatus) DeepCopyInto(out *LabSnapshotStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSnapshotStatus.
+func (in *LabSnapshotStatus) DeepCopy() *LabSnapshotStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LabSnapshotStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabUsage) DeepCopyInto(out *LabUsage) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabUsage.
+func (in *LabUsage) DeepCopy() *LabUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(LabUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabUsage) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabUsageDay) DeepCopyInto(out *LabUsageDay) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabUsageDay.
+func (in *LabUsageDay) DeepCopy() *LabUsageDay {
+	if in == nil {
+		return nil
+	}
+	out := new(LabUsageDay)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabUsageList) DeepCopyInto(out *LabUsageList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]LabUsage, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabUsageList.
+func (in *LabUsageList) DeepCopy() *LabUsageList {
+	if in == nil {
+		return nil
+	}
+	out := new(LabUsageList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabUsageList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabUsageSpec) DeepCopyInto(out *LabUsageSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabUsageSpec.
+func (in *LabUsageSpec) DeepCopy() *LabUsageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LabUsageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabUsageStatus) DeepCopyInto(out *LabUsageStatus) {
+	*out = *in
+	if in.Since != nil {
+		in, out := &in.Since, &out.Since
+		*out = (*in).DeepCopy()
+	}
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]LabUsageDay, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabUsageStatus.
+func (in *LabUsageStatus) DeepCopy() *LabUsageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LabUsageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UserRetention) DeepCopyInto(out *UserRetention) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UserRetention.
+func (in *UserRetention) DeepCopy() *UserRetention {
+	if in == nil {
+		return nil
+	}
+	out := new(UserRetention)
 	in.DeepCopyInto(out)
 	return out
 }
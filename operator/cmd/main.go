@@ -24,15 +24,22 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	kubelabv1 "kubelab.local/kubelab/api/v1"
 	"kubelab.local/kubelab/internal/controller"
+	"kubelab.local/kubelab/internal/metrics"
+	"kubelab.local/kubelab/internal/usage"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -52,11 +59,16 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var labLimits controller.LabLimits
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&labLimits.PerStudent, "max-running-labs-per-student", 1,
+		"The number of labs a student may run at the same time, 0 means unlimited.")
+	flag.IntVar(&labLimits.Total, "max-running-labs", 0,
+		"The number of labs all students may run in the cluster at the same time, 0 means unlimited.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -72,6 +84,10 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "3711c3b9.kubelab.local",
+		// Only the secrets of the labs are cached, e.g. their SSH host keys, other secrets are read from the API server
+		NewCache: cache.BuilderWithOptions(cache.Options{SelectorsByObject: cache.SelectorsByObject{
+			&corev1.Secret{}: {Label: labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "KubelabClassroom"})},
+		}}),
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -90,21 +106,113 @@ func main() {
 	}
 
 	if err = (&controller.ClassroomReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("classroom-controller"),
+		APIReader: mgr.GetAPIReader(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Classroom")
 		os.Exit(1)
 	}
 	if err = (&controller.KubelabUserReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("kubelabuser-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "KubelabUser")
+		os.Exit(1)
+	}
+	if err = (&controller.AssignmentReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "KubelabUser")
+		setupLog.Error(err, "unable to create controller", "controller", "Assignment")
+		os.Exit(1)
+	}
+	if err = (&controller.GradingRunReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Clientset: kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "GradingRun")
+		os.Exit(1)
+	}
+	if err = (&controller.DirectorySyncReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		APIReader: mgr.GetAPIReader(),
+		Recorder:  mgr.GetEventRecorderFor("directorysync-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DirectorySync")
+		os.Exit(1)
+	}
+	if err = (&controller.EnrollmentRequestReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "EnrollmentRequest")
+		os.Exit(1)
+	}
+	if err = (&controller.RetainedVolumeReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RetainedVolume")
+		os.Exit(1)
+	}
+	if err = (&controller.LabSnapshotReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Config:    mgr.GetConfig(),
+		Clientset: kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LabSnapshot")
+		os.Exit(1)
+	}
+	if err = (&controller.LabRestoreReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Config:    mgr.GetConfig(),
+		Clientset: kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LabRestore")
+		os.Exit(1)
+	}
+	if err = (&controller.LabUsageReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LabUsage")
+		os.Exit(1)
+	}
+	if err = (&controller.LabLimitReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("lablimit-controller"),
+		Limits:   labLimits,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LabLimit")
+		os.Exit(1)
+	}
+	if err = (&controller.LabSessionReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("labsession-controller"),
+		Limits:   labLimits,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LabSession")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
 
+	// domain metrics are served next to the controller-runtime metrics
+	metrics.Register(crmetrics.Registry, mgr.GetClient())
+	// the usage report is served by the metrics server as well, so it is protected by the same proxy
+	if err := mgr.AddMetricsExtraHandler("/usage", usage.Handler(mgr.GetClient())); err != nil {
+		setupLog.Error(err, "unable to set up usage report")
+		os.Exit(1)
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -0,0 +1,225 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// AssignmentReconciler reconciles a Assignment object
+type AssignmentReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=assignments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=assignments/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=assignments/finalizers,verbs=update
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=classrooms,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=kubelabusers,verbs=get;list;watch
+
+//Custom RBAC
+//+kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;update;patch;delete
+
+func (r *AssignmentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Fetch the instance and check if it exist
+	assignment := &kubelabv1.Assignment{}
+	if err := r.Get(ctx, req.NamespacedName, assignment); err != nil {
+		if apierrors.IsNotFound(err) {
+			// If the custom resource is not found then, it usually means that it was deleted or not created
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get Assignment")
+		return ctrl.Result{}, err
+	}
+
+	// set the status as Unknown when no status are available
+	if assignment.Status.Conditions == nil || len(assignment.Status.Conditions) == 0 {
+		meta.SetStatusCondition(&assignment.Status.Conditions, metav1.Condition{Type: typeAvailable, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
+		if err := r.Status().Update(ctx, assignment); err != nil {
+			log.Error(err, "Failed to update assignment status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Jobs are owned by the assignment and get removed with it
+	if !assignment.ObjectMeta.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	// Check validity of the spec TO BE REPLACED WITH A VALIDATION WEBHOOK
+	if !isValidSource(assignment.Spec.Source) {
+		meta.SetStatusCondition(&assignment.Status.Conditions, metav1.Condition{Type: typeAvailable,
+			Status: metav1.ConditionFalse, Reason: "Validating",
+			Message: fmt.Sprintf("Source has to be a relative path inside the class share: %s", assignment.Spec.Source)})
+		if err := r.Status().Update(ctx, assignment); err != nil {
+			log.Error(err, "Failed to update assignment status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// The namespace of the assignment is the one of its classroom
+	classroom := &kubelabv1.Classroom{}
+	if err := r.Get(ctx, client.ObjectKey{Name: assignment.Namespace}, classroom); err != nil {
+		if apierrors.IsNotFound(err) {
+			meta.SetStatusCondition(&assignment.Status.Conditions, metav1.Condition{Type: typeAvailable,
+				Status: metav1.ConditionFalse, Reason: "Validating",
+				Message: fmt.Sprintf("Classroom does not exist: %s", assignment.Namespace)})
+			if err := r.Status().Update(ctx, assignment); err != nil {
+				log.Error(err, "Failed to update assignment status")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: time.Minute}, nil
+		}
+		log.Error(err, "Failed to get Classroom")
+		return ctrl.Result{}, err
+	}
+
+	isDue := !assignment.Spec.Due.IsZero() && !time.Now().Before(assignment.Spec.Due.Time)
+	enrolled, err := studentsOfClassroom(ctx, r.Client, classroom)
+	if err != nil {
+		log.Error(err, "Failed to select students of Classroom")
+		return ctrl.Result{}, err
+	}
+	students := make([]kubelabv1.AssignmentStudentStatus, 0, len(enrolled))
+	handedOut, collected := 0, 0
+
+	for _, student := range enrolled {
+		state := kubelabv1.AssignmentStudentStatus{Id: student.Spec.Id}
+
+		// Hand out the starter files, a job is only created once so later changes of the students are kept
+		job := &batchv1.Job{}
+		err := r.Get(ctx, types.NamespacedName{Name: jobName(assignment.Name, "handout", student.Spec.Id), Namespace: classroom.Name}, job)
+		if err != nil && apierrors.IsNotFound(err) {
+			job, err := r.handoutJobForAssignment(assignment, classroom, &student)
+			if err != nil {
+				log.Error(err, "Failed to define new hand out Job for Assignment")
+				return ctrl.Result{}, err
+			}
+
+			if err = r.Create(ctx, job); err != nil {
+				log.Error(err, "Failed to create new hand out Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+				return ctrl.Result{}, err
+			}
+			state.Message = "Handing out"
+		} else if err != nil {
+			log.Error(err, "Failed to get hand out Job")
+			return ctrl.Result{}, err
+		} else {
+			state.HandedOut, state.Message = jobState(job, "Handed out")
+		}
+
+		if state.HandedOut {
+			handedOut++
+		}
+
+		// Collect the work once the assignment is due
+		if isDue && state.HandedOut {
+			job := &batchv1.Job{}
+			err := r.Get(ctx, types.NamespacedName{Name: jobName(assignment.Name, "collect", student.Spec.Id), Namespace: classroom.Name}, job)
+			if err != nil && apierrors.IsNotFound(err) {
+				job, err := r.collectJobForAssignment(assignment, classroom, &student)
+				if err != nil {
+					log.Error(err, "Failed to define new collect Job for Assignment")
+					return ctrl.Result{}, err
+				}
+
+				if err = r.Create(ctx, job); err != nil {
+					log.Error(err, "Failed to create new collect Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+					return ctrl.Result{}, err
+				}
+				state.Message = "Collecting"
+			} else if err != nil {
+				log.Error(err, "Failed to get collect Job")
+				return ctrl.Result{}, err
+			} else {
+				state.Collected, state.Message = jobState(job, "Collected")
+			}
+		}
+
+		if state.Collected {
+			collected++
+		}
+		students = append(students, state)
+	}
+
+	assignment.Status.Students = students
+	meta.SetStatusCondition(&assignment.Status.Conditions, metav1.Condition{Type: typeAvailable,
+		Status: conditionStatus(handedOut == len(students)), Reason: "HandingOut",
+		Message: fmt.Sprintf("Handed out to %d of %d students", handedOut, len(students))})
+	if isDue {
+		meta.SetStatusCondition(&assignment.Status.Conditions, metav1.Condition{Type: typeCollected,
+			Status: conditionStatus(collected == len(students)), Reason: "Collecting",
+			Message: fmt.Sprintf("Collected from %d of %d students", collected, len(students))})
+	}
+
+	if err := r.Status().Update(ctx, assignment); err != nil {
+		log.Error(err, "Failed to update assignment status")
+		return ctrl.Result{}, err
+	}
+
+	// Jobs report back through the watch, only the due date needs a timer
+	if !isDue && !assignment.Spec.Due.IsZero() {
+		return ctrl.Result{RequeueAfter: time.Until(assignment.Spec.Due.Time)}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AssignmentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubelabv1.Assignment{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &kubelabv1.Classroom{}}, handler.EnqueueRequestsFromMapFunc(r.assignmentsForClassroom)).
+		Complete(r)
+}
+
+// assignmentsForClassroom enqueues all assignments of a classroom, so newly enrolled students get the starter files as well.
+func (r *AssignmentReconciler) assignmentsForClassroom(obj client.Object) []reconcile.Request {
+	assignmentList := &kubelabv1.AssignmentList{}
+	if err := r.List(context.Background(), assignmentList, client.InNamespace(obj.GetName())); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(assignmentList.Items))
+	for _, assignment := range assignmentList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: assignment.Name, Namespace: assignment.Namespace}})
+	}
+	return requests
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Scripts get the paths via environment variables, so the spec can not inject shell code
+const handoutScript = `set -e
+mkdir -p "/work/$ASSIGNMENT"
+case "$SOURCE" in
+  *.tar.gz|*.tgz) tar -xzf "/share/$SOURCE" -C "/work/$ASSIGNMENT" ;;
+  *.tar) tar -xf "/share/$SOURCE" -C "/work/$ASSIGNMENT" ;;
+  *) cp -rn "/share/$SOURCE/." "/work/$ASSIGNMENT/" ;;
+esac
+chmod -R a+rwX "/work/$ASSIGNMENT"`
+
+const collectScript = `set -e
+mkdir -p "/collected/$ASSIGNMENT/$STUDENT"
+cp -r "/work/$ASSIGNMENT/." "/collected/$ASSIGNMENT/$STUDENT/"`
+
+// labelsForAssignment returns the labels for selecting the resources.
+func labelsForAssignment(name string, student string) map[string]string {
+
+	return map[string]string{
+		"app.kubernetes.io/name":       "KubelabAssignment",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/version":    "1",
+		"app.kubernetes.io/part-of":    "assignment-operator",
+		"app.kubernetes.io/created-by": "controller-manager",
+		"assignment":                   name,
+		"student":                      student,
+	}
+}
+
+// handoutJobForAssignment returns a job copying the starter files into the workspace of the student.
+func (r *AssignmentReconciler) handoutJobForAssignment(assignment *kubelabv1.Assignment, classroom *kubelabv1.Classroom, student *kubelabv1.KubelabUser) (*batchv1.Job, error) {
+	volumes := []v1.Volume{
+		{
+			Name: "class-data",
+			VolumeSource: v1.VolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   nfsServer,
+					Path:     nfsPath + "/class/" + classroom.Name,
+					ReadOnly: true,
+				},
+			},
+		},
+		{
+			Name: "work-data",
+			VolumeSource: v1.VolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server: nfsServer,
+					Path:   nfsPath + "/work/" + classroom.Name + "/" + student.Spec.Id,
+				},
+			},
+		},
+	}
+	mounts := []v1.VolumeMount{
+		{
+			Name:      "class-data",
+			MountPath: "/share",
+			ReadOnly:  true,
+		},
+		{
+			Name:      "work-data",
+			MountPath: "/work",
+		},
+	}
+
+	return r.jobForAssignment(assignment, classroom, student, "handout", handoutScript, mounts, volumes)
+}
+
+// collectJobForAssignment returns a job copying the work of the student into the collected folder of the class.
+func (r *AssignmentReconciler) collectJobForAssignment(assignment *kubelabv1.Assignment, classroom *kubelabv1.Classroom, student *kubelabv1.KubelabUser) (*batchv1.Job, error) {
+	volumes := []v1.Volume{
+		{
+			Name: "work-data",
+			VolumeSource: v1.VolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   nfsServer,
+					Path:     nfsPath + "/work/" + classroom.Name + "/" + student.Spec.Id,
+					ReadOnly: true,
+				},
+			},
+		},
+		{
+			Name: "collected",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: claimNameCollected,
+				},
+			},
+		},
+	}
+	mounts := []v1.VolumeMount{
+		{
+			Name:      "work-data",
+			MountPath: "/work",
+			ReadOnly:  true,
+		},
+		{
+			Name:      "collected",
+			MountPath: "/collected",
+		},
+	}
+
+	return r.jobForAssignment(assignment, classroom, student, "collect", collectScript, mounts, volumes)
+}
+
+// jobForAssignment returns a job running the script inside the classroom namespace.
+func (r *AssignmentReconciler) jobForAssignment(assignment *kubelabv1.Assignment, classroom *kubelabv1.Classroom, student *kubelabv1.KubelabUser, action string, script string, mounts []v1.VolumeMount, volumes []v1.Volume) (*batchv1.Job, error) {
+	ls := labelsForAssignment(assignment.Name, student.Spec.Id)
+	backoffLimit := int32(3)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName(assignment.Name, action, student.Spec.Id),
+			Namespace: classroom.Name,
+			Labels:    ls,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Image:   jobImage,
+						Name:    action,
+						Command: []string{"sh", "-c", script},
+						Env: []v1.EnvVar{
+							{
+								Name:  "ASSIGNMENT",
+								Value: assignment.Name,
+							},
+							{
+								Name:  "SOURCE",
+								Value: strings.TrimSuffix(assignment.Spec.Source, "/"),
+							},
+							{
+								Name:  "STUDENT",
+								Value: student.Spec.Id,
+							},
+						},
+						VolumeMounts: mounts,
+					}},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(assignment, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
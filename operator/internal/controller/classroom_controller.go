@@ -20,42 +20,71 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	v1apps "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	v1rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	kubelabv1 "kubelab.local/kubelab/api/v1"
+	"kubelab.local/kubelab/internal/metrics"
 )
 
 // ClassroomReconciler reconciles a Classroom object
 type ClassroomReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Pull secrets are read without the cache, so the operator does not need to watch all secrets
+	APIReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=classrooms,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=classrooms/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=classrooms/finalizers,verbs=update
 //+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=kubelabusers,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=enrollmentrequests,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labrestores;labsnapshots,verbs=get;list;watch
 
 //Custom RBAC
 //+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups="apps",resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="apps",resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="networking.k8s.io",resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings;clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
+// to grant permissions to teachers the controller needs to have them as well
+//+kubebuilder:rbac:groups="apps",resources=deployments/scale;statefulsets/scale,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=pods;configmaps,verbs=get;list
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;delete
 
 func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
@@ -72,10 +101,11 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
-	// add label for later filtering
-	if classroom.Labels["teacher"] != classroom.Spec.Teacher.Spec.Id {
+	// add label of the owner for later filtering
+	staff := staffOfClassroom(classroom)
+	if owner := ownerOfClassroom(staff); classroom.Labels["teacher"] != owner {
 		classroom.Labels = make(map[string]string)
-		classroom.Labels["teacher"] = classroom.Spec.Teacher.Spec.Id
+		classroom.Labels["teacher"] = owner
 		if err := r.Update(ctx, classroom); err != nil {
 			log.Error(err, "Failed to update classroom")
 		}
@@ -107,6 +137,31 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		// The object is being deleted
 		if controllerutil.ContainsFinalizer(classroom, classroomFinalizer) {
 
+			// The data is archived before the namespace gets deleted, unless the deletion is forced
+			if classroom.Annotations[forceDeleteAnnotation] != "true" {
+				ns := &v1.Namespace{}
+				if err := r.Get(ctx, client.ObjectKey{Name: classroom.Name}, ns); err == nil {
+					archived, message, err := r.archiveClassroom(ctx, classroom)
+					if err != nil {
+						log.Error(err, "Failed to archive classroom")
+						return ctrl.Result{}, err
+					}
+					if !archived {
+						meta.SetStatusCondition(&classroom.Status.Conditions, metav1.Condition{Type: typeDegraded,
+							Status: metav1.ConditionUnknown, Reason: "Archiving",
+							Message: fmt.Sprintf("Deletion is blocked until the classroom is archived: %s", message)})
+						if err := r.Status().Update(ctx, classroom); err != nil {
+							log.Error(err, "Failed to update classroom status")
+							return ctrl.Result{}, err
+						}
+						return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+					}
+				} else if !apierrors.IsNotFound(err) {
+					log.Error(err, "Failed to get Namespace")
+					return ctrl.Result{}, err
+				}
+			}
+
 			// Let's add here an status "Degraded" to define that this resource begin its process to be terminated.
 			meta.SetStatusCondition(&classroom.Status.Conditions, metav1.Condition{Type: typeDegraded,
 				Status: metav1.ConditionUnknown, Reason: "Finalizing",
@@ -122,7 +177,7 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			ns := &v1.Namespace{}
 			err := r.Get(ctx, client.ObjectKey{Name: namespaceName}, ns)
 			if err == nil {
-				err = r.Delete(ctx, ns)
+				err = recordEvent(r.Recorder, classroom, "Delete", ns, r.Delete(ctx, ns))
 				if err != nil {
 					log.Error(err, "Failed to delete Namespace", "Namespace Name", namespaceName)
 					return ctrl.Result{}, err
@@ -155,25 +210,70 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, nil
 	}
 
+	// A classroom taken out of the archive gets archived again on the next archival
+	phase := phaseOfClassroom(classroom, time.Now())
+	if phase != classroomArchived && classroom.Status.ArchivedAt != nil {
+		job := &batchv1.Job{}
+		if err := r.Get(ctx, types.NamespacedName{Name: archiveJobName(classroom), Namespace: classroom.Name}, job); err == nil {
+			if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "Failed to delete archive Job")
+				return ctrl.Result{}, err
+			}
+		} else if !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to get archive Job")
+			return ctrl.Result{}, err
+		}
+		classroom.Status.ArchivedAt = nil
+		classroom.Status.Archive = ""
+		if err := r.Status().Update(ctx, classroom); err != nil {
+			log.Error(err, "Failed to update classroom status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
 	// Check validity of connected ressources TO BE REPLACED WITH A VALIDATION WEBHOOK
-	teacher := classroom.Spec.Teacher
-	students := classroom.Spec.EnrolledStudents
-	if teacher.Spec.Id == "" {
-		return ctrl.Result{RequeueAfter: time.Minute}, errors.New("teacher not set")
+	students, err := studentsOfClassroom(ctx, r.Client, classroom)
+	if err != nil {
+		log.Error(err, "Failed to select students")
+
+		meta.SetStatusCondition(&classroom.Status.Conditions, metav1.Condition{Type: typeAvailable,
+			Status: metav1.ConditionFalse, Reason: "Reconciling",
+			Message: fmt.Sprintf("Failed to select the students of the custom resource (%s): (%s)", classroom.Name, err)})
+
+		if err := r.Status().Update(ctx, classroom); err != nil {
+			log.Error(err, "Failed to update classroom status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, err
+	}
+	if ownerOfClassroom(staff) == "" {
+		err := errors.New("owner not set")
+		recordWarning(r.Recorder, classroom, "InvalidSpec", err.Error())
+		return ctrl.Result{RequeueAfter: time.Minute}, err
 	} else {
 		kubelabUserList := &kubelabv1.KubelabUserList{}
 		r.Client.List(ctx, kubelabUserList)
 
 		for i := 0; i < len(students); i++ {
 			if err := r.List(ctx, kubelabUserList, client.MatchingFields{userOwnerKey: students[i].Spec.Id}); err != nil || len(kubelabUserList.Items) == 0 {
-				return ctrl.Result{RequeueAfter: time.Minute}, errors.New("student does not exist: " + students[i].Spec.Id)
+				err := errors.New("student does not exist: " + students[i].Spec.Id)
+				recordWarning(r.Recorder, classroom, "InvalidSpec", err.Error())
+				return ctrl.Result{RequeueAfter: time.Minute}, err
 			}
 		}
 
-		if err := r.List(ctx, kubelabUserList, client.MatchingFields{userOwnerKey: teacher.Spec.Id}); err != nil || len(kubelabUserList.Items) == 0 {
-			return ctrl.Result{RequeueAfter: time.Minute}, errors.New("teacher does not exist: " + teacher.Spec.Id)
-		} else if !kubelabUserList.Items[0].Spec.IsTeacher {
-			return ctrl.Result{RequeueAfter: time.Minute}, errors.New("user is not a teacher: " + teacher.Spec.Id)
+		// assistants do not need to be teachers, so tutors can be students of other classes
+		for _, member := range staff {
+			if err := r.List(ctx, kubelabUserList, client.MatchingFields{userOwnerKey: member.Id}); err != nil || len(kubelabUserList.Items) == 0 {
+				err := errors.New("staff member does not exist: " + member.Id)
+				recordWarning(r.Recorder, classroom, "InvalidSpec", err.Error())
+				return ctrl.Result{RequeueAfter: time.Minute}, err
+			} else if member.Role != staffAssistant && !hasRole(rolesOfUser(&kubelabUserList.Items[0]), userRoleTeacher) {
+				err := errors.New("user is not a teacher: " + member.Id)
+				recordWarning(r.Recorder, classroom, "InvalidSpec", err.Error())
+				return ctrl.Result{RequeueAfter: time.Minute}, err
+			}
 		}
 	}
 
@@ -199,7 +299,7 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			return ctrl.Result{}, err
 		}
 
-		if err = r.Create(ctx, ns); err != nil {
+		if err = recordEvent(r.Recorder, classroom, "Create", ns, r.Create(ctx, ns)); err != nil {
 			log.Error(err, "Failed to create new Namespace", "Namespace Name", ns.Namespace)
 			return ctrl.Result{}, err
 		}
@@ -212,29 +312,115 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
+	// Archived classrooms only keep their data, the labs are removed once the archive is written
+	if phase == classroomArchived {
+		if _, err := r.reconcilePrePull(ctx, classroom, phase); err != nil {
+			log.Error(err, "Failed to remove pre-pull DaemonSet")
+			return ctrl.Result{}, err
+		}
+		if _, err := r.reconcileWarmPool(ctx, classroom, phase, nil, nil); err != nil {
+			log.Error(err, "Failed to remove warm pool")
+			return ctrl.Result{}, err
+		}
+		archived, message, err := r.archiveClassroom(ctx, classroom)
+		if err != nil {
+			log.Error(err, "Failed to archive classroom")
+			return ctrl.Result{}, err
+		}
+		if archived {
+			if err := r.releaseLabs(ctx, classroom); err != nil {
+				log.Error(err, "Failed to remove the labs of the archived classroom")
+				return ctrl.Result{}, err
+			}
+		}
+
+		classroom.Status.Phase = phase
+		meta.SetStatusCondition(&classroom.Status.Conditions, metav1.Condition{Type: typeAvailable,
+			Status: conditionStatus(archived), Reason: "Archiving",
+			Message: message})
+		if err := r.Status().Update(ctx, classroom); err != nil {
+			log.Error(err, "Failed to update status")
+			return ctrl.Result{}, err
+		}
+		if !archived {
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Students get their labs once the classroom is no draft anymore
+	labStudents := students
+	if phase == classroomDraft {
+		labStudents = nil
+	}
+
+	// Labs with a reset annotation are recreated, their workspace is wiped before they start again
+	resetting, err := r.resetLabs(ctx, classroom, labStudents)
+	if err != nil {
+		log.Error(err, "Failed to reset labs")
+		return ctrl.Result{}, err
+	}
+
+	// The namespaces are shared with other classrooms, so their security profiles are needed for the namespace labels
+	classrooms := &kubelabv1.ClassroomList{}
+	if err := r.List(ctx, classrooms); err != nil {
+		log.Error(err, "Failed to list classrooms")
+		return ctrl.Result{}, err
+	}
+
+	// The template container is pulled before the classroom starts
+	prePullIn, err := r.reconcilePrePull(ctx, classroom, phase)
+	if err != nil {
+		log.Error(err, "Failed to reconcile pre-pull DaemonSet")
+		return ctrl.Result{}, err
+	}
+	// Warm pods of stopped labs are adopted by their labs on start, so the students do not wait for a node and the image
+	if classroom.Status.WarmPool, err = r.reconcileWarmPool(ctx, classroom, phase, labStudents, resetting); err != nil {
+		log.Error(err, "Failed to reconcile warm pool")
+		return ctrl.Result{}, err
+	}
+
+	// The restricted profile needs a template, which runs without root, the staff is warned about labs failing to start
+	if err := r.warnUnsupportedProfile(ctx, classroom); err != nil {
+		log.Error(err, "Failed to check the labs of the restricted profile")
+		return ctrl.Result{}, err
+	}
+
+	// Students whose lab can not start because the quota of their namespace is exhausted
+	var quotaExceededIds []string
+
 	// Do operations for all students
-	for _, student := range students {
-		// Check if the deployment already exists, if not create a new one
-		deployment := &v1apps.Deployment{}
-		err := r.Get(ctx, types.NamespacedName{Name: classroom.Name, Namespace: student.Spec.Id}, deployment)
-		if err != nil && apierrors.IsNotFound(err) {
+	for _, student := range labStudents {
+		if resetting[student.Spec.Id] {
+			continue
+		}
 
-			// fetch full student object
-			studentList := &kubelabv1.KubelabUserList{}
-			if err := r.List(ctx, studentList, client.MatchingFields{userOwnerKey: student.Spec.Id}); err != nil || len(studentList.Items) == 0 {
-				return ctrl.Result{}, errors.New("unable to find Student")
-			}
+		if err := r.ensureNamespaceSecurity(ctx, classroom, student.Spec.Id, podSecurityLevelOfUser(classroom, classrooms.Items, student.Spec.Id)); err != nil {
+			log.Error(err, "Failed to set the pod security of the namespace", "Namespace", student.Spec.Id)
+			return ctrl.Result{}, err
+		}
+		if err := r.ensurePullSecrets(ctx, classroom, student.Spec.Id); err != nil {
+			log.Error(err, "Failed to copy the image pull secrets", "Namespace", student.Spec.Id)
+			return ctrl.Result{}, err
+		}
+		if err := r.ensureHostKeys(ctx, classroom, &student); err != nil {
+			log.Error(err, "Failed to create the SSH host key", "Namespace", student.Spec.Id)
+			return ctrl.Result{}, err
+		}
 
-			// Define a new deployment
-			dep, err := r.deploymentForClassroom(classroom, &studentList.Items[0])
+		// Check if the workspace already exists, if not create a new one
+		workspace := &v1.PersistentVolumeClaim{}
+		err := r.Get(ctx, types.NamespacedName{Name: workspaceClaimName(classroom), Namespace: student.Spec.Id}, workspace)
+		if err != nil && apierrors.IsNotFound(err) {
+			// Define a new PVC
+			claim, err := r.persistentVolumeClaimForWorkspace(classroom, &student)
 			// If failing write Error inside Status
 			if err != nil {
-				log.Error(err, "Failed to define new Deployment resource for Classroom")
+				log.Error(err, "Failed to define new workspace PVC resource for Classroom")
 
-				// The following implementation will update the status
 				meta.SetStatusCondition(&classroom.Status.Conditions, metav1.Condition{Type: typeAvailable,
 					Status: metav1.ConditionFalse, Reason: "Reconciling",
-					Message: fmt.Sprintf("Failed to create Deployment for the custom resource (%s): (%s)", classroom.Name, err)})
+					Message: fmt.Sprintf("Failed to create workspace PVC for the custom resource (%s): (%s)", classroom.Name, err)})
 
 				if err := r.Status().Update(ctx, classroom); err != nil {
 					log.Error(err, "Failed to update status")
@@ -244,42 +430,44 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 				return ctrl.Result{}, err
 			}
 
-			if err = r.Create(ctx, dep); err != nil {
-				log.Error(err, "Failed to create new Deployment",
-					"Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+			if err = recordEvent(r.Recorder, classroom, "Create", claim, r.Create(ctx, claim)); err != nil {
+				log.Error(err, "Failed to create new workspace PVC",
+					"PVC.Namespace", claim.Namespace, "PVC.Name", claim.Name)
 				return ctrl.Result{}, err
 			}
 
 			// Reque to check if everything is alright
 			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 		} else if err != nil {
-			log.Error(err, "Failed to get Deployment")
+			log.Error(err, "Failed to get workspace PVC")
 			return ctrl.Result{}, err
 		}
 
-		// If the image gets changed in the CRD all deployments need to exchange theirs as well
-		image := classroom.Spec.TemplateContainer
-		// important: the call only works on the first image, so multiple images are currently not supported
-		if deployment.Spec.Template.Spec.Containers[0].Image != image {
-			deployment.Spec.Template.Spec.Containers[0].Image = image
-			if err = r.Update(ctx, deployment); err != nil {
-				log.Error(err, "Failed to update Deployment", "Deployment.Namespace", deployment.Namespace, "Deployment.Name", deployment.Name)
-
-				// The following implementation will update the status
-				meta.SetStatusCondition(&classroom.Status.Conditions, metav1.Condition{Type: typeDegraded,
-					Status: metav1.ConditionFalse, Reason: "Changing Image",
-					Message: fmt.Sprintf("Failed to update the image for the custom resource (%s): (%s)", classroom.Name, err)})
-
-				if err := r.Status().Update(ctx, classroom); err != nil {
-					log.Error(err, "Failed to update status")
-					return ctrl.Result{}, err
-				}
+		// fetch full student object
+		studentList := &kubelabv1.KubelabUserList{}
+		if err := r.List(ctx, studentList, client.MatchingFields{userOwnerKey: student.Spec.Id}); err != nil || len(studentList.Items) == 0 {
+			return ctrl.Result{}, errors.New("unable to find Student")
+		}
 
+		lab, result, err := r.reconcileLab(ctx, classroom, &studentList.Items[0], "")
+		if err != nil || !result.IsZero() {
+			return result, err
+		}
+		if lab != nil {
+			observeLab(classroom, lab)
+			quota, err := quotaOfLab(ctx, r.Client, lab)
+			if err != nil {
+				log.Error(err, "Failed to get ResourceQuota", "Namespace", student.Spec.Id)
 				return ctrl.Result{}, err
 			}
-
-			// Now, that we update the image we want to requeue the reconciliation
-			return ctrl.Result{Requeue: true}, nil
+			if message := quotaExceeded(lab, quota); message != "" && labRunning(lab) {
+				quotaExceededIds = append(quotaExceededIds, student.Spec.Id)
+				// the warning is only recorded once, not on every reconcile
+				if !containsString(classroom.Status.QuotaExceeded, student.Spec.Id) {
+					recordWarning(r.Recorder, classroom, "QuotaExceeded",
+						fmt.Sprintf("Lab of %s exceeds the quota of the namespace: %s", student.Spec.Id, message))
+				}
+			}
 		}
 
 		// Check if the svc already exists, if not create a new one
@@ -305,7 +493,7 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 				return ctrl.Result{}, err
 			}
 
-			if err = r.Create(ctx, svc); err != nil {
+			if err = recordEvent(r.Recorder, classroom, "Create", svc, r.Create(ctx, svc)); err != nil {
 				log.Error(err, "Failed to create new Deployment",
 					"Deployment.Namespace", svc.Namespace, "SVC.Name", svc.Name)
 				return ctrl.Result{}, err
@@ -318,6 +506,33 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			return ctrl.Result{}, err
 		}
 
+		// Give the staff of the classroom access to the lab of the student
+		for _, staffRole := range staffRoles {
+			role, err := r.roleForClassroomStaff(classroom, &student, staffRole)
+			if err != nil {
+				log.Error(err, "Failed to define new staff Role resource for Classroom", "Role", staffRole)
+				return ctrl.Result{}, err
+			}
+			if changed, err := r.ensureRole(ctx, classroom, role); err != nil {
+				log.Error(err, "Failed to reconcile staff Role", "Role.Namespace", role.Namespace, "Role.Name", role.Name)
+				return ctrl.Result{}, err
+			} else if changed {
+				return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+			}
+
+			roleBinding, err := r.roleBindingForClassroomStaff(classroom, student.Spec.Id, staffRole)
+			if err != nil {
+				log.Error(err, "Failed to define new staff Rolebinding resource for Classroom", "Role", staffRole)
+				return ctrl.Result{}, err
+			}
+			if changed, err := r.ensureRoleBinding(ctx, classroom, roleBinding); err != nil {
+				log.Error(err, "Failed to reconcile staff Rolebinding", "Rolebinding.Namespace", roleBinding.Namespace, "Rolebinding.Name", roleBinding.Name)
+				return ctrl.Result{}, err
+			} else if changed {
+				return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+			}
+		}
+
 		np := &networkingv1.NetworkPolicy{}
 		isExam := strings.ToLower(classroom.Spec.EnableExamMode) == "true"
 		err = r.Get(ctx, types.NamespacedName{Name: classroom.Name, Namespace: student.Spec.Id}, np)
@@ -341,7 +556,7 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 				return ctrl.Result{}, err
 			}
 
-			if err = r.Create(ctx, np); err != nil {
+			if err = recordEvent(r.Recorder, classroom, "Create", np, r.Create(ctx, np)); err != nil {
 				log.Error(err, "Failed to create new NP")
 				return ctrl.Result{}, err
 			}
@@ -349,7 +564,7 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			// Reque to check if everything is alright
 			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 		} else if !isExam && err == nil { // check if it is not exam and it is found -> delete
-			if err := r.Delete(ctx, np); err != nil {
+			if err := recordEvent(r.Recorder, classroom, "Delete", np, r.Delete(ctx, np)); err != nil {
 				log.Error(err, "unable to delete network policy")
 				return ctrl.Result{}, err
 			} else {
@@ -363,24 +578,70 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	}
 
-	// delete if student is removed
-	deploymentList := &v1apps.DeploymentList{}
-	if err := r.List(ctx, deploymentList, client.MatchingFields{classroomOwnerKey: classroom.Name}); err != nil {
-		log.Error(err, "unable to list all child deployments")
-		return ctrl.Result{}, err
-	} else {
-		for _, deploy := range deploymentList.Items {
-			if !isInClass(students, deploy) {
-				if err := r.Delete(ctx, &deploy); err != nil {
-					log.Error(err, "unable to delete old deployment")
-					return ctrl.Result{}, err
-				} else {
-					return ctrl.Result{}, err
-				}
+	// Every member of the staff gets an own lab to maintain the class share and read the submissions
+	for _, member := range staff {
+		teacherList := &kubelabv1.KubelabUserList{}
+		if err := r.List(ctx, teacherList, client.MatchingFields{userOwnerKey: member.Id}); err != nil || len(teacherList.Items) == 0 {
+			return ctrl.Result{}, errors.New("unable to find staff member: " + member.Id)
+		}
+		teacher := &teacherList.Items[0]
+
+		if err := r.ensureNamespaceSecurity(ctx, classroom, member.Id, podSecurityLevelOfUser(classroom, classrooms.Items, member.Id)); err != nil {
+			log.Error(err, "Failed to set the pod security of the namespace", "Namespace", member.Id)
+			return ctrl.Result{}, err
+		}
+		if err := r.ensurePullSecrets(ctx, classroom, member.Id); err != nil {
+			log.Error(err, "Failed to copy the image pull secrets", "Namespace", member.Id)
+			return ctrl.Result{}, err
+		}
+		if err := r.ensureHostKeys(ctx, classroom, teacher); err != nil {
+			log.Error(err, "Failed to create the SSH host key", "Namespace", member.Id)
+			return ctrl.Result{}, err
+		}
+
+		teacherLab, result, err := r.reconcileLab(ctx, classroom, teacher, member.Role)
+		if err != nil || !result.IsZero() {
+			return result, err
+		}
+		if teacherLab != nil {
+			observeLab(classroom, teacherLab)
+		}
+
+		teacherService := &v1.Service{}
+		err = r.Get(ctx, types.NamespacedName{Name: classroom.Name, Namespace: member.Id}, teacherService)
+		if err != nil && apierrors.IsNotFound(err) {
+			svc, err := r.serviceForClassroom(classroom, teacher)
+			if err != nil {
+				log.Error(err, "Failed to define new teacher SVC resource for Classroom")
+				return ctrl.Result{}, err
+			}
+
+			if err = recordEvent(r.Recorder, classroom, "Create", svc, r.Create(ctx, svc)); err != nil {
+				log.Error(err, "Failed to create new teacher SVC",
+					"SVC.Namespace", svc.Namespace, "SVC.Name", svc.Name)
+				return ctrl.Result{}, err
 			}
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		} else if err != nil {
+			log.Error(err, "Failed to get teacher SVC")
+			return ctrl.Result{}, err
 		}
 	}
 
+	// delete if student is removed
+	if deleted, err := r.cleanupLabs(ctx, classroom, phase, students, staff); err != nil {
+		log.Error(err, "unable to delete or stop old labs")
+		return ctrl.Result{}, err
+	} else if deleted {
+		return ctrl.Result{}, nil
+	}
+
+	// The staff loses the access to the namespaces of removed students
+	if err := r.revokeStaffRoles(ctx, classroom, students); err != nil {
+		log.Error(err, "Failed to delete staff Roles of removed students")
+		return ctrl.Result{}, err
+	}
+
 	// Check if the claim already exists, if not create a new claim
 	claim := &v1.PersistentVolumeClaim{}
 	if err := r.Get(ctx, client.ObjectKey{Name: claimNameClass, Namespace: classroom.Name}, claim); err != nil && apierrors.IsNotFound(err) {
@@ -402,7 +663,7 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			return ctrl.Result{}, err
 		}
 
-		if err = r.Create(ctx, claim); err != nil {
+		if err = recordEvent(r.Recorder, classroom, "Create", claim, r.Create(ctx, claim)); err != nil {
 			log.Error(err, "Failed to create new PVC")
 			return ctrl.Result{}, err
 		}
@@ -413,7 +674,108 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
+	// Check if the claim for collected work already exists, if not create a new claim
+	collected := &v1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, client.ObjectKey{Name: claimNameCollected, Namespace: classroom.Name}, collected); err != nil && apierrors.IsNotFound(err) {
+		claim, err := r.persistentVolumeClaimForCollected(classroom)
+		if err != nil {
+			log.Error(err, "Failed to define new collected PVC resource for classroom")
+			return ctrl.Result{}, err
+		}
+
+		if err = recordEvent(r.Recorder, classroom, "Create", claim, r.Create(ctx, claim)); err != nil {
+			log.Error(err, "Failed to create new collected PVC")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get collected PVC")
+		return ctrl.Result{}, err
+	}
+
+	// Teachers may not change the classroom, their settings are taken from the ConfigMap inside the classroom namespace
+	if changed, err := r.syncStaffSettings(ctx, classroom); err != nil {
+		log.Error(err, "Failed to sync settings of the staff")
+		return ctrl.Result{}, err
+	} else if changed {
+		return ctrl.Result{}, nil
+	}
+
+	// The staff may only see this classroom and the jobs and results inside its namespace
+	for _, staffRole := range staffRoles {
+		classRole, err := r.roleForClassNamespace(classroom, staffRole)
+		if err != nil {
+			log.Error(err, "Failed to define new staff Role resource for Classroom", "Role", staffRole)
+			return ctrl.Result{}, err
+		}
+		if changed, err := r.ensureRole(ctx, classroom, classRole); err != nil {
+			log.Error(err, "Failed to reconcile staff Role", "Role.Namespace", classRole.Namespace, "Role.Name", classRole.Name)
+			return ctrl.Result{}, err
+		} else if changed {
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+
+		classRoleBinding, err := r.roleBindingForClassroomStaff(classroom, classroom.Name, staffRole)
+		if err != nil {
+			log.Error(err, "Failed to define new staff Rolebinding resource for Classroom", "Role", staffRole)
+			return ctrl.Result{}, err
+		}
+		if changed, err := r.ensureRoleBinding(ctx, classroom, classRoleBinding); err != nil {
+			log.Error(err, "Failed to reconcile staff Rolebinding", "Rolebinding.Namespace", classRoleBinding.Namespace, "Rolebinding.Name", classRoleBinding.Name)
+			return ctrl.Result{}, err
+		} else if changed {
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+
+		clusterRole, err := r.clusterRoleForClassroom(classroom, staffRole)
+		if err != nil {
+			log.Error(err, "Failed to define new ClusterRole resource for Classroom", "Role", staffRole)
+			return ctrl.Result{}, err
+		}
+		if changed, err := r.ensureClusterRole(ctx, classroom, clusterRole); err != nil {
+			log.Error(err, "Failed to reconcile ClusterRole", "ClusterRole.Name", clusterRole.Name)
+			return ctrl.Result{}, err
+		} else if changed {
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+
+		clusterRoleBinding, err := r.clusterRoleBindingForClassroom(classroom, staffRole)
+		if err != nil {
+			log.Error(err, "Failed to define new ClusterRolebinding resource for Classroom", "Role", staffRole)
+			return ctrl.Result{}, err
+		}
+		if changed, err := r.ensureClusterRoleBinding(ctx, classroom, clusterRoleBinding); err != nil {
+			log.Error(err, "Failed to reconcile ClusterRolebinding", "ClusterRolebinding.Name", clusterRoleBinding.Name)
+			return ctrl.Result{}, err
+		} else if changed {
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+	}
+
+	// The ClusterRole of the single teacher is replaced by the roles of the staff
+	for _, legacy := range []client.Object{&v1rbac.ClusterRoleBinding{}, &v1rbac.ClusterRole{}} {
+		if err := r.Get(ctx, client.ObjectKey{Name: legacyClusterRoleName(classroom)}, legacy); err == nil {
+			if err := recordEvent(r.Recorder, classroom, "Delete", legacy, r.Delete(ctx, legacy)); err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "Failed to delete old teacher ClusterRole", "Name", legacy.GetName())
+				return ctrl.Result{}, err
+			}
+		} else if !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to get old teacher ClusterRole")
+			return ctrl.Result{}, err
+		}
+	}
+
+	pending, err := r.pendingEnrollments(ctx, classroom)
+	if err != nil {
+		log.Error(err, "Failed to list EnrollmentRequests")
+		return ctrl.Result{}, err
+	}
+
 	// The following implementation will update the status
+	classroom.Status.Students = studentIds(students)
+	classroom.Status.PendingEnrollments = pending
+	classroom.Status.Phase = phase
+	classroom.Status.QuotaExceeded = quotaExceededIds
 	meta.SetStatusCondition(&classroom.Status.Conditions, metav1.Condition{Type: typeAvailable,
 		Status: metav1.ConditionTrue, Reason: "Reconciling",
 		Message: fmt.Sprintf("Everything for custom resource (%s) created successfully", classroom.Name)})
@@ -423,33 +785,1053 @@ func (r *ClassroomReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
+	if len(resetting) > 0 {
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	}
+	// the start and end date change the phase without an update of the classroom
+	if next := nextPhaseChange(classroom, time.Now()); next > 0 {
+		if prePullIn > 0 && prePullIn < next {
+			next = prePullIn
+		}
+		return ctrl.Result{RequeueAfter: next}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *ClassroomReconciler) SetupWithManager(mgr ctrl.Manager) error {
+// syncStaffSettings syncs the template and the exam mode between the settings ConfigMap and the classroom and reports if the classroom was changed.
+// The values of the last sync are kept in annotations of the ConfigMap, so a change of the teachers is copied into the classroom
+// and a change of the owner into the ConfigMap. If both changed the same value, the one of the teachers is kept.
+func (r *ClassroomReconciler) syncStaffSettings(ctx context.Context, classroom *kubelabv1.Classroom) (bool, error) {
+	settings := &v1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Name: staffSettingsName, Namespace: classroom.Name}, settings); err != nil && apierrors.IsNotFound(err) {
+		cm, err := r.configMapForStaffSettings(classroom)
+		if err != nil {
+			return false, err
+		}
+		return false, recordEvent(r.Recorder, classroom, "Create", cm, r.Create(ctx, cm))
+	} else if err != nil {
+		return false, err
+	}
+	if settings.Data == nil {
+		settings.Data = map[string]string{}
+	}
+	if settings.Annotations == nil {
+		settings.Annotations = map[string]string{}
+	}
 
-	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1apps.Deployment{}, classroomOwnerKey, func(rawObj client.Object) []string {
-		deploy := rawObj.(*v1apps.Deployment)
-		return []string{deploy.Name}
-	}); err != nil {
-		return err
+	classroomChanged, settingsChanged := false, false
+	for key, value := range map[string]*string{
+		"templateContainer": &classroom.Spec.TemplateContainer,
+		"enableExamMode":    &classroom.Spec.EnableExamMode,
+	} {
+		synced, ok := settings.Annotations[staffSettingsSyncedPrefix+key]
+		if !ok {
+			// ConfigMaps of older versions were never synced back, their values are taken like before
+			synced = *value
+		}
+		staff, set := settings.Data[key]
+		// a lab without a template can not start, an empty template of the teachers is not copied
+		set = set && (staff != "" || key != "templateContainer")
+		switch {
+		case set && staff != synced && staff != *value:
+			*value = staff
+			classroomChanged = true
+		case *value != staff:
+			settings.Data[key] = *value
+			settingsChanged = true
+		}
+		if settings.Annotations[staffSettingsSyncedPrefix+key] != *value {
+			settings.Annotations[staffSettingsSyncedPrefix+key] = *value
+			settingsChanged = true
+		}
+	}
+	if settingsChanged {
+		if err := recordEvent(r.Recorder, classroom, "Update", settings, r.Update(ctx, settings)); err != nil {
+			return false, err
+		}
+	}
+	if !classroomChanged {
+		return false, nil
 	}
+	return true, r.Update(ctx, classroom)
+}
 
-	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &kubelabv1.KubelabUser{}, userOwnerKey, func(rawObj client.Object) []string {
-		user := rawObj.(*kubelabv1.KubelabUser)
-		return []string{user.Spec.Id}
-	}); err != nil {
-		return err
+// pendingEnrollments returns the requests waiting for approval, the staff may only read the requests of their classroom and can not list them
+func (r *ClassroomReconciler) pendingEnrollments(ctx context.Context, classroom *kubelabv1.Classroom) ([]string, error) {
+	if classroom.Spec.Enrollment == nil || !classroom.Spec.Enrollment.RequireApproval {
+		return nil, nil
+	}
+	requestList := &kubelabv1.EnrollmentRequestList{}
+	if err := r.List(ctx, requestList); err != nil {
+		return nil, err
+	}
+	pending := []string{}
+	for _, request := range requestList.Items {
+		if request.Spec.Classroom == classroom.Name && request.Status.Phase == enrollmentPending && request.Status.Approved == nil {
+			pending = append(pending, request.Namespace+"/"+request.Name)
+		}
 	}
+	return pending, nil
+}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&kubelabv1.Classroom{}).
-		Owns(&kubelabv1.KubelabUser{}).
-		Owns(&v1apps.Deployment{}).
-		Owns(&v1.Namespace{}).
-		Owns(&v1.Service{}).
-		Owns(&v1.PersistentVolumeClaim{}).
-		Owns(&networkingv1.NetworkPolicy{}).
-		Complete(r)
+// archiveClassroom writes the class share, the workspaces and the collected work into the archive.
+// It reports if the archive was written and a message describing the progress.
+func (r *ClassroomReconciler) archiveClassroom(ctx context.Context, classroom *kubelabv1.Classroom) (bool, string, error) {
+	if classroom.Status.ArchivedAt != nil {
+		return true, fmt.Sprintf("Archived to %s", classroom.Status.Archive), nil
+	}
+
+	claim := &v1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, client.ObjectKey{Name: claimNameArchive, Namespace: classroom.Name}, claim); err != nil && apierrors.IsNotFound(err) {
+		claim, err := r.persistentVolumeClaimForArchive(classroom)
+		if err != nil {
+			return false, "", err
+		}
+		return false, "Creating archive PVC", r.Create(ctx, claim)
+	} else if err != nil {
+		return false, "", err
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: archiveJobName(classroom), Namespace: classroom.Name}, job); err != nil && apierrors.IsNotFound(err) {
+		job, err := r.jobForArchive(classroom)
+		if err != nil {
+			return false, "", err
+		}
+		return false, "Archiving", r.Create(ctx, job)
+	} else if err != nil {
+		return false, "", err
+	}
+
+	archived, message := jobState(job, "Archived")
+	if !archived {
+		return false, message, nil
+	}
+	// the archive outlives the classroom and its namespace
+	if retained, err := r.retainArchive(ctx, classroom, claim); err != nil || !retained {
+		return false, "Retaining archive volume", err
+	}
+	classroom.Status.ArchivedAt = &metav1.Time{Time: time.Now()}
+	classroom.Status.Archive = archivePath(classroom, job)
+	return true, fmt.Sprintf("Archived to %s", classroom.Status.Archive), nil
+}
+
+// retainArchive keeps the volume of the archive claim if the claim or the namespace of the classroom are deleted.
+func (r *ClassroomReconciler) retainArchive(ctx context.Context, classroom *kubelabv1.Classroom, claim *v1.PersistentVolumeClaim) (bool, error) {
+	if claim.Spec.VolumeName == "" {
+		return false, nil
+	}
+	pv := &v1.PersistentVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: claim.Spec.VolumeName}, pv); err != nil {
+		return false, err
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy == v1.PersistentVolumeReclaimRetain && pv.Labels[archivedClassroomLabel] == classroom.Name {
+		return true, nil
+	}
+	pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+	if pv.Labels == nil {
+		pv.Labels = map[string]string{}
+	}
+	pv.Labels[archivedClassroomLabel] = classroom.Name
+	return true, recordEvent(r.Recorder, classroom, "Update", pv, r.Update(ctx, pv))
+}
+
+// reconcileLab creates the lab of the user or applies the changes of the classroom to it, role is empty for students and the role of the staff otherwise.
+// A non-zero result is returned after a change, so the lab is checked again. Labs still running in the Deployment of an older version
+// are kept until they are stopped and replaced by a StatefulSet then, so an upgrade restarts no lab. No lab is returned for them.
+func (r *ClassroomReconciler) reconcileLab(ctx context.Context, classroom *kubelabv1.Classroom, user *kubelabv1.KubelabUser, role string) (*v1apps.StatefulSet, ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if running, err := r.migrateLab(ctx, classroom, user.Spec.Id); err != nil || running {
+		return nil, ctrl.Result{}, err
+	}
+
+	lab := &v1apps.StatefulSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: classroom.Name, Namespace: user.Spec.Id}, lab)
+	if err != nil && apierrors.IsNotFound(err) {
+		// Define a new lab
+		var desired *v1apps.StatefulSet
+		if role == "" {
+			desired, err = r.statefulSetForClassroom(classroom, user)
+		} else {
+			desired, err = r.statefulSetForTeacher(classroom, user, role)
+		}
+		// If failing write Error inside Status
+		if err != nil {
+			log.Error(err, "Failed to define new StatefulSet resource for Classroom")
+
+			// The following implementation will update the status
+			meta.SetStatusCondition(&classroom.Status.Conditions, metav1.Condition{Type: typeAvailable,
+				Status: metav1.ConditionFalse, Reason: "Reconciling",
+				Message: fmt.Sprintf("Failed to create StatefulSet for the custom resource (%s): (%s)", classroom.Name, err)})
+
+			if err := r.Status().Update(ctx, classroom); err != nil {
+				log.Error(err, "Failed to update status")
+				return nil, ctrl.Result{}, err
+			}
+
+			return nil, ctrl.Result{}, err
+		}
+
+		if err = recordEvent(r.Recorder, classroom, "Create", desired, r.Create(ctx, desired)); err != nil {
+			log.Error(err, "Failed to create new StatefulSet",
+				"StatefulSet.Namespace", desired.Namespace, "StatefulSet.Name", desired.Name)
+			return nil, ctrl.Result{}, err
+		}
+
+		// Reque to check if everything is alright
+		return nil, ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get StatefulSet")
+		return nil, ctrl.Result{}, err
+	}
+
+	// If the image gets changed in the CRD all labs need to exchange theirs as well
+	image := classroom.Spec.TemplateContainer
+	// important: the call only works on the first image, so multiple images are currently not supported
+	if lab.Spec.Template.Spec.Containers[0].Image != image {
+		lab.Spec.Template.Spec.Containers[0].Image = image
+		if err = recordEvent(r.Recorder, classroom, "Update", lab, r.Update(ctx, lab)); err != nil {
+			log.Error(err, "Failed to update StatefulSet", "StatefulSet.Namespace", lab.Namespace, "StatefulSet.Name", lab.Name)
+
+			// The following implementation will update the status
+			meta.SetStatusCondition(&classroom.Status.Conditions, metav1.Condition{Type: typeDegraded,
+				Status: metav1.ConditionFalse, Reason: "Changing Image",
+				Message: fmt.Sprintf("Failed to update the image for the custom resource (%s): (%s)", classroom.Name, err)})
+
+			if err := r.Status().Update(ctx, classroom); err != nil {
+				log.Error(err, "Failed to update status")
+				return nil, ctrl.Result{}, err
+			}
+
+			return nil, ctrl.Result{}, err
+		}
+
+		// Now, that we update the image we want to requeue the reconciliation
+		return nil, ctrl.Result{Requeue: true}, nil
+	}
+
+	// The class share of the staff follows their role, assistants only read it
+	var mounts []v1.VolumeMount
+	var volumes []v1.Volume
+	outdated := false
+	if role == "" {
+		mounts, volumes = volumesForStudent(classroom, user)
+		outdated = !hasVolume(lab, "work-data")
+	} else {
+		mounts, volumes = volumesForTeacher(classroom, user, role)
+		outdated = !hasVolume(lab, "collected") || hasReadOnlyVolume(lab, "class-data") != (role == staffAssistant)
+	}
+	if outdated {
+		lab.Spec.Template.Spec.Containers[0].VolumeMounts = mounts
+		lab.Spec.Template.Spec.Volumes = volumes
+		if err = recordEvent(r.Recorder, classroom, "Update", lab, r.Update(ctx, lab)); err != nil {
+			log.Error(err, "Failed to update StatefulSet volumes", "StatefulSet.Namespace", lab.Namespace, "StatefulSet.Name", lab.Name)
+			return nil, ctrl.Result{}, err
+		}
+		return nil, ctrl.Result{Requeue: true}, nil
+	}
+
+	// A changed security profile, placement or image pull is applied to the existing labs
+	desired := lab.Spec.Template.Spec.DeepCopy()
+	applySecurityProfile(classroom, desired)
+	applyPlacement(classroom, desired)
+	applyImagePull(classroom, desired)
+	applyHostKeys(classroom, desired)
+	if !equality.Semantic.DeepEqual(*desired, lab.Spec.Template.Spec) {
+		lab.Spec.Template.Spec = *desired
+		if err = recordEvent(r.Recorder, classroom, "Update", lab, r.Update(ctx, lab)); err != nil {
+			log.Error(err, "Failed to update StatefulSet", "StatefulSet.Namespace", lab.Namespace, "StatefulSet.Name", lab.Name)
+			return nil, ctrl.Result{}, err
+		}
+		return nil, ctrl.Result{Requeue: true}, nil
+	}
+	return lab, ctrl.Result{}, nil
+}
+
+// migrateLab deletes the Deployment of a lab created by an older version once the lab is stopped, so it is replaced by a StatefulSet.
+// It reports if the Deployment is still running, running labs are not restarted by the upgrade.
+func (r *ClassroomReconciler) migrateLab(ctx context.Context, classroom *kubelabv1.Classroom, id string) (bool, error) {
+	deployment := &v1apps.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: classroom.Name, Namespace: id}, deployment); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(deployment, classroom) {
+		return false, nil
+	}
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > 0 || deployment.Status.Replicas > 0 {
+		return true, nil
+	}
+	return false, recordEvent(r.Recorder, classroom, "Delete", deployment, r.Delete(ctx, deployment))
+}
+
+// ensureNamespaceSecurity sets the Pod Security Admission labels of the namespace of the user.
+// Restricted labs mount the NFS share, which the restricted level forbids, so it is only warned about.
+func (r *ClassroomReconciler) ensureNamespaceSecurity(ctx context.Context, classroom *kubelabv1.Classroom, id string, level string) error {
+	ns := &v1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: id}, ns); err != nil {
+		// the namespace is created by the user controller
+		return client.IgnoreNotFound(err)
+	}
+	enforce := level
+	if enforce == "restricted" {
+		enforce = "baseline"
+	}
+	if ns.Labels[podSecurityEnforce] == enforce && ns.Labels[podSecurityWarn] == level {
+		return nil
+	}
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	ns.Labels[podSecurityEnforce] = enforce
+	ns.Labels[podSecurityWarn] = level
+	return recordEvent(r.Recorder, classroom, "Update", ns, r.Update(ctx, ns))
+}
+
+// ensurePullSecrets copies the image pull secrets of the classroom from the namespace pullSecretNamespace into the namespace of the user.
+// Only registry secrets with the label pullSecretSourceLabel are shared, and only the copies of the operator are updated.
+func (r *ClassroomReconciler) ensurePullSecrets(ctx context.Context, classroom *kubelabv1.Classroom, id string) error {
+	for _, name := range classroom.Spec.ImagePullSecrets {
+		source := &v1.Secret{}
+		if err := r.APIReader.Get(ctx, client.ObjectKey{Name: name, Namespace: pullSecretNamespace}, source); err != nil {
+			if apierrors.IsNotFound(err) {
+				recordWarning(r.Recorder, classroom, "InvalidSpec", fmt.Sprintf("Image pull secret %s does not exist in namespace %s", name, pullSecretNamespace))
+				continue
+			}
+			return err
+		}
+		if source.Type != v1.SecretTypeDockerConfigJson || source.Labels[pullSecretSourceLabel] != "true" {
+			recordWarning(r.Recorder, classroom, "InvalidSpec", fmt.Sprintf("Secret %s in namespace %s is no shared image pull secret", name, pullSecretNamespace))
+			continue
+		}
+
+		secret := &v1.Secret{}
+		err := r.APIReader.Get(ctx, client.ObjectKey{Name: name, Namespace: id}, secret)
+		if err != nil && apierrors.IsNotFound(err) {
+			secret = pullSecretForUser(source, id)
+			if err := recordEvent(r.Recorder, classroom, "Create", secret, r.Create(ctx, secret)); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if secret.Labels[pullSecretLabel] != "true" {
+			recordWarning(r.Recorder, classroom, "InvalidSpec", fmt.Sprintf("Secret %s in namespace %s is not managed by the operator and was not replaced", name, id))
+		} else if !equality.Semantic.DeepEqual(secret.Data, source.Data) {
+			// rotated credentials are copied again
+			secret.Data = source.Data
+			if err := recordEvent(r.Recorder, classroom, "Update", secret, r.Update(ctx, secret)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensurePrePullNamespace creates the locked down namespace of the pre-pull DaemonSets
+// and copies the image pull secrets of the classroom into it.
+func (r *ClassroomReconciler) ensurePrePullNamespace(ctx context.Context, classroom *kubelabv1.Classroom) error {
+	ns := &v1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: prePullNamespace}, ns); err != nil && apierrors.IsNotFound(err) {
+		ns = namespaceForPrePull()
+		if err := recordEvent(r.Recorder, classroom, "Create", ns, r.Create(ctx, ns)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	np := &networkingv1.NetworkPolicy{}
+	if err := r.Get(ctx, client.ObjectKey{Name: "deny-all", Namespace: prePullNamespace}, np); err != nil && apierrors.IsNotFound(err) {
+		np = networkPolicyForPrePull()
+		if err := recordEvent(r.Recorder, classroom, "Create", np, r.Create(ctx, np)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return r.ensurePullSecrets(ctx, classroom, prePullNamespace)
+}
+
+// deleteFromOperatorNamespace removes a pre-pull DaemonSet or the placeholders of a warm pool created by older versions in the namespace of the operator
+func (r *ClassroomReconciler) deleteFromOperatorNamespace(ctx context.Context, classroom *kubelabv1.Classroom, obj client.Object) error {
+	if err := r.Get(ctx, client.ObjectKey{Name: obj.GetName(), Namespace: operatorNamespace}, obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, classroom) {
+		return nil
+	}
+	return recordEvent(r.Recorder, classroom, "Delete", obj, r.Delete(ctx, obj))
+}
+
+// reconcilePrePull runs the pre-pull DaemonSet from prePullLead before the start date until the classroom is closed.
+// It returns the duration until the pre-pull starts, 0 if it already started or is not wanted.
+func (r *ClassroomReconciler) reconcilePrePull(ctx context.Context, classroom *kubelabv1.Classroom, phase string) (time.Duration, error) {
+	var startIn time.Duration
+	if start := classroom.Spec.StartDate; classroom.Spec.PrePull && phase == classroomDraft && start != nil {
+		startIn = time.Until(start.Add(-prePullLead))
+	}
+	wanted := classroom.Spec.PrePull && (phase == classroomActive || (phase == classroomDraft && startIn <= 0 && classroom.Spec.StartDate != nil))
+
+	desired, err := r.daemonSetForPrePull(classroom)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.deleteFromOperatorNamespace(ctx, classroom, &v1apps.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: desired.Name}}); err != nil {
+		return 0, err
+	}
+	if wanted {
+		if err := r.ensurePrePullNamespace(ctx, classroom); err != nil {
+			return 0, err
+		}
+	}
+	daemonSet := &v1apps.DaemonSet{}
+	err = r.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, daemonSet)
+	switch {
+	case err != nil && !apierrors.IsNotFound(err):
+		return 0, err
+	case err != nil && wanted:
+		return 0, recordEvent(r.Recorder, classroom, "Create", desired, r.Create(ctx, desired))
+	case err == nil && !wanted:
+		return 0, recordEvent(r.Recorder, classroom, "Delete", daemonSet, r.Delete(ctx, daemonSet))
+	case err == nil && pullTemplateOutdated(&daemonSet.Spec.Template.Spec, &desired.Spec.Template.Spec):
+		daemonSet.Spec.Template = desired.Spec.Template
+		return 0, recordEvent(r.Recorder, classroom, "Update", daemonSet, r.Update(ctx, daemonSet))
+	}
+	if startIn > 0 {
+		return startIn, nil
+	}
+	return 0, nil
+}
+
+// reconcileWarmPool keeps warm pods for the stopped labs of the first students while the classroom is active and returns the number of ready ones.
+// A warm pod is started from the pod template of the lab with the volumes of the student and handed over to the lab once it is started,
+// so the student does not wait for a node, the image and the volumes. Labs being reset or restored get no warm pod, their workspace is changed.
+func (r *ClassroomReconciler) reconcileWarmPool(ctx context.Context, classroom *kubelabv1.Classroom, phase string, students []kubelabv1.KubelabUser, resetting map[string]bool) (int32, error) {
+	wanted := classroom.Spec.WarmPool > 0 && phase == classroomActive
+
+	// older versions only reserved the capacity with placeholders, which can not become labs
+	placeholders := &v1apps.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: classroom.Name + "-warm", Namespace: prePullNamespace}, placeholders); err == nil && metav1.IsControlledBy(placeholders, classroom) {
+		if err := recordEvent(r.Recorder, classroom, "Delete", placeholders, r.Delete(ctx, placeholders)); err != nil && !apierrors.IsNotFound(err) {
+			return 0, err
+		}
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return 0, err
+	}
+	if err := r.deleteFromOperatorNamespace(ctx, classroom, &v1apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: classroom.Name + "-warm"}}); err != nil {
+		return 0, err
+	}
+
+	restoring, err := r.restoringStudents(ctx, classroom)
+	if err != nil {
+		return 0, err
+	}
+	enrolled := map[string]bool{}
+	for _, student := range students {
+		enrolled[student.Spec.Id] = !resetting[student.Spec.Id] && !restoring[student.Spec.Id]
+	}
+
+	podList := &v1.PodList{}
+	if err := r.List(ctx, podList, client.MatchingLabels{"app.kubernetes.io/name": "KubelabWarmPool", "class": classroom.Name}); err != nil {
+		return 0, err
+	}
+	warm := map[string]bool{}
+	var ready int32
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !metav1.IsControlledBy(pod, classroom) || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		lab := &v1apps.StatefulSet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: classroom.Name, Namespace: pod.Namespace}, lab); err != nil && !apierrors.IsNotFound(err) {
+			return 0, err
+		} else if err != nil {
+			lab = nil
+		}
+		switch {
+		case lab == nil || !enrolled[pod.Namespace] || pod.Annotations[warmPodRevisionAnnotation] != lab.Status.UpdateRevision:
+			// the lab is gone, changed or its workspace is about to be changed
+			if err := recordEvent(r.Recorder, classroom, "Delete", pod, r.Delete(ctx, pod)); err != nil && !apierrors.IsNotFound(err) {
+				return 0, err
+			}
+		case labRunning(lab):
+			if err := r.adoptWarmPod(ctx, classroom, lab, pod); err != nil {
+				return 0, err
+			}
+		case !wanted:
+			if err := recordEvent(r.Recorder, classroom, "Delete", pod, r.Delete(ctx, pod)); err != nil && !apierrors.IsNotFound(err) {
+				return 0, err
+			}
+		default:
+			warm[pod.Namespace] = true
+			for _, condition := range pod.Status.Conditions {
+				if condition.Type == v1.PodReady && condition.Status == v1.ConditionTrue {
+					ready++
+				}
+			}
+		}
+	}
+
+	for _, student := range students {
+		if !wanted || int32(len(warm)) >= classroom.Spec.WarmPool {
+			break
+		}
+		if warm[student.Spec.Id] || !enrolled[student.Spec.Id] {
+			continue
+		}
+		lab := &v1apps.StatefulSet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: classroom.Name, Namespace: student.Spec.Id}, lab); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return 0, err
+		}
+		// the revision is only known once the StatefulSet controller has seen the lab
+		if labRunning(lab) || lab.Status.Replicas > 0 || lab.Status.UpdateRevision == "" {
+			continue
+		}
+		pod, err := r.podForWarmPool(classroom, lab)
+		if err != nil {
+			return 0, err
+		}
+		// the old pod of the lab may still be terminating or the quota of the student may be exhausted, the next lab is warmed instead
+		if err := r.Create(ctx, pod); apierrors.IsAlreadyExists(err) || apierrors.IsForbidden(err) {
+			continue
+		} else if err := recordEvent(r.Recorder, classroom, "Create", pod, err); err != nil {
+			return 0, err
+		}
+		warm[student.Spec.Id] = true
+	}
+	return ready, nil
+}
+
+// adoptWarmPod hands the warm pod over to the started lab. The pod gets the labels of the pods of the StatefulSet and loses its owner,
+// so the StatefulSet adopts the running pod with its name instead of creating a new one.
+func (r *ClassroomReconciler) adoptWarmPod(ctx context.Context, classroom *kubelabv1.Classroom, lab *v1apps.StatefulSet, pod *v1.Pod) error {
+	ls := map[string]string{}
+	for key, value := range lab.Spec.Template.Labels {
+		ls[key] = value
+	}
+	// a pod of another revision would be replaced by the StatefulSet
+	ls[v1apps.ControllerRevisionHashLabelKey] = lab.Status.UpdateRevision
+	ls[v1apps.StatefulSetPodNameLabel] = pod.Name
+	pod.Labels = ls
+	pod.OwnerReferences = nil
+	return recordEvent(r.Recorder, classroom, "Update", pod, r.Update(ctx, pod))
+}
+
+// restoringStudents returns the students whose lab is rolled back by a LabRestore in the namespace of the classroom
+func (r *ClassroomReconciler) restoringStudents(ctx context.Context, classroom *kubelabv1.Classroom) (map[string]bool, error) {
+	restoring := map[string]bool{}
+	restoreList := &kubelabv1.LabRestoreList{}
+	if err := r.List(ctx, restoreList, client.InNamespace(classroom.Name)); err != nil {
+		return nil, err
+	}
+	for _, restore := range restoreList.Items {
+		if restore.Status.Phase == restoreRestored || restore.Status.Phase == snapshotFailed {
+			continue
+		}
+		snapshot := &kubelabv1.LabSnapshot{}
+		if err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.Snapshot, Namespace: restore.Namespace}, snapshot); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		restoring[snapshot.Spec.Student] = true
+	}
+	return restoring, nil
+}
+
+// ensureHostKeys creates the SSH host key of the lab of the user once, it is kept when the lab is reset.
+// The cache of the manager only holds the secrets of the labs, see main.go.
+func (r *ClassroomReconciler) ensureHostKeys(ctx context.Context, classroom *kubelabv1.Classroom, user *kubelabv1.KubelabUser) error {
+	secret := &v1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Name: hostKeysSecretName(classroom), Namespace: user.Spec.Id}, secret)
+	if err == nil || !apierrors.IsNotFound(err) {
+		return err
+	}
+	secret, err = r.secretForHostKeys(classroom, user)
+	if err != nil {
+		return err
+	}
+	return recordEvent(r.Recorder, classroom, "Create", secret, r.Create(ctx, secret))
+}
+
+// resetLabs deletes the lab pods of the students with a reset annotation, so they are recreated with a fresh container.
+// If the workspace is reset as well, the lab is scaled down, the workspace is wiped by a Job and the lab is scaled up again.
+// It returns the students whose reset is still running, their labs must not be created yet.
+func (r *ClassroomReconciler) resetLabs(ctx context.Context, classroom *kubelabv1.Classroom, students []kubelabv1.KubelabUser) (map[string]bool, error) {
+	resetting := map[string]bool{}
+	finished := []kubelabv1.LabReset{}
+	for key, mode := range classroom.Annotations {
+		if !strings.HasPrefix(key, resetAnnotationPrefix) {
+			continue
+		}
+		reset := kubelabv1.LabReset{Student: strings.TrimPrefix(key, resetAnnotationPrefix), Workspace: mode == resetWorkspace, Time: metav1.Now()}
+		if !isEnrolled(students, reset.Student) {
+			reset.Message = "No lab to reset"
+			finished = append(finished, reset)
+			continue
+		}
+
+		lab, err := r.labOfUser(ctx, classroom, reset.Student)
+		if err != nil {
+			return nil, err
+		}
+		if !reset.Workspace {
+			// the pod is recreated by the StatefulSet, which keeps its number of replicas
+			if err := r.deleteLabPods(ctx, classroom, reset.Student); err != nil {
+				return nil, err
+			}
+		} else {
+			// the workspace may only be wiped once the container stopped writing into it,
+			// the lab is scaled down meanwhile and gets its replicas back afterwards
+			if stopping, err := r.stopLabForReset(ctx, classroom, lab); err != nil {
+				return nil, err
+			} else if stopping {
+				resetting[reset.Student] = true
+				continue
+			}
+			podList := &v1.PodList{}
+			if err := r.List(ctx, podList, client.InNamespace(reset.Student), labPodSelector(classroom.Name, reset.Student)); err != nil {
+				return nil, err
+			}
+			if len(podList.Items) > 0 {
+				resetting[reset.Student] = true
+				continue
+			}
+			done, message, err := r.wipeWorkspace(ctx, classroom, reset.Student)
+			if err != nil {
+				return nil, err
+			}
+			if !done {
+				resetting[reset.Student] = true
+				continue
+			}
+			if err := r.startLabAfterReset(ctx, classroom, lab); err != nil {
+				return nil, err
+			}
+			reset.Message = message
+		}
+		if reset.Message == "" {
+			reset.Message = "Reset"
+		}
+		finished = append(finished, reset)
+	}
+	if len(finished) == 0 {
+		return resetting, nil
+	}
+
+	// the annotations are removed first, since the update replaces the status with the stored one
+	for _, reset := range finished {
+		delete(classroom.Annotations, resetAnnotationPrefix+reset.Student)
+	}
+	if err := r.Update(ctx, classroom); err != nil {
+		return nil, err
+	}
+	for _, reset := range finished {
+		// successful resets are recorded by the events of the deleted pod or the scaled lab
+		if reset.Message != "Reset" {
+			recordWarning(r.Recorder, classroom, "LabReset", fmt.Sprintf("Lab of %s: %s", reset.Student, reset.Message))
+		}
+
+		resets := []kubelabv1.LabReset{}
+		for _, last := range classroom.Status.Resets {
+			if last.Student != reset.Student {
+				resets = append(resets, last)
+			}
+		}
+		classroom.Status.Resets = append(resets, reset)
+	}
+	if err := r.Status().Update(ctx, classroom); err != nil {
+		return nil, err
+	}
+	return resetting, nil
+}
+
+// observeLab records the time to ready of the lab from the scale-up marked by the LabLimit controller and keeps the last one in the status
+func observeLab(classroom *kubelabv1.Classroom, lab *v1apps.StatefulSet) {
+	// the time is zero until the scale-up is marked
+	started, _ := time.Parse(time.RFC3339Nano, lab.Annotations[labStartedAnnotation])
+	if ready, ok := metrics.ObserveLab(lab, poolOfClassroom(classroom), started); ok {
+		classroom.Status.LabReadySeconds = int32(ready.Seconds())
+	}
+}
+
+// warnUnsupportedProfile records a warning for every lab of a restricted classroom, whose container can not be created or keeps crashing.
+// The template of the classroom is checked by starting it, e.g. the default image needs root to create the user and to configure sshd.
+func (r *ClassroomReconciler) warnUnsupportedProfile(ctx context.Context, classroom *kubelabv1.Classroom) error {
+	if securityProfileOfClassroom(classroom) != securityRestricted {
+		return nil
+	}
+	podList := &v1.PodList{}
+	if err := r.List(ctx, podList, client.MatchingLabels{"app.kubernetes.io/name": "KubelabClassroom", "class": classroom.Name}); err != nil {
+		return err
+	}
+	for _, pod := range podList.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if waiting := status.State.Waiting; waiting != nil && (waiting.Reason == "CreateContainerConfigError" || waiting.Reason == "CrashLoopBackOff") {
+				recordWarning(r.Recorder, classroom, "SecurityProfileUnsupported", fmt.Sprintf(
+					"Lab of %s does not start with the restricted profile, the template has to run as user %d without root: %s %s",
+					pod.Labels["student"], restrictedLabUser, waiting.Reason, waiting.Message))
+			}
+		}
+	}
+	return nil
+}
+
+// deleteLabPods deletes the pods of the lab of the student, so they are recreated with a fresh container.
+func (r *ClassroomReconciler) deleteLabPods(ctx context.Context, classroom *kubelabv1.Classroom, student string) error {
+	podList := &v1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(student), labPodSelector(classroom.Name, student)); err != nil {
+		return err
+	}
+	for i := range podList.Items {
+		if err := recordEvent(r.Recorder, classroom, "Delete", &podList.Items[i], r.Delete(ctx, &podList.Items[i])); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopLabForReset scales the lab down and keeps its replicas in an annotation.
+// It reports if the lab was scaled down right now.
+func (r *ClassroomReconciler) stopLabForReset(ctx context.Context, classroom *kubelabv1.Classroom, lab client.Object) (bool, error) {
+	if lab == nil {
+		return false, nil
+	}
+	annotations := lab.GetAnnotations()
+	if _, ok := annotations[resetReplicasAnnotation]; ok {
+		return false, nil
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	replicas := replicasOfLab(lab)
+	annotations[resetReplicasAnnotation] = strconv.Itoa(int(*replicas))
+	lab.SetAnnotations(annotations)
+	*replicas = 0
+	return true, recordEvent(r.Recorder, classroom, "Update", lab, r.Update(ctx, lab))
+}
+
+// startLabAfterReset scales the lab back to the replicas it had before the reset.
+func (r *ClassroomReconciler) startLabAfterReset(ctx context.Context, classroom *kubelabv1.Classroom, lab client.Object) error {
+	if lab == nil {
+		return nil
+	}
+	annotations := lab.GetAnnotations()
+	value, ok := annotations[resetReplicasAnnotation]
+	if !ok {
+		return nil
+	}
+	scaled, err := strconv.Atoi(value)
+	if err != nil {
+		scaled = 0
+	}
+	*replicasOfLab(lab) = int32(scaled)
+	delete(annotations, resetReplicasAnnotation)
+	lab.SetAnnotations(annotations)
+	return recordEvent(r.Recorder, classroom, "Update", lab, r.Update(ctx, lab))
+}
+
+// labOfUser returns the StatefulSet of the lab of the user, the Deployment of a lab created by an older version or nil if the user has no lab
+func (r *ClassroomReconciler) labOfUser(ctx context.Context, classroom *kubelabv1.Classroom, id string) (client.Object, error) {
+	key := types.NamespacedName{Name: classroom.Name, Namespace: id}
+	lab := &v1apps.StatefulSet{}
+	if err := r.Get(ctx, key, lab); err == nil {
+		return lab, nil
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	deployment := &v1apps.Deployment{}
+	if err := r.Get(ctx, key, deployment); err == nil && metav1.IsControlledBy(deployment, classroom) {
+		return deployment, nil
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// wipeWorkspace empties the workspace of the student with a Job.
+// It reports if the Job finished and a message describing the result.
+func (r *ClassroomReconciler) wipeWorkspace(ctx context.Context, classroom *kubelabv1.Classroom, student string) (bool, string, error) {
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: jobName(classroom.Name, "reset", student), Namespace: student}, job); err != nil && apierrors.IsNotFound(err) {
+		job, err := r.jobForWorkspaceReset(classroom, student)
+		if err != nil {
+			return false, "", err
+		}
+		return false, "", r.Create(ctx, job)
+	} else if err != nil {
+		return false, "", err
+	}
+
+	message := "Reset"
+	if condition := failedCondition(job); condition != nil {
+		message = "Failed to wipe the workspace: " + condition.Message
+	} else if job.Status.Succeeded == 0 {
+		return false, "", nil
+	}
+	// the Job is removed, so the next reset runs a new one
+	if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+		return false, "", err
+	}
+	return true, message, nil
+}
+
+// releaseLabs removes the labs, services and network policies of all labs of the classroom, the volumes are kept.
+func (r *ClassroomReconciler) releaseLabs(ctx context.Context, classroom *kubelabv1.Classroom) error {
+	labs, err := r.labsOfClassroom(ctx, classroom)
+	if err != nil {
+		return err
+	}
+
+	for _, lab := range labs {
+		key := types.NamespacedName{Name: classroom.Name, Namespace: lab.GetNamespace()}
+		for _, obj := range []client.Object{&v1.Service{}, &networkingv1.NetworkPolicy{}} {
+			if err := r.Get(ctx, key, obj); err == nil {
+				if err := recordEvent(r.Recorder, classroom, "Delete", obj, r.Delete(ctx, obj)); err != nil && !apierrors.IsNotFound(err) {
+					return err
+				}
+			} else if !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+		if err := recordEvent(r.Recorder, classroom, "Delete", lab, r.Delete(ctx, lab)); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupLabs deletes the labs of removed students and stops the labs, which may not run in the phase of the classroom.
+// Closed classrooms keep all labs stopped, Draft classrooms keep the labs of their students stopped until they start again.
+func (r *ClassroomReconciler) cleanupLabs(ctx context.Context, classroom *kubelabv1.Classroom, phase string, students []kubelabv1.KubelabUser, staff []kubelabv1.ClassroomStaff) (bool, error) {
+	labs, err := r.labsOfClassroom(ctx, classroom)
+	if err != nil {
+		return false, err
+	}
+	for _, lab := range labs {
+		staffLab := isStaff(staff, lab.GetNamespace())
+		if !isEnrolled(students, lab.GetNamespace()) && !staffLab {
+			return true, recordEvent(r.Recorder, classroom, "Delete", lab, r.Delete(ctx, lab))
+		}
+		stopped := phase == classroomClosed || (phase == classroomDraft && !staffLab)
+		if replicas := replicasOfLab(lab); stopped && *replicas != 0 {
+			*replicas = 0
+			if err := recordEvent(r.Recorder, classroom, "Update", lab, r.Update(ctx, lab)); err != nil {
+				return false, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// labsOfClassroom returns the StatefulSets of the labs of the classroom and the Deployments of labs created by older versions
+func (r *ClassroomReconciler) labsOfClassroom(ctx context.Context, classroom *kubelabv1.Classroom) ([]client.Object, error) {
+	labs := []client.Object{}
+	statefulSetList := &v1apps.StatefulSetList{}
+	if err := r.List(ctx, statefulSetList, client.MatchingFields{classroomOwnerKey: classroom.Name}); err != nil {
+		return nil, err
+	}
+	for i := range statefulSetList.Items {
+		labs = append(labs, &statefulSetList.Items[i])
+	}
+	deploymentList := &v1apps.DeploymentList{}
+	if err := r.List(ctx, deploymentList, client.MatchingFields{classroomOwnerKey: classroom.Name}); err != nil {
+		return nil, err
+	}
+	for i := range deploymentList.Items {
+		if metav1.IsControlledBy(&deploymentList.Items[i], classroom) {
+			labs = append(labs, &deploymentList.Items[i])
+		}
+	}
+	return labs, nil
+}
+
+// revokeStaffRoles deletes the roles and rolebindings of the staff inside the namespaces of students who left the classroom.
+func (r *ClassroomReconciler) revokeStaffRoles(ctx context.Context, classroom *kubelabv1.Classroom, students []kubelabv1.KubelabUser) error {
+	roleList := &v1rbac.RoleList{}
+	if err := r.List(ctx, roleList, client.MatchingLabels{"app.kubernetes.io/name": "KubelabClassroom", "class": classroom.Name}); err != nil {
+		return err
+	}
+
+	for i := range roleList.Items {
+		role := &roleList.Items[i]
+		if role.Namespace == classroom.Name || isEnrolled(students, role.Namespace) {
+			continue
+		}
+		// the rolebinding has the name of the role
+		rb := &v1rbac.RoleBinding{}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(role), rb); err == nil {
+			if err := recordEvent(r.Recorder, classroom, "Delete", rb, r.Delete(ctx, rb)); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		} else if !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err := recordEvent(r.Recorder, classroom, "Delete", role, r.Delete(ctx, role)); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureRole creates the role or updates its rules if they drifted and reports if anything changed.
+// Both are recorded as an Event of the classroom.
+func (r *ClassroomReconciler) ensureRole(ctx context.Context, classroom *kubelabv1.Classroom, desired *v1rbac.Role) (bool, error) {
+	role := &v1rbac.Role{}
+	err := r.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, role)
+	if err != nil && apierrors.IsNotFound(err) {
+		return true, recordEvent(r.Recorder, classroom, "Create", desired, r.Create(ctx, desired))
+	} else if err != nil {
+		return false, err
+	}
+
+	if equality.Semantic.DeepEqual(role.Rules, desired.Rules) {
+		return false, nil
+	}
+	role.Rules = desired.Rules
+	return true, recordEvent(r.Recorder, classroom, "Update", role, r.Update(ctx, role))
+}
+
+// ensureRoleBinding creates the rolebinding or updates its subjects if they drifted and reports if anything changed.
+func (r *ClassroomReconciler) ensureRoleBinding(ctx context.Context, classroom *kubelabv1.Classroom, desired *v1rbac.RoleBinding) (bool, error) {
+	roleBinding := &v1rbac.RoleBinding{}
+	err := r.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, roleBinding)
+	if err != nil && apierrors.IsNotFound(err) {
+		return true, recordEvent(r.Recorder, classroom, "Create", desired, r.Create(ctx, desired))
+	} else if err != nil {
+		return false, err
+	}
+
+	if equality.Semantic.DeepEqual(roleBinding.Subjects, desired.Subjects) {
+		return false, nil
+	}
+	roleBinding.Subjects = desired.Subjects
+	return true, recordEvent(r.Recorder, classroom, "Update", roleBinding, r.Update(ctx, roleBinding))
+}
+
+// ensureClusterRole creates the clusterrole or updates its rules if they drifted and reports if anything changed.
+func (r *ClassroomReconciler) ensureClusterRole(ctx context.Context, classroom *kubelabv1.Classroom, desired *v1rbac.ClusterRole) (bool, error) {
+	role := &v1rbac.ClusterRole{}
+	err := r.Get(ctx, client.ObjectKey{Name: desired.Name}, role)
+	if err != nil && apierrors.IsNotFound(err) {
+		return true, recordEvent(r.Recorder, classroom, "Create", desired, r.Create(ctx, desired))
+	} else if err != nil {
+		return false, err
+	}
+
+	if equality.Semantic.DeepEqual(role.Rules, desired.Rules) {
+		return false, nil
+	}
+	role.Rules = desired.Rules
+	return true, recordEvent(r.Recorder, classroom, "Update", role, r.Update(ctx, role))
+}
+
+// ensureClusterRoleBinding creates the clusterrolebinding or updates its subjects if they drifted and reports if anything changed.
+func (r *ClassroomReconciler) ensureClusterRoleBinding(ctx context.Context, classroom *kubelabv1.Classroom, desired *v1rbac.ClusterRoleBinding) (bool, error) {
+	roleBinding := &v1rbac.ClusterRoleBinding{}
+	err := r.Get(ctx, client.ObjectKey{Name: desired.Name}, roleBinding)
+	if err != nil && apierrors.IsNotFound(err) {
+		return true, recordEvent(r.Recorder, classroom, "Create", desired, r.Create(ctx, desired))
+	} else if err != nil {
+		return false, err
+	}
+
+	if equality.Semantic.DeepEqual(roleBinding.Subjects, desired.Subjects) {
+		return false, nil
+	}
+	roleBinding.Subjects = desired.Subjects
+	return true, recordEvent(r.Recorder, classroom, "Update", roleBinding, r.Update(ctx, roleBinding))
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClassroomReconciler) SetupWithManager(mgr ctrl.Manager) error {
+
+	// the labs are named like their classroom, the Deployments are the labs of older versions and the warm pools
+	for _, obj := range []client.Object{&v1apps.StatefulSet{}, &v1apps.Deployment{}} {
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), obj, classroomOwnerKey, func(rawObj client.Object) []string {
+			return []string{rawObj.GetName()}
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &kubelabv1.KubelabUser{}, userOwnerKey, func(rawObj client.Object) []string {
+		user := rawObj.(*kubelabv1.KubelabUser)
+		return []string{user.Spec.Id}
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubelabv1.Classroom{}).
+		Owns(&kubelabv1.KubelabUser{}).
+		Owns(&v1apps.StatefulSet{}).
+		Owns(&v1apps.Deployment{}).
+		Owns(&v1apps.DaemonSet{}).
+		Owns(&v1.Namespace{}).
+		Owns(&v1.Service{}).
+		Owns(&v1.Secret{}).
+		Owns(&v1.Pod{}).
+		Owns(&v1.PersistentVolumeClaim{}).
+		Owns(&v1.ConfigMap{}).
+		Owns(&batchv1.Job{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&v1rbac.Role{}).
+		Owns(&v1rbac.RoleBinding{}).
+		Owns(&v1rbac.ClusterRole{}).
+		Owns(&v1rbac.ClusterRoleBinding{}).
+		Watches(&source.Kind{Type: &kubelabv1.KubelabUser{}}, r.classroomsForUser()).
+		Watches(&source.Kind{Type: &kubelabv1.EnrollmentRequest{}}, handler.EnqueueRequestsFromMapFunc(classroomForEnrollmentRequest)).
+		Complete(r)
+}
+
+// classroomForEnrollmentRequest enqueues the requested classroom, so the pending requests in its status are updated.
+func classroomForEnrollmentRequest(obj client.Object) []reconcile.Request {
+	request := obj.(*kubelabv1.EnrollmentRequest)
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: request.Spec.Classroom}}}
+}
+
+// classroomsForUser enqueues the classrooms whose student selector matches the old or the new labels of a user,
+// so users gaining or losing a label are enrolled or removed.
+func (r *ClassroomReconciler) classroomsForUser() handler.EventHandler {
+	return handler.Funcs{
+		CreateFunc: func(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			r.enqueueClassroomsSelecting(q, e.Object.GetLabels())
+		},
+		UpdateFunc: func(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			r.enqueueClassroomsSelecting(q, e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+		},
+		DeleteFunc: func(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			r.enqueueClassroomsSelecting(q, e.Object.GetLabels())
+		},
+	}
+}
+
+// enqueueClassroomsSelecting enqueues the classrooms whose student selector matches any of the label sets
+func (r *ClassroomReconciler) enqueueClassroomsSelecting(q workqueue.RateLimitingInterface, labelSets ...map[string]string) {
+	classroomList := &kubelabv1.ClassroomList{}
+	if err := r.List(context.Background(), classroomList); err != nil {
+		return
+	}
+
+	for _, classroom := range classroomList.Items {
+		if classroom.Spec.StudentSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(classroom.Spec.StudentSelector)
+		if err != nil {
+			continue
+		}
+		for _, set := range labelSets {
+			if selector.Matches(labels.Set(set)) {
+				q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Name: classroom.Name}})
+				break
+			}
+		}
+	}
 }
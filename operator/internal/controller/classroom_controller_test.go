@@ -0,0 +1,924 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	v1rbac "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// Every student gets a writable workspace per classroom, the staff reads all workspaces of the class as submissions.
+func TestWorkspaceOfLabs(t *testing.T) {
+	classroom := &kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "java"}}
+	student := &kubelabv1.KubelabUser{ObjectMeta: metav1.ObjectMeta{Name: "student"}, Spec: kubelabv1.KubelabUserSpec{Id: "575103"}}
+	teacher := &kubelabv1.KubelabUser{ObjectMeta: metav1.ObjectMeta{Name: "teacher"}, Spec: kubelabv1.KubelabUserSpec{Id: "t01"}}
+	r := &ClassroomReconciler{Client: newTestClient(), Scheme: testScheme}
+
+	mountsOf := func(statefulSet *appsv1.StatefulSet) map[string]v1.VolumeMount {
+		mounts := map[string]v1.VolumeMount{}
+		for _, mount := range statefulSet.Spec.Template.Spec.Containers[0].VolumeMounts {
+			mounts[mount.MountPath] = mount
+		}
+		return mounts
+	}
+
+	lab, err := r.statefulSetForClassroom(classroom, student)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounts := mountsOf(lab)
+	if mount, ok := mounts["/home/student/java/work"]; !ok || mount.Name != "work-data" {
+		t.Errorf("workspace is not mounted: %v", mounts)
+	}
+	if !hasReadOnlyVolume(lab, "class-data") || !hasVolume(lab, "work-data") {
+		t.Errorf("class share is writable or the workspace is missing: %v", lab.Spec.Template.Spec.Volumes)
+	}
+
+	claim, err := r.persistentVolumeClaimForWorkspace(classroom, student)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claim.Namespace != "575103" || claim.Name != workspaceClaimName(classroom) || claim.Annotations["nfs.io/storage-path"] != "work/java" {
+		t.Errorf("workspace is not stored below the class folder: %s/%s %v", claim.Namespace, claim.Name, claim.Annotations)
+	}
+
+	for role, readOnly := range map[string]bool{staffTeacher: false, staffAssistant: true} {
+		lab, err := r.statefulSetForTeacher(classroom, teacher, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := mountsOf(lab)["/home/teacher/java/submissions"]; !ok || !hasReadOnlyVolume(lab, "submissions") {
+			t.Errorf("%s can not read the submissions read only", role)
+		}
+		if hasReadOnlyVolume(lab, "class-data") != readOnly {
+			t.Errorf("%s gets the wrong access to the class share", role)
+		}
+	}
+}
+
+// A reset recreates the pod of the lab, a reset with the workspace keeps the lab stopped until the workspace is wiped.
+// The labs keep their replicas.
+func TestResetLabWipesWorkspace(t *testing.T) {
+	classroom := &kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{
+		Name: "java",
+		Annotations: map[string]string{
+			resetAnnotationPrefix + "575103": resetWorkspace,
+			resetAnnotationPrefix + "575104": "lab",
+			resetAnnotationPrefix + "575199": "lab",
+		},
+	}}
+	replicas := int32(1)
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "java", Namespace: "575103"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+	other := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "java", Namespace: "575104"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "java-abc", Namespace: "575104",
+		Labels: map[string]string{"app.kubernetes.io/name": "KubelabClassroom", "class": "java", "student": "575104"},
+	}}
+	students := []kubelabv1.KubelabUser{{Spec: kubelabv1.KubelabUserSpec{Id: "575103"}}, {Spec: kubelabv1.KubelabUserSpec{Id: "575104"}}}
+	recorder := record.NewFakeRecorder(100)
+	r := &ClassroomReconciler{
+		Client:   newTestClient(classroom, statefulSet, other, pod),
+		Scheme:   testScheme,
+		Recorder: recorder,
+	}
+	ctx := context.Background()
+	replicasOf := func(statefulSet *appsv1.StatefulSet) int32 {
+		if err := r.Get(ctx, client.ObjectKeyFromObject(statefulSet), statefulSet); err != nil {
+			t.Fatalf("StatefulSet of the lab was deleted: %v", err)
+		}
+		return *statefulSet.Spec.Replicas
+	}
+
+	// the unknown student is dropped right away, the lab is recreated, the workspace is wiped once the lab stopped
+	resetting, err := r.resetLabs(ctx, classroom, students)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resetting["575103"] || resetting["575104"] {
+		t.Errorf("unexpected resets still running: %v", resetting)
+	}
+	if replicasOf(statefulSet) != 0 {
+		t.Errorf("lab was not stopped before the workspace is wiped")
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pod), pod); !apierrors.IsNotFound(err) {
+		t.Errorf("pod of the lab was not deleted: %v", err)
+	}
+	if replicasOf(other) != 1 {
+		t.Errorf("reset changed the replicas of the lab")
+	}
+	for _, student := range []string{"575104", "575199"} {
+		if _, ok := classroom.Annotations[resetAnnotationPrefix+student]; ok {
+			t.Errorf("reset of %s was not finished", student)
+		}
+	}
+
+	if _, err := r.resetLabs(ctx, classroom, students); err != nil {
+		t.Fatal(err)
+	}
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, client.ObjectKey{Name: jobName("java", "reset", "575103"), Namespace: "575103"}, job); err != nil {
+		t.Fatalf("wipe job was not created: %v", err)
+	}
+	job.Status.Succeeded = 1
+	if err := r.Status().Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	resetting, err = r.resetLabs(ctx, classroom, students)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resetting) > 0 || len(classroom.Annotations) > 0 {
+		t.Errorf("reset did not finish: %v %v", resetting, classroom.Annotations)
+	}
+	if replicasOf(statefulSet) != 1 || statefulSet.Annotations[resetReplicasAnnotation] != "" {
+		t.Errorf("lab did not get its replicas back: %v", statefulSet.Annotations)
+	}
+	if err := r.Get(ctx, client.ObjectKey{Name: "java"}, classroom); err != nil {
+		t.Fatal(err)
+	}
+	if len(classroom.Status.Resets) != 3 {
+		t.Fatalf("resets were not recorded: %v", classroom.Status.Resets)
+	}
+	for _, reset := range classroom.Status.Resets {
+		if reset.Student == "575103" && (!reset.Workspace || reset.Message != "Reset") {
+			t.Errorf("unexpected reset %v", reset)
+		}
+	}
+	warnings, deleted := 0, 0
+	for len(recorder.Events) > 0 {
+		event := <-recorder.Events
+		if strings.Contains(event, "LabReset") {
+			warnings++
+		}
+		if strings.Contains(event, "Deleted Pod") {
+			deleted++
+		}
+	}
+	if warnings != 1 || deleted != 1 {
+		t.Errorf("expected a warning for the unknown student and an event for the deleted pod, got %d and %d", warnings, deleted)
+	}
+}
+
+// The profile hardens the lab, the namespace follows the most permissive classroom of the user.
+func TestSecurityProfileOfLabs(t *testing.T) {
+	restricted := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java"},
+		Spec: kubelabv1.ClassroomSpec{Security: &kubelabv1.ClassroomSecurity{
+			Profile: securityRestricted, RuntimeClassName: "gvisor", UserNamespace: true,
+		}},
+	}
+	spec := &v1.PodSpec{Containers: []v1.Container{{Name: "java"}}}
+	applySecurityProfile(restricted, spec)
+	if spec.SecurityContext.RunAsNonRoot == nil || !*spec.SecurityContext.RunAsNonRoot {
+		t.Errorf("restricted lab may run as root")
+	}
+	if security := spec.SecurityContext; security.RunAsUser == nil || *security.RunAsUser == 0 || security.FSGroup == nil || *security.FSGroup != *security.RunAsUser {
+		t.Errorf("restricted lab has no user or its host key is not readable: %v %v", security.RunAsUser, security.FSGroup)
+	}
+	if caps := spec.Containers[0].SecurityContext.Capabilities; len(caps.Drop) != 1 || caps.Drop[0] != "ALL" {
+		t.Errorf("restricted lab keeps capabilities: %v", caps)
+	}
+	if spec.RuntimeClassName == nil || *spec.RuntimeClassName != "gvisor" || spec.HostUsers == nil || *spec.HostUsers {
+		t.Errorf("runtime class or user namespace is not set: %v %v", spec.RuntimeClassName, spec.HostUsers)
+	}
+
+	privileged := kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "linux"},
+		Status:     kubelabv1.ClassroomStatus{Students: []string{"575103"}},
+	}
+	if level := podSecurityLevelOfUser(restricted, nil, "575103"); level != "restricted" {
+		t.Errorf("level of a restricted classroom is %s", level)
+	}
+	if level := podSecurityLevelOfUser(restricted, []kubelabv1.Classroom{privileged}, "575103"); level != "privileged" {
+		t.Errorf("level of a student of a privileged classroom is %s", level)
+	}
+}
+
+// The staff is warned about labs, which can not start with the restricted profile.
+func TestUnsupportedProfileIsWarned(t *testing.T) {
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java"},
+		Spec:       kubelabv1.ClassroomSpec{Security: &kubelabv1.ClassroomSecurity{Profile: securityRestricted}},
+	}
+	pod := func(student string, waiting string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "java-0", Namespace: student, Labels: labelsForClassroom("java", student)},
+			Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+				Name: "java", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: waiting}},
+			}}},
+		}
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &ClassroomReconciler{
+		Client:   newTestClient(classroom, pod("575103", "CrashLoopBackOff"), pod("575104", "ContainerCreating")),
+		Scheme:   testScheme,
+		Recorder: recorder,
+	}
+
+	if err := r.warnUnsupportedProfile(context.Background(), classroom); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected a warning for the crashing lab, got %d events", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "SecurityProfileUnsupported") || !strings.Contains(event, "575103") {
+		t.Errorf("unexpected event %s", event)
+	}
+}
+
+// Pinned labs get the node selector and tolerations and avoid the labs of the classroom in other namespaces.
+func TestPlacementOfLabs(t *testing.T) {
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java"},
+		Spec: kubelabv1.ClassroomSpec{Placement: &kubelabv1.ClassroomPlacement{
+			NodeSelector:      map[string]string{"pool": "exam"},
+			Tolerations:       []v1.Toleration{{Key: "exam", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}},
+			SpreadTopologyKey: "kubernetes.io/hostname",
+		}},
+	}
+	spec := &v1.PodSpec{Containers: []v1.Container{{Name: "java"}}}
+	applyPlacement(classroom, spec)
+	if spec.NodeSelector["pool"] != "exam" || len(spec.Tolerations) != 1 {
+		t.Errorf("lab is not pinned to the pool: %v %v", spec.NodeSelector, spec.Tolerations)
+	}
+	if spec.Affinity.NodeAffinity == nil {
+		t.Errorf("lab may run on any operating system")
+	}
+	terms := spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 1 || terms[0].PodAffinityTerm.NamespaceSelector == nil || terms[0].PodAffinityTerm.TopologyKey != "kubernetes.io/hostname" {
+		t.Errorf("labs are not spread across namespaces: %v", terms)
+	}
+
+	classroom.Spec.Placement = nil
+	applyPlacement(classroom, spec)
+	if spec.NodeSelector != nil || spec.Tolerations != nil || spec.Affinity.PodAntiAffinity != nil {
+		t.Errorf("removed placement is kept: %v", spec)
+	}
+}
+
+// The template is pulled from an hour before the start date on, pull secrets are copied into the namespace of the student.
+func TestPrePullAndPullSecrets(t *testing.T) {
+	start := metav1.NewTime(time.Now().Add(3 * time.Hour))
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java"},
+		Spec: kubelabv1.ClassroomSpec{
+			TemplateContainer: "registry.example.com/java:1",
+			ImagePullSecrets:  []string{"registry"},
+			PrePull:           true,
+			StartDate:         &start,
+		},
+	}
+	source := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: pullSecretNamespace, Labels: map[string]string{pullSecretSourceLabel: "true"}},
+		Type:       v1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{v1.DockerConfigJsonKey: []byte("{}")},
+	}
+	// an unshared secret and a secret of the user with the same name as a shared one
+	unshared := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: pullSecretNamespace},
+		Type:       v1.SecretTypeTLS,
+	}
+	foreign := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "575104"},
+		Data:       map[string][]byte{"token": []byte("mine")},
+	}
+	// the pre-pull DaemonSet of older versions in the namespace of the operator
+	legacy := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "java-prepull", Namespace: operatorNamespace}}
+	if err := ctrl.SetControllerReference(classroom, legacy, testScheme); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(classroom, source, unshared, foreign, legacy)
+	r := &ClassroomReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100), APIReader: c}
+	ctx := context.Background()
+
+	startIn, err := r.reconcilePrePull(ctx, classroom, classroomDraft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if startIn < time.Hour || startIn > 2*time.Hour {
+		t.Errorf("pre-pull starts in %s, want about 2h", startIn)
+	}
+	daemonSet := &appsv1.DaemonSet{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-prepull", Namespace: prePullNamespace}, daemonSet); !apierrors.IsNotFound(err) {
+		t.Errorf("pre-pull started hours before the start date: %v", err)
+	}
+
+	start = metav1.NewTime(time.Now().Add(30 * time.Minute))
+	if _, err := r.reconcilePrePull(ctx, classroom, classroomDraft); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-prepull", Namespace: prePullNamespace}, daemonSet); err != nil {
+		t.Fatalf("pre-pull did not start: %v", err)
+	}
+	if image := daemonSet.Spec.Template.Spec.InitContainers[0].Image; image != classroom.Spec.TemplateContainer {
+		t.Errorf("pre-pull pulls %s", image)
+	}
+	if automount := daemonSet.Spec.Template.Spec.AutomountServiceAccountToken; automount == nil || *automount {
+		t.Error("pre-pull pods get a service account token")
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-prepull", Namespace: operatorNamespace}, &appsv1.DaemonSet{}); !apierrors.IsNotFound(err) {
+		t.Errorf("pre-pull in the namespace of the operator was kept: %v", err)
+	}
+	ns := &v1.Namespace{}
+	if err := c.Get(ctx, client.ObjectKey{Name: prePullNamespace}, ns); err != nil || ns.Labels[podSecurityEnforce] != securityRestricted {
+		t.Errorf("pre-pull namespace is not restricted: %v %v", err, ns.Labels)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "registry", Namespace: prePullNamespace}, &v1.Secret{}); err != nil {
+		t.Errorf("pull secret was not copied into the pre-pull namespace: %v", err)
+	}
+
+	if _, err := r.reconcilePrePull(ctx, classroom, classroomClosed); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-prepull", Namespace: prePullNamespace}, daemonSet); !apierrors.IsNotFound(err) {
+		t.Errorf("pre-pull was not removed from the closed classroom: %v", err)
+	}
+
+	if err := r.ensurePullSecrets(ctx, classroom, "575103"); err != nil {
+		t.Fatal(err)
+	}
+	secret := &v1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "registry", Namespace: "575103"}, secret); err != nil {
+		t.Fatalf("pull secret was not copied: %v", err)
+	}
+	if secret.Type != v1.SecretTypeDockerConfigJson || string(secret.Data[v1.DockerConfigJsonKey]) != "{}" {
+		t.Errorf("copy differs from the pull secret: %v", secret)
+	}
+
+	classroom.Spec.ImagePullSecrets = []string{"registry", "tls"}
+	if err := r.ensurePullSecrets(ctx, classroom, "575104"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "registry", Namespace: "575104"}, secret); err != nil || string(secret.Data["token"]) != "mine" {
+		t.Errorf("secret of the user was replaced: %v %v", err, secret.Data)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "tls", Namespace: "575104"}, secret); !apierrors.IsNotFound(err) {
+		t.Errorf("unshared secret was copied: %v", err)
+	}
+}
+
+// The warm pool pre-starts the stopped labs of the first students, a started lab adopts its warm pod.
+func TestWarmPoolOfActiveClassroom(t *testing.T) {
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java", UID: "java-uid"},
+		Spec:       kubelabv1.ClassroomSpec{TemplateContainer: "java:1", WarmPool: 1},
+	}
+	students := []kubelabv1.KubelabUser{{Spec: kubelabv1.KubelabUserSpec{Id: "575103"}}, {Spec: kubelabv1.KubelabUserSpec{Id: "575104"}}}
+	lab := func(student string) *appsv1.StatefulSet {
+		stopped := int32(0)
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "java", Namespace: student, Labels: labelsForClassroom("java", student)},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas:    &stopped,
+				ServiceName: "java",
+				Selector:    &metav1.LabelSelector{MatchLabels: labelsForClassroom("java", student)},
+				Template: v1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labelsForClassroom("java", student)},
+					Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "java", Image: "java:1"}}},
+				},
+			},
+			Status: appsv1.StatefulSetStatus{UpdateRevision: "java-1"},
+		}
+	}
+	placeholders := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "java-warm", Namespace: prePullNamespace}}
+	if err := ctrl.SetControllerReference(classroom, placeholders, testScheme); err != nil {
+		t.Fatal(err)
+	}
+	quota := &v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: quotaName, Namespace: "575104"},
+		Status:     v1.ResourceQuotaStatus{Hard: v1.ResourceList{v1.ResourcePods: resource.MustParse("1")}, Used: v1.ResourceList{v1.ResourcePods: resource.MustParse("1")}},
+	}
+	c := newTestClient(classroom, lab("575103"), lab("575104"), placeholders, quota)
+	r := &ClassroomReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100), APIReader: c}
+	ctx := context.Background()
+
+	if _, err := r.reconcileWarmPool(ctx, classroom, classroomActive, students, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-warm", Namespace: prePullNamespace}, placeholders); !apierrors.IsNotFound(err) {
+		t.Errorf("placeholders of the old warm pool were kept: %v", err)
+	}
+	pod := &v1.Pod{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-0", Namespace: "575103"}, pod); err != nil {
+		t.Fatalf("lab was not warmed: %v", err)
+	}
+	selector := labels.SelectorFromSet(labelsForClassroom("java", "575103"))
+	if selector.Matches(labels.Set(pod.Labels)) || pod.Spec.Hostname != "java-0" || pod.Spec.Subdomain != "java" || !metav1.IsControlledBy(pod, classroom) {
+		t.Errorf("warm pod is already part of the lab: %v %s.%s", pod.Labels, pod.Spec.Hostname, pod.Spec.Subdomain)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-0", Namespace: "575104"}, &v1.Pod{}); !apierrors.IsNotFound(err) {
+		t.Errorf("more labs were warmed than the warm pool: %v", err)
+	}
+
+	// the student starts the lab, the warm pod is handed over and the next lab is warmed
+	started := lab("575103")
+	if err := c.Get(ctx, client.ObjectKey{Name: "java", Namespace: "575103"}, started); err != nil {
+		t.Fatal(err)
+	}
+	replicas := int32(1)
+	started.Spec.Replicas = &replicas
+	if err := c.Update(ctx, started); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reconcileWarmPool(ctx, classroom, classroomActive, students, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-0", Namespace: "575103"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	if !selector.Matches(labels.Set(pod.Labels)) || pod.Labels[appsv1.ControllerRevisionHashLabelKey] != "java-1" || len(pod.OwnerReferences) != 0 {
+		t.Errorf("warm pod was not handed over to the lab: %v %v", pod.Labels, pod.OwnerReferences)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-0", Namespace: "575104"}, pod); err != nil {
+		t.Fatalf("next lab was not warmed: %v", err)
+	}
+	// the warm pod already uses the quota, so the lab may start
+	if quota, err := quotaOfLab(ctx, c, lab("575104")); err != nil || quota != nil {
+		t.Errorf("quota of warmed lab: %v %v", quota, err)
+	}
+
+	// the workspace is wiped by a reset, the warm pod must not use it meanwhile
+	if _, err := r.reconcileWarmPool(ctx, classroom, classroomActive, students, map[string]bool{"575104": true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-0", Namespace: "575104"}, pod); !apierrors.IsNotFound(err) {
+		t.Errorf("warm pod of the lab being reset was kept: %v", err)
+	}
+
+	if _, err := r.reconcileWarmPool(ctx, classroom, classroomActive, students, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reconcileWarmPool(ctx, classroom, classroomClosed, students, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java-0", Namespace: "575104"}, pod); !apierrors.IsNotFound(err) {
+		t.Errorf("warm pod of the closed classroom was kept: %v", err)
+	}
+}
+
+// The host key is created once and mounted into the lab, so it survives restarts and resets.
+func TestLabKeepsHostKey(t *testing.T) {
+	classroom := &kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "java"}}
+	student := &kubelabv1.KubelabUser{ObjectMeta: metav1.ObjectMeta{Name: "student"}, Spec: kubelabv1.KubelabUserSpec{Id: "575103"}}
+	c := newTestClient(classroom)
+	r := &ClassroomReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100), APIReader: c}
+	ctx := context.Background()
+
+	var keys []string
+	for i := 0; i < 2; i++ {
+		if err := r.ensureHostKeys(ctx, classroom, student); err != nil {
+			t.Fatal(err)
+		}
+		secret := &v1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Name: hostKeysSecretName(classroom), Namespace: "575103"}, secret); err != nil {
+			t.Fatalf("host key was not created: %v", err)
+		}
+		if !strings.HasPrefix(string(secret.Data["ssh_host_ecdsa_key.pub"]), "ecdsa-sha2-nistp256 ") {
+			t.Errorf("public key is not in authorized keys format: %s", secret.Data["ssh_host_ecdsa_key.pub"])
+		}
+		keys = append(keys, string(secret.Data["ssh_host_ecdsa_key"]))
+	}
+	if keys[0] != keys[1] {
+		t.Errorf("host key changed")
+	}
+
+	spec := &v1.PodSpec{Containers: []v1.Container{{Name: "java"}}}
+	applyHostKeys(classroom, spec)
+	applyHostKeys(classroom, spec)
+	if len(spec.Volumes) != 1 || len(spec.Containers[0].VolumeMounts) != 1 || spec.Containers[0].VolumeMounts[0].MountPath != hostKeysMountPath {
+		t.Errorf("host key is not mounted once: %v %v", spec.Volumes, spec.Containers[0].VolumeMounts)
+	}
+}
+
+// allows checks if one of the rules grants the verb on the named resource.
+func allows(rules []v1rbac.PolicyRule, resource string, name string, verb string) bool {
+	for _, rule := range rules {
+		if containsString(rule.Resources, resource) && containsString(rule.Verbs, verb) &&
+			(len(rule.ResourceNames) == 0 || containsString(rule.ResourceNames, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Only owners may change the classroom, teachers only its settings and assistants only restart labs.
+func TestStaffPermissions(t *testing.T) {
+	classroom := &kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "java"}}
+	student := &kubelabv1.KubelabUser{Spec: kubelabv1.KubelabUserSpec{Id: "575103"}}
+	r := &ClassroomReconciler{Client: newTestClient(), Scheme: testScheme}
+
+	for _, test := range []struct {
+		role     string
+		resource string
+		name     string
+		verb     string
+		allowed  bool
+	}{
+		{staffOwner, "classrooms", "java", "update", true},
+		{staffOwner, "classrooms", "java", "delete", true},
+		{staffTeacher, "classrooms", "java", "get", true},
+		{staffTeacher, "classrooms", "java", "update", false},
+		{staffTeacher, "classrooms", "java", "patch", false},
+		{staffTeacher, "classrooms", "python", "get", false},
+		{staffAssistant, "classrooms", "java", "update", false},
+		{staffTeacher, "configmaps", staffSettingsName, "patch", true},
+		{staffTeacher, "configmaps", "kube-root-ca.crt", "patch", false},
+		{staffAssistant, "configmaps", staffSettingsName, "patch", false},
+		{staffTeacher, "gradingruns", "", "create", true},
+		{staffAssistant, "gradingruns", "", "create", false},
+		{staffTeacher, "labrestores", "", "create", true},
+		{staffAssistant, "labrestores", "", "create", false},
+		{staffAssistant, "assignments", "", "get", true},
+		{staffTeacher, "deployments", "java", "update", true},
+		{staffTeacher, "deployments", "python", "update", false},
+		{staffAssistant, "deployments", "java", "update", false},
+		{staffAssistant, "deployments/scale", "java", "update", true},
+		{staffTeacher, "statefulsets", "java", "update", true},
+		{staffTeacher, "statefulsets", "python", "update", false},
+		{staffAssistant, "statefulsets", "java", "update", false},
+		{staffAssistant, "statefulsets/scale", "java", "update", true},
+		{staffAssistant, "pods", "", "delete", true},
+	} {
+		clusterRole, err := r.clusterRoleForClassroom(classroom, test.role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		classRole, err := r.roleForClassNamespace(classroom, test.role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		labRole, err := r.roleForClassroomStaff(classroom, student, test.role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules := append(append(clusterRole.Rules, classRole.Rules...), labRole.Rules...)
+		if allowed := allows(rules, test.resource, test.name, test.verb); allowed != test.allowed {
+			t.Errorf("%s may %s %s %s: %t, expected %t", test.role, test.verb, test.resource, test.name, allowed, test.allowed)
+		}
+	}
+}
+
+// The staff view of the web app reads the lab and starts or stops it through the scale subresource, every member of staff may do so.
+func TestStaffRestartsLabsThroughScale(t *testing.T) {
+	classroom := &kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "java"}}
+	student := &kubelabv1.KubelabUser{Spec: kubelabv1.KubelabUserSpec{Id: "575103"}}
+	r := &ClassroomReconciler{Client: newTestClient(), Scheme: testScheme}
+
+	for _, role := range []string{staffOwner, staffTeacher, staffAssistant} {
+		labRole, err := r.roleForClassroomStaff(classroom, student, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// readLab and scaleLab of the web app, the Deployment of labs of older versions included
+		for _, request := range []struct{ resource, verb string }{
+			{"statefulsets", "get"}, {"statefulsets/scale", "update"}, {"deployments", "get"}, {"deployments/scale", "update"},
+		} {
+			if !allows(labRole.Rules, request.resource, "java", request.verb) {
+				t.Errorf("%s may not %s %s of the lab", role, request.verb, request.resource)
+			}
+		}
+	}
+}
+
+// The settings of the teachers are copied into the classroom, since they may not change it themselves.
+func TestStaffSettings(t *testing.T) {
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java"},
+		Spec:       kubelabv1.ClassroomSpec{TemplateContainer: "java:1"},
+	}
+	c := newTestClient(classroom)
+	r := &ClassroomReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100)}
+	ctx := context.Background()
+
+	if changed, err := r.syncStaffSettings(ctx, classroom); err != nil || changed {
+		t.Fatalf("creating the settings changed the classroom: %t %v", changed, err)
+	}
+	settings := &v1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Name: staffSettingsName, Namespace: "java"}, settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Data["templateContainer"] != "java:1" {
+		t.Errorf("settings were not created with the template of the classroom: %v", settings.Data)
+	}
+
+	settings.Data["templateContainer"] = "java:2"
+	settings.Data["enableExamMode"] = "true"
+	if err := c.Update(ctx, settings); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := r.syncStaffSettings(ctx, classroom); err != nil || !changed {
+		t.Fatalf("settings were not copied: %t %v", changed, err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(classroom), classroom); err != nil {
+		t.Fatal(err)
+	}
+	if classroom.Spec.TemplateContainer != "java:2" || classroom.Spec.EnableExamMode != "true" {
+		t.Errorf("unexpected template %s and exam mode %s", classroom.Spec.TemplateContainer, classroom.Spec.EnableExamMode)
+	}
+
+	// the owner changes the classroom, the change is written to the settings instead of being reverted
+	classroom.Spec.TemplateContainer = "java:3"
+	if err := c.Update(ctx, classroom); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := r.syncStaffSettings(ctx, classroom); err != nil || changed {
+		t.Fatalf("change of the owner was reverted: %t %v", changed, err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(settings), settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Data["templateContainer"] != "java:3" || settings.Data["enableExamMode"] != "true" {
+		t.Errorf("change of the owner was not written to the settings: %v", settings.Data)
+	}
+	if changed, err := r.syncStaffSettings(ctx, classroom); err != nil || changed {
+		t.Errorf("synced settings changed the classroom again: %t %v", changed, err)
+	}
+}
+
+// The staff loses the access to the lab of a student who left the classroom.
+func TestStaffRolesOfRemovedStudents(t *testing.T) {
+	classroom := &kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "java"}}
+	enrolled := kubelabv1.KubelabUser{Spec: kubelabv1.KubelabUserSpec{Id: "575103"}}
+	removed := kubelabv1.KubelabUser{Spec: kubelabv1.KubelabUserSpec{Id: "575104"}}
+	c := newTestClient(classroom)
+	r := &ClassroomReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100)}
+	ctx := context.Background()
+
+	for _, student := range []kubelabv1.KubelabUser{enrolled, removed} {
+		for _, staffRole := range staffRoles {
+			role, err := r.roleForClassroomStaff(classroom, &student, staffRole)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rb, err := r.roleBindingForClassroomStaff(classroom, student.Spec.Id, staffRole)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Create(ctx, role); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Create(ctx, rb); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := r.revokeStaffRoles(ctx, classroom, []kubelabv1.KubelabUser{enrolled}); err != nil {
+		t.Fatal(err)
+	}
+	for _, staffRole := range staffRoles {
+		for _, obj := range []client.Object{&v1rbac.Role{}, &v1rbac.RoleBinding{}} {
+			if err := c.Get(ctx, client.ObjectKey{Name: staffRoleName(classroom, staffRole), Namespace: "575103"}, obj); err != nil {
+				t.Errorf("staff lost the access to an enrolled student: %v", err)
+			}
+			if err := c.Get(ctx, client.ObjectKey{Name: staffRoleName(classroom, staffRole), Namespace: "575104"}, obj); !apierrors.IsNotFound(err) {
+				t.Errorf("staff keeps the access to a removed student: %v", err)
+			}
+		}
+	}
+}
+
+// Users are enrolled by the student selector, a change of their labels only enqueues the classrooms selecting them before or after.
+func TestStudentSelectorEnrollment(t *testing.T) {
+	selecting := func(name string, cohort string) *kubelabv1.Classroom {
+		return &kubelabv1.Classroom{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       kubelabv1.ClassroomSpec{StudentSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"cohort": cohort}}},
+		}
+	}
+	java, python := selecting("java", "java"), selecting("python", "python")
+	manual := &kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "go"}}
+	user := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "575103", Labels: map[string]string{"cohort": "java"}},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "575103"},
+	}
+	c := newTestClient(java, python, manual, user)
+	r := &ClassroomReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100)}
+	ctx := context.Background()
+
+	students, err := studentsOfClassroom(ctx, c, java)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEnrolled(students, "575103") {
+		t.Errorf("selected user was not enrolled: %v", studentIds(students))
+	}
+	if students, _ := studentsOfClassroom(ctx, c, python); isEnrolled(students, "575103") {
+		t.Errorf("user was enrolled in a classroom not selecting it")
+	}
+
+	enqueued := func(old map[string]string, new map[string]string) []string {
+		q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		defer q.ShutDown()
+		oldUser, newUser := user.DeepCopy(), user.DeepCopy()
+		oldUser.Labels, newUser.Labels = old, new
+		r.classroomsForUser().Update(event.UpdateEvent{ObjectOld: oldUser, ObjectNew: newUser}, q)
+		names := []string{}
+		for q.Len() > 0 {
+			item, _ := q.Get()
+			names = append(names, item.(reconcile.Request).Name)
+			q.Done(item)
+		}
+		sort.Strings(names)
+		return names
+	}
+	if names := enqueued(map[string]string{"cohort": "java"}, map[string]string{"cohort": "python"}); strings.Join(names, ",") != "java,python" {
+		t.Errorf("moving the user enqueued %v", names)
+	}
+	if names := enqueued(map[string]string{"cohort": "java"}, nil); strings.Join(names, ",") != "java" {
+		t.Errorf("removing the label enqueued %v", names)
+	}
+	if names := enqueued(map[string]string{"team": "a"}, map[string]string{"team": "b"}); len(names) != 0 {
+		t.Errorf("user not selected by any classroom enqueued %v", names)
+	}
+}
+
+// A classroom set back to Draft keeps the labs of its students stopped instead of deleting them.
+func TestDraftKeepsLabsStopped(t *testing.T) {
+	classroom := &kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "java"}}
+	replicas := int32(1)
+	lab := func(namespace string) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "java", Namespace: namespace},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(testScheme).
+		WithObjects(classroom, lab("575103"), lab("575104"), lab("t01")).
+		WithIndex(&appsv1.StatefulSet{}, classroomOwnerKey, func(obj client.Object) []string { return []string{obj.GetName()} }).
+		WithIndex(&appsv1.Deployment{}, classroomOwnerKey, func(obj client.Object) []string { return []string{obj.GetName()} }).
+		Build()
+	r := &ClassroomReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100)}
+	ctx := context.Background()
+	students := []kubelabv1.KubelabUser{{Spec: kubelabv1.KubelabUserSpec{Id: "575103"}}}
+	staff := []kubelabv1.ClassroomStaff{{Id: "t01", Role: staffOwner}}
+
+	replicasOf := func(namespace string) int32 {
+		statefulSet := &appsv1.StatefulSet{}
+		if err := c.Get(ctx, client.ObjectKey{Name: "java", Namespace: namespace}, statefulSet); err != nil {
+			t.Fatalf("lab in %s was deleted: %v", namespace, err)
+		}
+		return *statefulSet.Spec.Replicas
+	}
+
+	if _, err := r.cleanupLabs(ctx, classroom, classroomActive, students, staff); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java", Namespace: "575104"}, &appsv1.StatefulSet{}); !apierrors.IsNotFound(err) {
+		t.Errorf("lab of a removed student was kept: %v", err)
+	}
+	if replicasOf("575103") != 1 {
+		t.Errorf("lab of an active classroom was stopped")
+	}
+
+	if _, err := r.cleanupLabs(ctx, classroom, classroomDraft, students, staff); err != nil {
+		t.Fatal(err)
+	}
+	if replicasOf("575103") != 0 {
+		t.Errorf("lab of a student keeps running in a Draft classroom")
+	}
+	if replicasOf("t01") != 1 {
+		t.Errorf("lab of the staff was stopped in a Draft classroom")
+	}
+}
+
+// Labs created as Deployments by older versions are replaced by StatefulSets once they are stopped, running labs are not restarted.
+func TestLegacyLabsAreMigratedWhenStopped(t *testing.T) {
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java", UID: "java-uid"},
+		Spec:       kubelabv1.ClassroomSpec{TemplateContainer: "java:1"},
+	}
+	legacy := func(namespace string, replicas int32) *appsv1.Deployment {
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "java", Namespace: namespace, Labels: labelsForClassroom("java", namespace)},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "java", Image: "java:0"}}}},
+			},
+			Status: appsv1.DeploymentStatus{Replicas: replicas},
+		}
+		if err := ctrl.SetControllerReference(classroom, deployment, testScheme); err != nil {
+			t.Fatal(err)
+		}
+		return deployment
+	}
+	c := newTestClient(classroom, legacy("575103", 1), legacy("575104", 0))
+	r := &ClassroomReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100)}
+	ctx := context.Background()
+	user := func(id string) *kubelabv1.KubelabUser {
+		return &kubelabv1.KubelabUser{ObjectMeta: metav1.ObjectMeta{Name: "max"}, Spec: kubelabv1.KubelabUserSpec{Id: id}}
+	}
+
+	for _, id := range []string{"575103", "575104"} {
+		if _, _, err := r.reconcileLab(ctx, classroom, user(id), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java", Namespace: "575103"}, deployment); err != nil {
+		t.Fatalf("running lab was removed: %v", err)
+	}
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "java:0" {
+		t.Errorf("running lab was changed to %s", image)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java", Namespace: "575103"}, &appsv1.StatefulSet{}); !apierrors.IsNotFound(err) {
+		t.Errorf("second lab was created next to the running lab: %v", err)
+	}
+
+	if err := c.Get(ctx, client.ObjectKey{Name: "java", Namespace: "575104"}, &appsv1.Deployment{}); !apierrors.IsNotFound(err) {
+		t.Errorf("stopped lab was not migrated: %v", err)
+	}
+	statefulSet := &appsv1.StatefulSet{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "java", Namespace: "575104"}, statefulSet); err != nil {
+		t.Fatalf("stopped lab was not replaced: %v", err)
+	}
+	if *statefulSet.Spec.Replicas != 0 || statefulSet.Spec.ServiceName != "java" {
+		t.Errorf("lab has %d replicas and the service %q", *statefulSet.Spec.Replicas, statefulSet.Spec.ServiceName)
+	}
+}
+
+// The volume of the archive is retained, so it outlives the classroom and its namespace.
+func TestArchiveIsRetained(t *testing.T) {
+	classroom := &kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "java"}}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-archive"},
+		Spec:       v1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete},
+	}
+	c := newTestClient(classroom, pv)
+	r := &ClassroomReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100)}
+	ctx := context.Background()
+
+	claim, err := r.persistentVolumeClaimForArchive(classroom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claim.OwnerReferences) > 0 {
+		t.Errorf("archive is owned by the classroom: %v", claim.OwnerReferences)
+	}
+	claim.Spec.VolumeName = pv.Name
+	if err := c.Create(ctx, claim); err != nil {
+		t.Fatal(err)
+	}
+	job, err := r.jobForArchive(classroom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Succeeded = 1
+	if err := c.Create(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	archived, message, err := r.archiveClassroom(ctx, classroom)
+	if err != nil || !archived {
+		t.Fatalf("classroom was not archived: %s %v", message, err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pv), pv); err != nil {
+		t.Fatal(err)
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain || pv.Labels[archivedClassroomLabel] != "java" {
+		t.Errorf("archive volume was not retained: %v %v", pv.Spec.PersistentVolumeReclaimPolicy, pv.Labels)
+	}
+}
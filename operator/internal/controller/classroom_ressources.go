@@ -1,9 +1,19 @@
 package controller
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	v1rbac "k8s.io/api/rbac/v1"
 	resource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -13,6 +23,37 @@ import (
 	v1apps "k8s.io/api/apps/v1"
 )
 
+// Phases of a classroom
+const (
+	classroomDraft    = "Draft"
+	classroomActive   = "Active"
+	classroomClosed   = "Closed"
+	classroomArchived = "Archived"
+)
+
+// Security profiles of the labs
+const (
+	securityRestricted    = "restricted"
+	securityBaseline      = "baseline"
+	securityPrivilegedLab = "privileged-lab"
+)
+
+// Labels of the Pod Security Admission
+const (
+	podSecurityEnforce = "pod-security.kubernetes.io/enforce"
+	podSecurityWarn    = "pod-security.kubernetes.io/warn"
+)
+
+// The script gets the names via environment variables, folders which were never created are skipped
+const archiveScript = `set -e
+cd /data
+paths=""
+for dir in "class/$CLASSROOM" "work/$CLASSROOM" "collected/$CLASSROOM"; do
+  if [ -d "$dir" ]; then paths="$paths $dir"; fi
+done
+if [ -z "$paths" ]; then echo "nothing to archive"; exit 0; fi
+tar -czf "/archive/$ARCHIVE" $paths`
+
 // labelsForClassroom returns the labels for selecting the resources.
 func labelsForClassroom(name string, student string) map[string]string {
 
@@ -71,12 +112,138 @@ func (r *ClassroomReconciler) serviceForClassroom(classroom *kubelabv1.Classroom
 	return service, nil
 }
 
-// deploymentForClassroom returns a Deployment object.
-func (r *ClassroomReconciler) deploymentForClassroom(classroom *kubelabv1.Classroom, student *kubelabv1.KubelabUser) (*v1apps.Deployment, error) {
-	ls := labelsForClassroom(classroom.Name, student.Spec.Id)
+// statefulSetForClassroom returns the lab of a student.
+func (r *ClassroomReconciler) statefulSetForClassroom(classroom *kubelabv1.Classroom, student *kubelabv1.KubelabUser) (*v1apps.StatefulSet, error) {
+	mounts, volumes := volumesForStudent(classroom, student)
+	return r.labStatefulSet(classroom, student, mounts, volumes)
+}
+
+// statefulSetForTeacher returns the lab giving a member of the staff access to the class share and the submissions.
+func (r *ClassroomReconciler) statefulSetForTeacher(classroom *kubelabv1.Classroom, teacher *kubelabv1.KubelabUser, role string) (*v1apps.StatefulSet, error) {
+	mounts, volumes := volumesForTeacher(classroom, teacher, role)
+	return r.labStatefulSet(classroom, teacher, mounts, volumes)
+}
+
+// volumesForStudent returns the mounts of a student lab: the private folder, the read only class share and the writable workspace.
+func volumesForStudent(classroom *kubelabv1.Classroom, student *kubelabv1.KubelabUser) ([]v1.VolumeMount, []v1.Volume) {
+	home := "/home/" + student.Name
+	mounts := []v1.VolumeMount{
+		{
+			Name:      "user-data",
+			MountPath: home + "/private",
+		},
+		{
+			Name:      "class-data",
+			MountPath: home + "/" + classroom.Name + "/share",
+		},
+		{
+			Name:      "work-data",
+			MountPath: home + "/" + classroom.Name + "/work",
+		},
+	}
+	volumes := []v1.Volume{
+		{
+			Name: "user-data",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: claimNameUser,
+				},
+			},
+		},
+		{
+			Name: "class-data",
+			VolumeSource: v1.VolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   nfsServer,
+					Path:     nfsPath + "/class/" + classroom.Name, // path pattern in the storageClass defined
+					ReadOnly: true,
+				},
+			},
+		},
+		{
+			Name: "work-data",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: workspaceClaimName(classroom),
+				},
+			},
+		},
+	}
+	return mounts, volumes
+}
+
+// volumesForTeacher returns the mounts of a teacher lab: the private folder, the class share, all workspaces of the class as submissions
+// and the work collected by assignments. Assistants can not change the class share.
+func volumesForTeacher(classroom *kubelabv1.Classroom, teacher *kubelabv1.KubelabUser, role string) ([]v1.VolumeMount, []v1.Volume) {
+	home := "/home/" + teacher.Name
+	mounts := []v1.VolumeMount{
+		{
+			Name:      "user-data",
+			MountPath: home + "/private",
+		},
+		{
+			Name:      "class-data",
+			MountPath: home + "/" + classroom.Name + "/share",
+		},
+		{
+			Name:      "submissions",
+			MountPath: home + "/" + classroom.Name + "/submissions",
+		},
+		{
+			Name:      "collected",
+			MountPath: home + "/" + classroom.Name + "/collected",
+		},
+	}
+	volumes := []v1.Volume{
+		{
+			Name: "user-data",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: claimNameUser,
+				},
+			},
+		},
+		{
+			Name: "class-data",
+			VolumeSource: v1.VolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   nfsServer,
+					Path:     nfsPath + "/class/" + classroom.Name,
+					ReadOnly: role == staffAssistant,
+				},
+			},
+		},
+		{
+			Name: "submissions",
+			VolumeSource: v1.VolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   nfsServer,
+					Path:     nfsPath + "/work/" + classroom.Name, // one folder per student, see persistentVolumeClaimForWorkspace
+					ReadOnly: true,
+				},
+			},
+		},
+		{
+			Name: "collected",
+			VolumeSource: v1.VolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   nfsServer,
+					Path:     nfsPath + "/collected/" + classroom.Name,
+					ReadOnly: true,
+				},
+			},
+		},
+	}
+	return mounts, volumes
+}
+
+// labStatefulSet returns the StatefulSet running the template container for a user with the given volumes.
+// A lab is a single pet, the StatefulSet stops the old pod before the new one mounts the same home and keeps the name of the pod.
+func (r *ClassroomReconciler) labStatefulSet(classroom *kubelabv1.Classroom, user *kubelabv1.KubelabUser, mounts []v1.VolumeMount, volumes []v1.Volume) (*v1apps.StatefulSet, error) {
+	ls := labelsForClassroom(classroom.Name, user.Spec.Id)
 	replicas := int32(0)
 
-	userHash, err := bcrypt.GenerateFromPassword([]byte(student.Name), bcrypt.DefaultCost)
+	userHash, err := bcrypt.GenerateFromPassword([]byte(user.Name), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
 	}
@@ -85,14 +252,15 @@ func (r *ClassroomReconciler) deploymentForClassroom(classroom *kubelabv1.Classr
 		return nil, err
 	}
 
-	deployment := &v1apps.Deployment{
+	lab := &v1apps.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      classroom.Name,
-			Namespace: student.Spec.Id,
+			Namespace: user.Spec.Id,
 			Labels:    ls,
 		},
-		Spec: v1apps.DeploymentSpec{
-			Replicas: &replicas,
+		Spec: v1apps.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: classroom.Name,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: ls,
 			},
@@ -101,58 +269,14 @@ func (r *ClassroomReconciler) deploymentForClassroom(classroom *kubelabv1.Classr
 					Labels: ls,
 				},
 				Spec: v1.PodSpec{
-					// let only run on linux for now
-					Affinity: &v1.Affinity{
-						NodeAffinity: &v1.NodeAffinity{
-							RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
-								NodeSelectorTerms: []v1.NodeSelectorTerm{
-									{
-										MatchExpressions: []v1.NodeSelectorRequirement{
-											{
-												Key:      "kubernetes.io/arch",
-												Operator: "In",
-												Values:   []string{"amd64", "arm64", "ppc64le", "s390x"},
-											},
-											{
-												Key:      "kubernetes.io/os",
-												Operator: "In",
-												Values:   []string{"linux"},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
 					Containers: []v1.Container{{
-						Image:           classroom.Spec.TemplateContainer,
-						Name:            classroom.Name,
-						ImagePullPolicy: v1.PullAlways,
+						Image: classroom.Spec.TemplateContainer,
+						Name:  classroom.Name,
 						Ports: []v1.ContainerPort{{
 							ContainerPort: 22,
 							Name:          "classroom-port",
 						}},
-						SecurityContext: &v1.SecurityContext{
-							Capabilities: &v1.Capabilities{
-								Add: []v1.Capability{
-									"SYS_CHROOT",
-									"AUDIT_WRITE",
-									"NET_RAW",
-								},
-							},
-						},
-						Resources: v1.ResourceRequirements{
-							Limits: v1.ResourceList{
-								"ephemeral-storage": resource.MustParse("1Gi"),
-								"cpu":               resource.MustParse("100m"),
-								"memory":            resource.MustParse("256Mi"),
-							},
-							Requests: v1.ResourceList{
-								"ephemeral-storage": resource.MustParse("1Gi"),
-								"cpu":               resource.MustParse("100m"),
-								"memory":            resource.MustParse("256Mi"),
-							},
-						},
+						Resources: labResources(),
 						Env: []v1.EnvVar{
 							{
 								Name:  "ROOT_PASSWORD",
@@ -164,53 +288,111 @@ func (r *ClassroomReconciler) deploymentForClassroom(classroom *kubelabv1.Classr
 							},
 							{
 								Name:  "USER_NAME",
-								Value: student.Name,
+								Value: user.Name,
 							},
 							{
 								Name:  "USER_PASSWORD",
 								Value: string(userHash),
 							},
 						},
-						VolumeMounts: []v1.VolumeMount{
-							{
-								Name:      "user-data",
-								MountPath: "/home/" + student.Name + "/private",
-							},
-							{
-								Name:      "class-data",
-								MountPath: "/home/" + student.Name + "/" + classroom.Name,
-							},
-						},
+						VolumeMounts: mounts,
 					}},
-					Volumes: []v1.Volume{
-						{
-							Name: "user-data",
-							VolumeSource: v1.VolumeSource{
-								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-									ClaimName: claimNameUser,
-								},
-							},
-						},
-						{
-							Name: "class-data",
-							VolumeSource: v1.VolumeSource{
-								NFS: &v1.NFSVolumeSource{
-									Server:   nfsServer,
-									Path:     "/srv/kubernetes/class/" + classroom.Name, // path pattern in the storageClass defined
-									ReadOnly: true,
-								},
-							},
-						},
-					},
+					Volumes: volumes,
 				},
 			},
 		},
 	}
 
-	if err := ctrl.SetControllerReference(classroom, deployment, r.Scheme); err != nil {
+	applySecurityProfile(classroom, &lab.Spec.Template.Spec)
+	applyPlacement(classroom, &lab.Spec.Template.Spec)
+	applyImagePull(classroom, &lab.Spec.Template.Spec)
+	applyHostKeys(classroom, &lab.Spec.Template.Spec)
+
+	if err := ctrl.SetControllerReference(classroom, lab, r.Scheme); err != nil {
 		return nil, err
 	}
-	return deployment, nil
+	return lab, nil
+}
+
+// securityProfileOfClassroom returns the security profile of the labs, labs of older classrooms are privileged
+func securityProfileOfClassroom(classroom *kubelabv1.Classroom) string {
+	if classroom.Spec.Security == nil || classroom.Spec.Security.Profile == "" {
+		return securityPrivilegedLab
+	}
+	return classroom.Spec.Security.Profile
+}
+
+// podSecurityLevel returns the Pod Security Admission level the labs of the profile comply with
+func podSecurityLevel(profile string) string {
+	switch profile {
+	case securityRestricted:
+		return "restricted"
+	case securityBaseline:
+		return "baseline"
+	}
+	return "privileged"
+}
+
+// applySecurityProfile sets the security context, the runtime class and the user namespace of the lab pod.
+// Only these fields are set, so existing labs can be compared with the profile of the classroom.
+func applySecurityProfile(classroom *kubelabv1.Classroom, spec *v1.PodSpec) {
+	container := &spec.Containers[0]
+	runtimeDefault := &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault}
+
+	switch securityProfileOfClassroom(classroom) {
+	case securityRestricted:
+		// the image has to run sshd as the user restrictedLabUser, sudo does not work without privilege escalation.
+		// The group makes the host key readable, unprivileged ports start at 0 so sshd may still listen on port 22.
+		nonRoot, escalation, user := true, false, int64(restrictedLabUser)
+		spec.SecurityContext = &v1.PodSecurityContext{
+			RunAsNonRoot:   &nonRoot,
+			RunAsUser:      &user,
+			RunAsGroup:     &user,
+			FSGroup:        &user,
+			SeccompProfile: runtimeDefault,
+			Sysctls:        []v1.Sysctl{{Name: "net.ipv4.ip_unprivileged_port_start", Value: "0"}},
+		}
+		container.SecurityContext = &v1.SecurityContext{
+			AllowPrivilegeEscalation: &escalation,
+			Capabilities: &v1.Capabilities{
+				Drop: []v1.Capability{"ALL"},
+				Add:  []v1.Capability{"NET_BIND_SERVICE"},
+			},
+		}
+	case securityBaseline:
+		spec.SecurityContext = &v1.PodSecurityContext{SeccompProfile: runtimeDefault}
+		container.SecurityContext = &v1.SecurityContext{
+			Capabilities: &v1.Capabilities{
+				Drop: []v1.Capability{"NET_RAW"},
+				Add:  []v1.Capability{"SYS_CHROOT", "AUDIT_WRITE"},
+			},
+		}
+	default:
+		// the API server sets an empty pod security context
+		spec.SecurityContext = &v1.PodSecurityContext{}
+		container.SecurityContext = &v1.SecurityContext{
+			Capabilities: &v1.Capabilities{
+				Add: []v1.Capability{
+					"SYS_CHROOT",
+					"AUDIT_WRITE",
+					"NET_RAW",
+				},
+			},
+		}
+	}
+
+	spec.RuntimeClassName = nil
+	spec.HostUsers = nil
+	if security := classroom.Spec.Security; security != nil {
+		if security.RuntimeClassName != "" {
+			runtimeClassName := security.RuntimeClassName
+			spec.RuntimeClassName = &runtimeClassName
+		}
+		if security.UserNamespace {
+			hostUsers := false
+			spec.HostUsers = &hostUsers
+		}
+	}
 }
 
 // persistentVolumeClaimForClassroom returns pvc to have a classroom folder.
@@ -249,6 +431,254 @@ func (r *ClassroomReconciler) persistentVolumeClaimForClassroom(class *kubelabv1
 	return claim, nil
 }
 
+// configMapForStaffSettings returns configmap with the settings the teachers may change, since only owners may change the classroom.
+func (r *ClassroomReconciler) configMapForStaffSettings(classroom *kubelabv1.Classroom) (*v1.ConfigMap, error) {
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      staffSettingsName,
+			Namespace: classroom.Name,
+			Annotations: map[string]string{
+				staffSettingsSyncedPrefix + "templateContainer": classroom.Spec.TemplateContainer,
+				staffSettingsSyncedPrefix + "enableExamMode":    classroom.Spec.EnableExamMode,
+			},
+		},
+		Data: map[string]string{
+			"templateContainer": classroom.Spec.TemplateContainer,
+			"enableExamMode":    classroom.Spec.EnableExamMode,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(classroom, cm, r.Scheme); err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+// persistentVolumeClaimForCollected returns pvc to store the work collected by assignments.
+func (r *ClassroomReconciler) persistentVolumeClaimForCollected(class *kubelabv1.Classroom) (*v1.PersistentVolumeClaim, error) {
+	storageClassName := storageClass
+
+	claim := &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimNameCollected,
+			Namespace: class.Name,
+			Annotations: map[string]string{
+				"nfs.io/storage-path": "collected",
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClassName,
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteMany,
+			},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("100Mi"),
+				},
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(class, claim, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return claim, nil
+}
+
+// persistentVolumeClaimForWorkspace returns pvc to have a writable folder per student and classroom.
+func (r *ClassroomReconciler) persistentVolumeClaimForWorkspace(class *kubelabv1.Classroom, student *kubelabv1.KubelabUser) (*v1.PersistentVolumeClaim, error) {
+	storageClassName := storageClass
+
+	claim := &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      workspaceClaimName(class),
+			Namespace: student.Spec.Id,
+			Labels:    labelsForClassroom(class.Name, student.Spec.Id),
+			Annotations: map[string]string{
+				// results in work/<class>/<student>, so the teacher can mount the whole class folder
+				"nfs.io/storage-path": "work/" + class.Name,
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClassName,
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteMany,
+			},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("100Mi"),
+				},
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(class, claim, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return claim, nil
+}
+
+// persistentVolumeClaimForArchive returns pvc to store the archives of the classroom, which are kept by the storage class after deletion.
+func (r *ClassroomReconciler) persistentVolumeClaimForArchive(class *kubelabv1.Classroom) (*v1.PersistentVolumeClaim, error) {
+	storageClassName := storageClass
+
+	claim := &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimNameArchive,
+			Namespace: class.Name,
+			Annotations: map[string]string{
+				"nfs.io/storage-path": "archive",
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClassName,
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteMany,
+			},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("100Mi"),
+				},
+			},
+		},
+	}
+
+	// the archive is not owned by the classroom, its volume is retained once it is written
+	return claim, nil
+}
+
+// jobForWorkspaceReset returns a job deleting the content of the workspace of the student.
+func (r *ClassroomReconciler) jobForWorkspaceReset(classroom *kubelabv1.Classroom, student string) (*batchv1.Job, error) {
+	volumes := []v1.Volume{{
+		Name: "work-data",
+		VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: workspaceClaimName(classroom),
+			},
+		},
+	}}
+	mounts := []v1.VolumeMount{{
+		Name:      "work-data",
+		MountPath: "/work",
+	}}
+
+	job := labJob(jobName(classroom.Name, "reset", student), classroom.Name, student, "find /work -mindepth 1 -delete", nil, mounts, volumes)
+	if err := ctrl.SetControllerReference(classroom, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// jobForArchive returns a job packing the class share, the workspaces and the collected work into a tarball on the archive pvc.
+func (r *ClassroomReconciler) jobForArchive(classroom *kubelabv1.Classroom) (*batchv1.Job, error) {
+	ls := labelsForClassroom(classroom.Name, "")
+	backoffLimit := int32(3)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      archiveJobName(classroom),
+			Namespace: classroom.Name,
+			Labels:    ls,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Image:   jobImage,
+						Name:    "archive",
+						Command: []string{"sh", "-c", archiveScript},
+						Env: []v1.EnvVar{
+							{
+								Name:  "CLASSROOM",
+								Value: classroom.Name,
+							},
+							{
+								Name:  "ARCHIVE",
+								Value: classroom.Name + "-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz",
+							},
+						},
+						VolumeMounts: []v1.VolumeMount{
+							{
+								Name:      "data",
+								MountPath: "/data",
+								ReadOnly:  true,
+							},
+							{
+								Name:      "archive",
+								MountPath: "/archive",
+							},
+						},
+					}},
+					Volumes: []v1.Volume{
+						{
+							Name: "data",
+							VolumeSource: v1.VolumeSource{
+								NFS: &v1.NFSVolumeSource{
+									Server:   nfsServer,
+									Path:     nfsPath,
+									ReadOnly: true,
+								},
+							},
+						},
+						{
+							Name: "archive",
+							VolumeSource: v1.VolumeSource{
+								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+									ClaimName: claimNameArchive,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(classroom, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// archiveJobName returns the name of the archive job inside the classroom namespace.
+func archiveJobName(class *kubelabv1.Classroom) string {
+	return class.Name + "-archive"
+}
+
+// archivePath returns the path of the archive written by the job on the NFS share, see persistentVolumeClaimForArchive
+func archivePath(class *kubelabv1.Classroom, job *batchv1.Job) string {
+	for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "ARCHIVE" {
+			return "archive/" + class.Name + "/" + env.Value
+		}
+	}
+	return ""
+}
+
+// workspaceClaimName returns the name of the workspace claim inside the student namespace.
+func workspaceClaimName(class *kubelabv1.Classroom) string {
+	return class.Name + "-" + claimNameWork
+}
+
 // deploymentForClassroom returns a service object.
 func (r *ClassroomReconciler) networkPolicyForClassroom(classroom *kubelabv1.Classroom, student *kubelabv1.KubelabUser) (*networkingv1.NetworkPolicy, error) {
 	port := intstr.FromInt(22)
@@ -287,3 +717,564 @@ func (r *ClassroomReconciler) networkPolicyForClassroom(classroom *kubelabv1.Cla
 	}
 	return networkPolicy, nil
 }
+
+// roleForClassroomStaff returns role giving the staff of the classroom access to the lab of the student.
+func (r *ClassroomReconciler) roleForClassroomStaff(classroom *kubelabv1.Classroom, student *kubelabv1.KubelabUser, staffRole string) (*v1rbac.Role, error) {
+
+	// Resource names restrict the staff to the lab of this classroom
+	rules := []v1rbac.PolicyRule{
+		{
+			// labs started before they were StatefulSets keep their Deployment until they are stopped
+			APIGroups:     []string{"apps"},
+			Resources:     []string{"statefulsets", "statefulsets/scale", "deployments", "deployments/scale"},
+			ResourceNames: []string{classroom.Name},
+			Verbs:         []string{"get", "update", "patch"},
+		},
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"services"},
+			ResourceNames: []string{classroom.Name},
+			Verbs:         []string{"get"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"get", "list"},
+		},
+	}
+	if staffRole == staffAssistant {
+		// Assistants may restart the lab by scaling it or deleting its pod, but not change the StatefulSet
+		rules = []v1rbac.PolicyRule{
+			{
+				APIGroups:     []string{"apps"},
+				Resources:     []string{"statefulsets", "deployments"},
+				ResourceNames: []string{classroom.Name},
+				Verbs:         []string{"get"},
+			},
+			{
+				APIGroups:     []string{"apps"},
+				Resources:     []string{"statefulsets/scale", "deployments/scale"},
+				ResourceNames: []string{classroom.Name},
+				Verbs:         []string{"get", "update", "patch"},
+			},
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"services"},
+				ResourceNames: []string{classroom.Name},
+				Verbs:         []string{"get"},
+			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"pods"},
+				Verbs:     []string{"get", "list", "delete"},
+			},
+		}
+	}
+
+	role := &v1rbac.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      staffRoleName(classroom, staffRole),
+			Namespace: student.Spec.Id,
+			Labels:    labelsForClassroom(classroom.Name, student.Spec.Id),
+		},
+		Rules: rules,
+	}
+
+	if err := ctrl.SetControllerReference(classroom, role, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// roleForClassNamespace returns role to see the jobs and results and to manage the assignments, graders, snapshots and restores inside the classroom namespace,
+// assistants may only read the assignments and snapshots and do not see the grading results.
+func (r *ClassroomReconciler) roleForClassNamespace(classroom *kubelabv1.Classroom, staffRole string) (*v1rbac.Role, error) {
+
+	resources := []string{"pods", "configmaps"}
+	if staffRole == staffAssistant {
+		resources = []string{"pods"}
+	}
+
+	rules := []v1rbac.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: resources,
+			Verbs:     []string{"get", "list"},
+		},
+		{
+			APIGroups: []string{"batch"},
+			Resources: []string{"jobs"},
+			Verbs:     []string{"get", "list"},
+		},
+	}
+	// Assignments, grading runs, snapshots and restores live in the classroom namespace, so only the staff of this classroom can manage them
+	if staffRole == staffAssistant {
+		rules = append(rules, v1rbac.PolicyRule{
+			APIGroups: []string{"kubelab.kubelab.local"},
+			Resources: []string{"assignments", "labsnapshots", "labrestores"},
+			Verbs:     []string{"get", "list", "watch"},
+		})
+	} else {
+		rules = append(rules, v1rbac.PolicyRule{
+			APIGroups: []string{"kubelab.kubelab.local"},
+			Resources: []string{"assignments", "gradingruns", "labsnapshots", "labrestores"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+		}, v1rbac.PolicyRule{
+			// teachers change the template and the exam mode here, the classroom itself may only be changed by owners
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{staffSettingsName},
+			Verbs:         []string{"get", "update", "patch"},
+		})
+	}
+
+	role := &v1rbac.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      staffRoleName(classroom, staffRole),
+			Namespace: classroom.Name,
+		},
+		Rules: rules,
+	}
+
+	if err := ctrl.SetControllerReference(classroom, role, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// roleBindingForClassroomStaff returns rolebinding giving the staff with the given role the matching role inside the namespace.
+func (r *ClassroomReconciler) roleBindingForClassroomStaff(classroom *kubelabv1.Classroom, namespace string, staffRole string) (*v1rbac.RoleBinding, error) {
+
+	rb := &v1rbac.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      staffRoleName(classroom, staffRole),
+			Namespace: namespace,
+		},
+		Subjects: subjectsForClassroomStaff(classroom, staffRole),
+		RoleRef: v1rbac.RoleRef{
+			Kind:     "Role",
+			Name:     staffRoleName(classroom, staffRole),
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}
+
+	if err := ctrl.SetControllerReference(classroom, rb, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return rb, nil
+}
+
+// clusterRoleForClassroom returns role to read this classroom, since a list of cluster wide objects can not be restricted.
+// Only owners may change and delete the classroom, e.g. its staff, students and security, teachers use the settings ConfigMap instead.
+func (r *ClassroomReconciler) clusterRoleForClassroom(classroom *kubelabv1.Classroom, staffRole string) (*v1rbac.ClusterRole, error) {
+
+	verbs := []string{"get", "watch"}
+	if staffRole == staffOwner {
+		verbs = append(verbs, "update", "patch", "delete")
+	}
+
+	role := &v1rbac.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: staffClusterRoleName(classroom, staffRole),
+		},
+		Rules: []v1rbac.PolicyRule{
+			{
+				APIGroups:     []string{"kubelab.kubelab.local"},
+				Resources:     []string{"classrooms"},
+				ResourceNames: []string{classroom.Name},
+				Verbs:         verbs,
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(classroom, role, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// clusterRoleBindingForClassroom returns rolebinding to give the staff with the given role access to the classroom.
+func (r *ClassroomReconciler) clusterRoleBindingForClassroom(classroom *kubelabv1.Classroom, staffRole string) (*v1rbac.ClusterRoleBinding, error) {
+
+	rb := &v1rbac.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: staffClusterRoleName(classroom, staffRole),
+		},
+		Subjects: subjectsForClassroomStaff(classroom, staffRole),
+		RoleRef: v1rbac.RoleRef{
+			Kind:     "ClusterRole",
+			Name:     staffClusterRoleName(classroom, staffRole),
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}
+
+	if err := ctrl.SetControllerReference(classroom, rb, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return rb, nil
+}
+
+// subjectsForClassroomStaff returns the groups of the staff of the classroom with the given role.
+func subjectsForClassroomStaff(classroom *kubelabv1.Classroom, staffRole string) []v1rbac.Subject {
+	subjects := []v1rbac.Subject{}
+	for _, member := range staffOfClassroom(classroom) {
+		if member.Role == staffRole {
+			subjects = append(subjects, v1rbac.Subject{
+				Kind:     "Group",
+				Name:     groupPrefix + member.Id,
+				APIGroup: "rbac.authorization.k8s.io",
+			})
+		}
+	}
+	return subjects
+}
+
+// staffRoleName returns the name of the role, the teacher role keeps the name used before staff existed
+func staffRoleName(classroom *kubelabv1.Classroom, staffRole string) string {
+	return classroom.Name + "-" + staffRole
+}
+
+func staffClusterRoleName(classroom *kubelabv1.Classroom, staffRole string) string {
+	return kubelabPrefix + "classroom:" + classroom.Name + ":" + staffRole
+}
+
+// legacyClusterRoleName returns the name of the role created for the single teacher before staff existed
+func legacyClusterRoleName(classroom *kubelabv1.Classroom) string {
+	return kubelabPrefix + "classroom:" + classroom.Name
+}
+
+// applyPlacement sets the node selector, the tolerations and the affinity of the lab pod.
+// Like applySecurityProfile only these fields are set, so existing labs can be compared with the classroom.
+func applyPlacement(classroom *kubelabv1.Classroom, spec *v1.PodSpec) {
+	// let only run on linux for now
+	spec.Affinity = &v1.Affinity{
+		NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{
+					{
+						MatchExpressions: []v1.NodeSelectorRequirement{
+							{
+								Key:      "kubernetes.io/arch",
+								Operator: "In",
+								Values:   []string{"amd64", "arm64", "ppc64le", "s390x"},
+							},
+							{
+								Key:      "kubernetes.io/os",
+								Operator: "In",
+								Values:   []string{"linux"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	spec.NodeSelector = nil
+	spec.Tolerations = nil
+
+	placement := classroom.Spec.Placement
+	if placement == nil {
+		return
+	}
+	if len(placement.NodeSelector) > 0 {
+		spec.NodeSelector = map[string]string{}
+		for key, value := range placement.NodeSelector {
+			spec.NodeSelector[key] = value
+		}
+	}
+	if len(placement.Tolerations) > 0 {
+		spec.Tolerations = append([]v1.Toleration{}, placement.Tolerations...)
+	}
+	if placement.SpreadTopologyKey != "" {
+		// topology spread constraints only count the pods of the own namespace, but every lab runs in the namespace of its user.
+		// The anti affinity selects the labs of the classroom in all namespaces instead
+		spec.Affinity.PodAntiAffinity = &v1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: v1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app.kubernetes.io/name": "KubelabClassroom", "class": classroom.Name},
+					},
+					NamespaceSelector: &metav1.LabelSelector{},
+					TopologyKey:       placement.SpreadTopologyKey,
+				},
+			}},
+		}
+	}
+}
+
+// imagePullPolicyOfClassroom returns the pull policy of the template container, labs of older classrooms always pull
+func imagePullPolicyOfClassroom(classroom *kubelabv1.Classroom) v1.PullPolicy {
+	if classroom.Spec.ImagePullPolicy == "" {
+		return v1.PullAlways
+	}
+	return classroom.Spec.ImagePullPolicy
+}
+
+// applyImagePull sets the pull policy and the pull secrets of the lab pod.
+// Like applySecurityProfile only these fields are set, so existing labs can be compared with the classroom.
+func applyImagePull(classroom *kubelabv1.Classroom, spec *v1.PodSpec) {
+	spec.Containers[0].ImagePullPolicy = imagePullPolicyOfClassroom(classroom)
+	spec.ImagePullSecrets = nil
+	for _, name := range classroom.Spec.ImagePullSecrets {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, v1.LocalObjectReference{Name: name})
+	}
+}
+
+// pullSecretForUser returns a copy of the pull secret for the namespace of the user.
+// The copy is shared by all classrooms of the user, so it is not owned by the classroom.
+// The label pullSecretLabel marks it as a copy, other secrets of the user are never overwritten.
+func pullSecretForUser(source *v1.Secret, namespace string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      source.Name,
+			Namespace: namespace,
+			Labels:    map[string]string{pullSecretLabel: "true"},
+		},
+		Type: source.Type,
+		Data: source.Data,
+	}
+}
+
+// namespaceForPrePull returns the namespace of the pre-pull DaemonSets.
+// Their pods run the template containers of all classrooms, so the namespace enforces the restricted level.
+// It is shared by all classrooms, so it is not owned by a classroom.
+func namespaceForPrePull() *v1.Namespace {
+	return &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: prePullNamespace,
+			Labels: map[string]string{
+				podSecurityEnforce: securityRestricted,
+				podSecurityWarn:    securityRestricted,
+			},
+		},
+	}
+}
+
+// networkPolicyForPrePull denies all traffic of the pods in the pre-pull namespace, the images are pulled by the kubelet
+func networkPolicyForPrePull() *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "deny-all",
+			Namespace: prePullNamespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+}
+
+// applyPrePullSecurity locks down the pods pulling the template container, they get no token and run as nobody.
+// The pull secrets are copies in the pre-pull namespace like in the namespaces of the users.
+func applyPrePullSecurity(classroom *kubelabv1.Classroom, spec *v1.PodSpec) {
+	automount, nonRoot, escalation, nobody := false, true, false, int64(65534)
+	spec.AutomountServiceAccountToken = &automount
+	spec.SecurityContext = &v1.PodSecurityContext{
+		RunAsNonRoot:   &nonRoot,
+		RunAsUser:      &nobody,
+		RunAsGroup:     &nobody,
+		SeccompProfile: &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+	}
+	for _, containers := range [][]v1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			containers[i].SecurityContext = &v1.SecurityContext{
+				AllowPrivilegeEscalation: &escalation,
+				Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+			}
+		}
+	}
+	spec.ImagePullSecrets = nil
+	for _, name := range classroom.Spec.ImagePullSecrets {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, v1.LocalObjectReference{Name: name})
+	}
+}
+
+// daemonSetForPrePull returns the DaemonSet pulling the template container on all nodes the labs may run on.
+// The image is pulled by an init container, the pause container keeps the pod, so nodes joining later pull as well.
+func (r *ClassroomReconciler) daemonSetForPrePull(classroom *kubelabv1.Classroom) (*v1apps.DaemonSet, error) {
+	ls := map[string]string{
+		"app.kubernetes.io/name":       "KubelabPrePull",
+		"app.kubernetes.io/instance":   classroom.Name,
+		"app.kubernetes.io/part-of":    "classroom-operator",
+		"app.kubernetes.io/created-by": "controller-manager",
+		"class":                        classroom.Name,
+	}
+
+	daemonSet := &v1apps.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      classroom.Name + "-prepull",
+			Namespace: prePullNamespace,
+			Labels:    ls,
+		},
+		Spec: v1apps.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: v1.PodSpec{
+					InitContainers: []v1.Container{{
+						Name:  "pull",
+						Image: classroom.Spec.TemplateContainer,
+						// layers pulled here are reused by labs with the policy Always as well, they only check the digest
+						ImagePullPolicy: v1.PullIfNotPresent,
+						Command:         []string{"sh", "-c", "exit 0"},
+					}},
+					Containers: []v1.Container{{
+						Name:  "pause",
+						Image: prePullPauseImage,
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{
+								"cpu":    resource.MustParse("1m"),
+								"memory": resource.MustParse("8Mi"),
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	applyPlacement(classroom, &daemonSet.Spec.Template.Spec)
+	// the labs of the classroom are not avoided, the pod of the DaemonSet is bound to its node anyway
+	daemonSet.Spec.Template.Spec.Affinity.PodAntiAffinity = nil
+	applyPrePullSecurity(classroom, &daemonSet.Spec.Template.Spec)
+
+	if err := ctrl.SetControllerReference(classroom, daemonSet, r.Scheme); err != nil {
+		return nil, err
+	}
+	return daemonSet, nil
+}
+
+// labResources returns the resources of a lab container
+func labResources() v1.ResourceRequirements {
+	return v1.ResourceRequirements{
+		Limits: v1.ResourceList{
+			"ephemeral-storage": resource.MustParse("1Gi"),
+			"cpu":               resource.MustParse("100m"),
+			"memory":            resource.MustParse("256Mi"),
+		},
+		Requests: v1.ResourceList{
+			"ephemeral-storage": resource.MustParse("1Gi"),
+			"cpu":               resource.MustParse("100m"),
+			"memory":            resource.MustParse("256Mi"),
+		},
+	}
+}
+
+// poolOfClassroom returns warm if the labs of the classroom start from a warm pool and cold otherwise
+func poolOfClassroom(classroom *kubelabv1.Classroom) string {
+	if classroom.Spec.WarmPool > 0 {
+		return "warm"
+	}
+	return "cold"
+}
+
+// podForWarmPool returns the warm pod of the stopped lab, which runs the pod template of the lab with the volumes of the student.
+// Its labels do not match the selector of the StatefulSet, so the lab stays stopped until the warm pod is adopted on start.
+func (r *ClassroomReconciler) podForWarmPool(classroom *kubelabv1.Classroom, lab *v1apps.StatefulSet) (*v1.Pod, error) {
+	template := lab.Spec.Template.DeepCopy()
+	ls := map[string]string{}
+	for key, value := range template.Labels {
+		ls[key] = value
+	}
+	ls["app.kubernetes.io/name"] = "KubelabWarmPool"
+	annotations := map[string]string{}
+	for key, value := range template.Annotations {
+		annotations[key] = value
+	}
+	// the pod is only adopted by the revision of the StatefulSet it was created from
+	annotations[warmPodRevisionAnnotation] = lab.Status.UpdateRevision
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        labPodName(lab),
+			Namespace:   lab.Namespace,
+			Labels:      ls,
+			Annotations: annotations,
+		},
+		Spec: template.Spec,
+	}
+	// the identity of the pod of the StatefulSet can not be changed once the pod is running
+	pod.Spec.Hostname = pod.Name
+	pod.Spec.Subdomain = lab.Spec.ServiceName
+
+	if err := ctrl.SetControllerReference(classroom, pod, r.Scheme); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// hostKeysSecretName returns the name of the secret with the SSH host key of the lab inside the namespace of the user
+func hostKeysSecretName(classroom *kubelabv1.Classroom) string {
+	return classroom.Name + "-ssh-host-keys"
+}
+
+// applyHostKeys mounts the SSH host key of the lab, so the key does not change when the lab restarts.
+// Like applySecurityProfile it only adds what is missing, so existing labs can be compared with the classroom.
+func applyHostKeys(classroom *kubelabv1.Classroom, spec *v1.PodSpec) {
+	container := &spec.Containers[0]
+	for _, mount := range container.VolumeMounts {
+		if mount.Name == "ssh-host-keys" {
+			return
+		}
+	}
+	// sshd refuses host keys readable by others, restricted labs read the key through the group of the pod
+	mode := int32(0400)
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name: "ssh-host-keys",
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName:  hostKeysSecretName(classroom),
+				DefaultMode: &mode,
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+		Name:      "ssh-host-keys",
+		MountPath: hostKeysMountPath,
+		ReadOnly:  true,
+	})
+}
+
+// secretForHostKeys returns a new SSH host key for the lab of the user.
+// ECDSA keys are used, since sshd reads them as PEM and no OpenSSH key format is needed.
+func (r *ClassroomReconciler) secretForHostKeys(classroom *kubelabv1.Classroom, user *kubelabv1.KubelabUser) (*v1.Secret, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	private, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	public, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      hostKeysSecretName(classroom),
+			Namespace: user.Spec.Id,
+			Labels:    labelsForClassroom(classroom.Name, user.Spec.Id),
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"ssh_host_ecdsa_key":     pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: private}),
+			"ssh_host_ecdsa_key.pub": ssh.MarshalAuthorizedKey(public),
+		},
+	}
+
+	if err := ctrl.SetControllerReference(classroom, secret, r.Scheme); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
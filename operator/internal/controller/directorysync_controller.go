@@ -0,0 +1,384 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+	"kubelab.local/kubelab/internal/directory"
+)
+
+// DirectorySyncReconciler reconciles a DirectorySync object
+type DirectorySyncReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Secrets are read without the cache, so the operator does not need to watch all secrets
+	APIReader client.Reader
+	Recorder  record.EventRecorder
+}
+
+// directoryMember is a user of the directory with everything derived from the mapped groups
+type directoryMember struct {
+	user       directory.User
+	roles      []kubelabv1.UserRole
+	classrooms map[string]string
+}
+
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=directorysyncs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=directorysyncs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=directorysyncs/finalizers,verbs=update
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=kubelabusers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=classrooms,verbs=get;list;watch;update;patch
+
+//Custom RBAC
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *DirectorySyncReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Fetch the instance and check if it exist
+	sync := &kubelabv1.DirectorySync{}
+	if err := r.Get(ctx, req.NamespacedName, sync); err != nil {
+		if apierrors.IsNotFound(err) {
+			// If the custom resource is not found then, it usually means that it was deleted or not created
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get DirectorySync")
+		return ctrl.Result{}, err
+	}
+
+	// set the status as Unknown when no status are available
+	if sync.Status.Conditions == nil || len(sync.Status.Conditions) == 0 {
+		meta.SetStatusCondition(&sync.Status.Conditions, metav1.Condition{Type: typeAvailable, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
+		if err := r.Status().Update(ctx, sync); err != nil {
+			log.Error(err, "Failed to update directorysync status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// The synced users are kept when the sync is removed
+	if !sync.ObjectMeta.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	interval := sync.Spec.Interval.Duration
+	if interval == 0 {
+		interval = directorySyncInterval
+	}
+
+	// Changes of the spec are synced immediately, otherwise wait for the interval
+	condition := meta.FindStatusCondition(sync.Status.Conditions, typeAvailable)
+	if sync.Status.LastSyncTime != nil && condition.Status == metav1.ConditionTrue && condition.ObservedGeneration == sync.Generation {
+		if next := sync.Status.LastSyncTime.Add(interval); time.Now().Before(next) {
+			return ctrl.Result{RequeueAfter: time.Until(next)}, nil
+		}
+	}
+
+	members, err := r.membersOfDirectory(ctx, sync)
+	if err != nil {
+		log.Error(err, "Failed to read directory")
+
+		meta.SetStatusCondition(&sync.Status.Conditions, metav1.Condition{Type: typeAvailable,
+			Status: metav1.ConditionFalse, Reason: "Syncing", ObservedGeneration: sync.Generation,
+			Message: fmt.Sprintf("Failed to read the directory for the custom resource (%s): (%s)", sync.Name, err)})
+		if err := r.Status().Update(ctx, sync); err != nil {
+			log.Error(err, "Failed to update directorysync status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	synced, enabled, disabled, err := r.syncUsers(ctx, sync, members)
+	if err != nil {
+		log.Error(err, "Failed to sync users")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.syncClassrooms(ctx, sync, members, synced); err != nil {
+		log.Error(err, "Failed to sync classrooms")
+
+		meta.SetStatusCondition(&sync.Status.Conditions, metav1.Condition{Type: typeAvailable,
+			Status: metav1.ConditionFalse, Reason: "Syncing", ObservedGeneration: sync.Generation,
+			Message: fmt.Sprintf("Failed to sync the classrooms for the custom resource (%s): (%s)", sync.Name, err)})
+		if err := r.Status().Update(ctx, sync); err != nil {
+			log.Error(err, "Failed to update directorysync status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	now := metav1.Now()
+	sync.Status.LastSyncTime = &now
+	sync.Status.Users = enabled
+	sync.Status.Disabled = disabled
+	meta.SetStatusCondition(&sync.Status.Conditions, metav1.Condition{Type: typeAvailable,
+		Status: metav1.ConditionTrue, Reason: "Syncing", ObservedGeneration: sync.Generation,
+		Message: fmt.Sprintf("Synced %d users from the directory", len(members))})
+	if err := r.Status().Update(ctx, sync); err != nil {
+		log.Error(err, "Failed to update directorysync status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// membersOfDirectory returns the members of all mapped groups by their id
+func (r *DirectorySyncReconciler) membersOfDirectory(ctx context.Context, sync *kubelabv1.DirectorySync) (map[string]*directoryMember, error) {
+	dir, err := r.directoryForSync(ctx, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	members := map[string]*directoryMember{}
+	invalid := map[string][]string{}
+	for _, mapping := range sync.Spec.Groups {
+		users, err := dir.Members(ctx, mapping.Group)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			// the id is used as the name of the namespace of the user
+			if errs := validation.IsDNS1123Label(user.Id); len(errs) > 0 {
+				invalid[user.Id] = errs
+				continue
+			}
+			member, ok := members[user.Id]
+			if !ok {
+				member = &directoryMember{user: user, classrooms: map[string]string{}}
+				members[user.Id] = member
+			}
+			for _, role := range mapping.Roles {
+				if !hasRole(member.roles, role) {
+					member.roles = append(member.roles, role)
+				}
+			}
+			if mapping.Classroom != "" {
+				member.classrooms[mapping.Classroom] = valueOrDefault(mapping.ClassroomRole, userRoleStudent)
+			}
+		}
+	}
+	for id, errs := range invalid {
+		recordWarning(r.Recorder, sync, "InvalidUser", fmt.Sprintf("User %s is skipped, its id is no valid namespace name: %s", id, strings.Join(errs, ", ")))
+	}
+	return members, nil
+}
+
+// syncUsers creates and updates the users of the directory and disables synced users, which left all groups.
+// It returns the ids of the users owned by the sync, since the cache does not contain the users it just created or took over.
+func (r *DirectorySyncReconciler) syncUsers(ctx context.Context, sync *kubelabv1.DirectorySync, members map[string]*directoryMember) (map[string]bool, int32, int32, error) {
+	log := log.FromContext(ctx)
+
+	userList := &kubelabv1.KubelabUserList{}
+	if err := r.List(ctx, userList); err != nil {
+		return nil, 0, 0, err
+	}
+	existing := map[string]*kubelabv1.KubelabUser{}
+	for i := range userList.Items {
+		existing[userList.Items[i].Spec.Id] = &userList.Items[i]
+	}
+
+	synced := map[string]bool{}
+	var enabled, disabled int32
+	for _, id := range sortedMemberIds(members) {
+		member := members[id]
+		user, ok := existing[id]
+		if !ok {
+			if err := r.Create(ctx, userForDirectory(sync, member)); err != nil {
+				return nil, 0, 0, err
+			}
+		} else if owner := user.Labels[directorySyncLabel]; owner != "" && owner != sync.Name {
+			log.Info("User is synced by another DirectorySync", "User", id, "DirectorySync", owner)
+			continue
+		} else {
+			// users created by hand are taken over by the sync, only the roles and the state belong to the directory
+			desired := userForDirectory(sync, member)
+			if user.Labels[directorySyncLabel] != sync.Name || user.Spec.Disabled != desired.Spec.Disabled ||
+				!equality.Semantic.DeepEqual(user.Spec.Roles, desired.Spec.Roles) {
+				if user.Labels == nil {
+					user.Labels = map[string]string{}
+				}
+				user.Labels[directorySyncLabel] = sync.Name
+				user.Spec.Roles = desired.Spec.Roles
+				user.Spec.Disabled = desired.Spec.Disabled
+				if err := r.Update(ctx, user); err != nil {
+					return nil, 0, 0, err
+				}
+			}
+		}
+		synced[id] = true
+		if member.user.Enabled {
+			enabled++
+		} else {
+			disabled++
+		}
+	}
+
+	// users, which left all groups, keep their data but lose access
+	for i := range userList.Items {
+		user := &userList.Items[i]
+		if user.Labels[directorySyncLabel] != sync.Name || members[user.Spec.Id] != nil {
+			continue
+		}
+		synced[user.Spec.Id] = true
+		if !user.Spec.Disabled {
+			user.Spec.Disabled = true
+			if err := r.Update(ctx, user); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+		disabled++
+	}
+	return synced, enabled, disabled, nil
+}
+
+// syncClassrooms adds the enabled members of the mapped groups to the classrooms and removes synced users, which left the group.
+// Users added by hand and users of other syncs are kept, synced contains the ids of the users owned by this sync.
+func (r *DirectorySyncReconciler) syncClassrooms(ctx context.Context, sync *kubelabv1.DirectorySync, members map[string]*directoryMember, synced map[string]bool) error {
+	classrooms := []string{}
+	for _, mapping := range sync.Spec.Groups {
+		if mapping.Classroom != "" && !containsString(classrooms, mapping.Classroom) {
+			classrooms = append(classrooms, mapping.Classroom)
+		}
+	}
+
+	for _, name := range classrooms {
+		classroom := &kubelabv1.Classroom{}
+		if err := r.Get(ctx, client.ObjectKey{Name: name}, classroom); err != nil {
+			if apierrors.IsNotFound(err) {
+				return errors.New("classroom does not exist: " + name)
+			}
+			return err
+		}
+
+		// the role of every enabled member inside this classroom
+		desired := map[string]string{}
+		for id, member := range members {
+			if role, ok := member.classrooms[name]; ok && member.user.Enabled && synced[id] {
+				desired[id] = role
+			}
+		}
+
+		students := []kubelabv1.KubelabUser{}
+		for _, student := range classroom.Spec.EnrolledStudents {
+			if !synced[student.Spec.Id] || desired[student.Spec.Id] == userRoleStudent {
+				students = append(students, student)
+			}
+		}
+		staff := []kubelabv1.ClassroomStaff{}
+		for _, member := range classroom.Spec.Staff {
+			if !synced[member.Id] || desired[member.Id] == member.Role {
+				staff = append(staff, member)
+			}
+		}
+		for _, id := range sortedMemberIds(members) {
+			role, ok := desired[id]
+			if !ok {
+				continue
+			}
+			if role == userRoleStudent && !isEnrolled(students, id) {
+				students = append(students, kubelabv1.KubelabUser{Spec: kubelabv1.KubelabUserSpec{Id: id}})
+			} else if role != userRoleStudent && !isStaff(staff, id) {
+				staff = append(staff, kubelabv1.ClassroomStaff{Id: id, Role: role})
+			}
+		}
+
+		if equality.Semantic.DeepEqual(classroom.Spec.EnrolledStudents, students) && equality.Semantic.DeepEqual(classroom.Spec.Staff, staff) {
+			continue
+		}
+		classroom.Spec.EnrolledStudents = students
+		classroom.Spec.Staff = staff
+		if err := r.Update(ctx, classroom); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// directoryForSync returns the directory of the sync with the credentials of its secret
+func (r *DirectorySyncReconciler) directoryForSync(ctx context.Context, sync *kubelabv1.DirectorySync) (directory.Directory, error) {
+	switch {
+	case sync.Spec.Keycloak != nil && sync.Spec.LDAP == nil:
+		keycloak := sync.Spec.Keycloak
+		secret := &v1.Secret{}
+		if err := r.APIReader.Get(ctx, client.ObjectKey{Name: keycloak.CredentialsSecret.Name, Namespace: keycloak.CredentialsSecret.Namespace}, secret); err != nil {
+			return nil, err
+		}
+		return &directory.Keycloak{
+			URL:          keycloak.URL,
+			Realm:        keycloak.Realm,
+			ClientID:     string(secret.Data["clientId"]),
+			ClientSecret: string(secret.Data["clientSecret"]),
+			IdAttribute:  keycloak.IdAttribute,
+		}, nil
+	case sync.Spec.LDAP != nil && sync.Spec.Keycloak == nil:
+		ldap := sync.Spec.LDAP
+		dir := &directory.LDAP{
+			URL:             ldap.URL,
+			GroupBaseDN:     ldap.GroupBaseDN,
+			MemberAttribute: ldap.MemberAttribute,
+			IdAttribute:     ldap.IdAttribute,
+		}
+		if ldap.CredentialsSecret != nil {
+			secret := &v1.Secret{}
+			if err := r.APIReader.Get(ctx, client.ObjectKey{Name: ldap.CredentialsSecret.Name, Namespace: ldap.CredentialsSecret.Namespace}, secret); err != nil {
+				return nil, err
+			}
+			dir.BindDN = string(secret.Data["bindDN"])
+			dir.Password = string(secret.Data["password"])
+		}
+		return dir, nil
+	default:
+		return nil, errors.New("exactly one of keycloak and ldap has to be set")
+	}
+}
+
+func sortedMemberIds(members map[string]*directoryMember) []string {
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DirectorySyncReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubelabv1.DirectorySync{}).
+		Complete(r)
+}
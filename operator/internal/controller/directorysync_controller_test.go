@@ -0,0 +1,193 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// keycloakStandIn serves the parts of the admin API used by the sync
+func keycloakStandIn(t *testing.T) *httptest.Server {
+	groups := map[string][]map[string]interface{}{
+		"java-students": {
+			{"id": "u1", "username": "575103", "firstName": "Max", "lastName": "Muster", "email": "max@example.com", "enabled": true},
+			{"id": "u2", "username": "575104", "enabled": false},
+			{"id": "u4", "username": "Max_Muster", "enabled": true},
+			{"id": "u5", "username": "575105", "firstName": "Erika", "lastName": "Muster", "enabled": true},
+		},
+		"java-tutors": {
+			{"id": "u3", "username": "tutor01", "enabled": true},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/kubelab/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "token"})
+	})
+	mux.HandleFunc("/admin/realms/kubelab/groups", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("search")
+		_ = json.NewEncoder(w).Encode([]map[string]string{{"id": name, "name": name, "path": "/" + name}})
+	})
+	for name, members := range groups {
+		members := members
+		mux.HandleFunc("/admin/realms/kubelab/groups/"+name+"/members", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(members)
+		})
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// staleLists serves lists from a cache, which does not contain the changes of the reconcile yet
+type staleLists struct {
+	client.Client
+	cache client.Client
+}
+
+func (s *staleLists) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return s.cache.List(ctx, list, opts...)
+}
+
+// Members of the groups become users and are added to the classroom, users which left are disabled.
+func TestDirectorySyncFromKeycloak(t *testing.T) {
+	server := keycloakStandIn(t)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keycloak", Namespace: "kubelab-system"},
+		Data:       map[string][]byte{"clientId": []byte("kubelab"), "clientSecret": []byte("secret")},
+	}
+	sync := &kubelabv1.DirectorySync{
+		ObjectMeta: metav1.ObjectMeta{Name: "keycloak"},
+		Spec: kubelabv1.DirectorySyncSpec{
+			Keycloak: &kubelabv1.KeycloakDirectory{
+				URL:               server.URL,
+				Realm:             "kubelab",
+				CredentialsSecret: v1.SecretReference{Name: "keycloak", Namespace: "kubelab-system"},
+			},
+			Groups: []kubelabv1.DirectoryGroupMapping{
+				{Group: "java-students", Classroom: "java"},
+				{Group: "java-tutors", Roles: []kubelabv1.UserRole{userRoleAssistant}, Classroom: "java", ClassroomRole: staffAssistant},
+			},
+		},
+	}
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java"},
+		Spec: kubelabv1.ClassroomSpec{
+			Staff:            []kubelabv1.ClassroomStaff{{Id: "t01", Role: staffOwner}},
+			EnrolledStudents: []kubelabv1.KubelabUser{{Spec: kubelabv1.KubelabUserSpec{Id: "manual"}}},
+		},
+	}
+	leftUser := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "left", Labels: map[string]string{directorySyncLabel: "keycloak"}},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "left"},
+	}
+	// a user created by hand keeps the settings, which are not part of the directory
+	manualUser := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "575105"},
+		Spec: kubelabv1.KubelabUserSpec{
+			Id: "575105", DisplayName: "Erika", QuotaProfile: "large",
+			Retention: &kubelabv1.UserRetention{Policy: retentionDelete},
+		},
+	}
+	c := newTestClient(secret, sync, classroom, leftUser, manualUser)
+	r := &DirectorySyncReconciler{
+		// the users created by the sync are enrolled without waiting for the cache
+		Client:    &staleLists{Client: c, cache: newTestClient(secret, sync, classroom, leftUser, manualUser)},
+		Scheme:    testScheme,
+		APIReader: c,
+		Recorder:  record.NewFakeRecorder(10),
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: sync.Name}}); err != nil {
+			t.Fatalf("reconcile failed: %v", err)
+		}
+	}
+
+	user := &kubelabv1.KubelabUser{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "575103"}, user); err != nil {
+		t.Fatalf("user was not created: %v", err)
+	}
+	if user.Spec.DisplayName != "Max Muster" || user.Spec.Email != "max@example.com" || user.Spec.Disabled {
+		t.Errorf("profile of the user was not synced: %+v", user.Spec)
+	}
+	if user.Spec.ExternalIdentity == nil || user.Spec.ExternalIdentity.Subject != "u1" {
+		t.Errorf("external identity was not set: %+v", user.Spec.ExternalIdentity)
+	}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "575104"}, user); err != nil || !user.Spec.Disabled {
+		t.Errorf("user disabled inside the directory was not disabled: %v", err)
+	}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "tutor01"}, user); err != nil || !hasRole(user.Spec.Roles, userRoleAssistant) {
+		t.Errorf("role of the group was not given: %v %v", err, user.Spec.Roles)
+	}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "left"}, user); err != nil || !user.Spec.Disabled {
+		t.Errorf("user which left all groups was not disabled: %v", err)
+	}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "575105"}, user); err != nil || user.Labels[directorySyncLabel] != sync.Name {
+		t.Errorf("user created by hand was not taken over: %v", err)
+	}
+	if user.Spec.QuotaProfile != "large" || user.Spec.Retention == nil || user.Spec.DisplayName != "Erika" {
+		t.Errorf("settings of the user created by hand were overwritten: %+v", user.Spec)
+	}
+	users := &kubelabv1.KubelabUserList{}
+	if err := c.List(context.Background(), users); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users.Items {
+		if u.Spec.Id == "Max_Muster" {
+			t.Errorf("user with an invalid id was created: %s", u.Name)
+		}
+	}
+
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "java"}, classroom); err != nil {
+		t.Fatal(err)
+	}
+	if !isEnrolled(classroom.Spec.EnrolledStudents, "manual") || !isEnrolled(classroom.Spec.EnrolledStudents, "575103") || len(classroom.Spec.EnrolledStudents) != 3 {
+		t.Errorf("students were not enrolled: %v", classroom.Spec.EnrolledStudents)
+	}
+	if len(classroom.Spec.Staff) != 2 || classroom.Spec.Staff[1] != (kubelabv1.ClassroomStaff{Id: "tutor01", Role: staffAssistant}) {
+		t.Errorf("assistant was not added to the staff: %v", classroom.Spec.Staff)
+	}
+
+	if err := r.Get(context.Background(), client.ObjectKey{Name: sync.Name}, sync); err != nil {
+		t.Fatal(err)
+	}
+	if sync.Status.Users != 3 || sync.Status.Disabled != 2 {
+		t.Errorf("status counts %d enabled and %d disabled users", sync.Status.Users, sync.Status.Disabled)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// invalidNameCharacters are replaced to get a valid name from the id of a user
+var invalidNameCharacters = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// userForDirectory returns the KubelabUser of a member of the directory, it is not owned by the sync and kept if the sync is removed.
+func userForDirectory(sync *kubelabv1.DirectorySync, member *directoryMember) *kubelabv1.KubelabUser {
+	roles := member.roles
+	if len(roles) == 0 {
+		// the same default the KubelabUser controller would set
+		roles = []kubelabv1.UserRole{userRoleStudent}
+	}
+
+	return &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   userNameForDirectory(member.user.Id),
+			Labels: map[string]string{directorySyncLabel: sync.Name},
+		},
+		Spec: kubelabv1.KubelabUserSpec{
+			Id:          member.user.Id,
+			Roles:       roles,
+			DisplayName: member.user.DisplayName,
+			Email:       member.user.Email,
+			ExternalIdentity: &kubelabv1.ExternalIdentity{
+				Provider: providerOfSync(sync),
+				Subject:  member.user.Subject,
+			},
+			Disabled: !member.user.Enabled,
+		},
+	}
+}
+
+// userNameForDirectory returns a valid name for the KubelabUser with the id
+func userNameForDirectory(id string) string {
+	return strings.Trim(invalidNameCharacters.ReplaceAllString(strings.ToLower(id), "-"), ".-")
+}
+
+func providerOfSync(sync *kubelabv1.DirectorySync) string {
+	if sync.Spec.LDAP != nil {
+		return "ldap"
+	}
+	return "keycloak"
+}
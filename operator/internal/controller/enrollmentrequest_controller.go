@@ -0,0 +1,236 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// EnrollmentRequestReconciler reconciles a EnrollmentRequest object
+type EnrollmentRequestReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=enrollmentrequests,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=enrollmentrequests/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=enrollmentrequests/finalizers,verbs=update
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=classrooms,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=kubelabusers,verbs=get;list;watch
+
+//Custom RBAC
+//+kubebuilder:rbac:groups="rbac.authorization.k8s.io",resources=roles,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="rbac.authorization.k8s.io",resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
+
+func (r *EnrollmentRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Fetch the instance and check if it exist
+	request := &kubelabv1.EnrollmentRequest{}
+	if err := r.Get(ctx, req.NamespacedName, request); err != nil {
+		if apierrors.IsNotFound(err) {
+			// If the custom resource is not found then, it usually means that it was deleted or not created
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get EnrollmentRequest")
+		return ctrl.Result{}, err
+	}
+
+	// set the status as Unknown when no status are available
+	if request.Status.Conditions == nil || len(request.Status.Conditions) == 0 {
+		meta.SetStatusCondition(&request.Status.Conditions, metav1.Condition{Type: typeAvailable, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
+		request.Status.Phase = enrollmentPending
+		if err := r.Status().Update(ctx, request); err != nil {
+			log.Error(err, "Failed to update enrollment request status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Enrolled and rejected requests are final, a deleted request does not remove the student again
+	if !request.ObjectMeta.DeletionTimestamp.IsZero() || isFinalEnrollment(request) {
+		return ctrl.Result{}, nil
+	}
+
+	// The request lives in the namespace of the student, which only the student may create requests in
+	student := request.Namespace
+	classroom := &kubelabv1.Classroom{}
+	if err := r.Get(ctx, client.ObjectKey{Name: request.Spec.Classroom}, classroom); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.setEnrollmentPhase(ctx, request, enrollmentRejected, fmt.Sprintf("Classroom does not exist: %s", request.Spec.Classroom))
+		}
+		log.Error(err, "Failed to get Classroom")
+		return ctrl.Result{}, err
+	}
+
+	// Check validity of the request TO BE REPLACED WITH A VALIDATION WEBHOOK
+	enrollment := classroom.Spec.Enrollment
+	if enrollment == nil || enrollment.JoinCode == "" || enrollment.JoinCode != request.Spec.JoinCode {
+		return r.setEnrollmentPhase(ctx, request, enrollmentRejected, "Invalid join code")
+	}
+
+	userList := &kubelabv1.KubelabUserList{}
+	if err := r.List(ctx, userList, client.MatchingFields{userOwnerKey: student}); err != nil {
+		log.Error(err, "Failed to list KubelabUsers")
+		return ctrl.Result{}, err
+	}
+	if len(userList.Items) == 0 {
+		// the user of a student may be created shortly after the request, e.g. on the first login
+		if _, err := r.setEnrollmentPhase(ctx, request, enrollmentPending, fmt.Sprintf("Student does not exist: %s", student)); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	if userList.Items[0].Spec.Disabled {
+		return r.setEnrollmentPhase(ctx, request, enrollmentRejected, fmt.Sprintf("Student is disabled: %s", student))
+	}
+
+	students, err := studentsOfClassroom(ctx, r.Client, classroom)
+	if err != nil {
+		log.Error(err, "Failed to select students of Classroom")
+		return ctrl.Result{}, err
+	}
+	if isEnrolled(students, student) {
+		return r.setEnrollmentPhase(ctx, request, enrollmentEnrolled, "Already enrolled")
+	}
+	if enrollment.Capacity > 0 && len(students) >= int(enrollment.Capacity) {
+		return r.setEnrollmentPhase(ctx, request, enrollmentRejected, fmt.Sprintf("Classroom is full: %d students", len(students)))
+	}
+
+	if enrollment.RequireApproval {
+		if request.Status.Approved == nil {
+			// the request is reconciled again once the staff sets approved
+			if err := r.ensureApprovers(ctx, request, classroom); err != nil {
+				log.Error(err, "Failed to reconcile approvers of EnrollmentRequest")
+				return ctrl.Result{}, err
+			}
+			return r.setEnrollmentPhase(ctx, request, enrollmentPending, "Waiting for approval")
+		}
+		if !*request.Status.Approved {
+			return r.setEnrollmentPhase(ctx, request, enrollmentRejected, "Rejected by the staff")
+		}
+	}
+
+	// the classroom controller creates the lab of the student
+	classroom.Spec.EnrolledStudents = append(classroom.Spec.EnrolledStudents, kubelabv1.KubelabUser{Spec: kubelabv1.KubelabUserSpec{Id: student}})
+	if err := r.Update(ctx, classroom); err != nil {
+		log.Error(err, "Failed to enroll student", "Classroom", classroom.Name, "Student", student)
+		return ctrl.Result{}, err
+	}
+
+	return r.setEnrollmentPhase(ctx, request, enrollmentEnrolled, fmt.Sprintf("Enrolled in %s", classroom.Name))
+}
+
+// setEnrollmentPhase updates the phase and the condition of the request
+func (r *EnrollmentRequestReconciler) setEnrollmentPhase(ctx context.Context, request *kubelabv1.EnrollmentRequest, phase string, message string) (ctrl.Result, error) {
+	request.Status.Phase = phase
+	request.Status.Message = message
+	meta.SetStatusCondition(&request.Status.Conditions, metav1.Condition{Type: typeAvailable,
+		Status: conditionStatus(phase == enrollmentEnrolled), Reason: phase,
+		Message: message})
+	if err := r.Status().Update(ctx, request); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update enrollment request status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// ensureApprovers gives the owners and teachers of the classroom the right to approve the request, the staff of other classrooms can not see it
+func (r *EnrollmentRequestReconciler) ensureApprovers(ctx context.Context, request *kubelabv1.EnrollmentRequest, classroom *kubelabv1.Classroom) error {
+	role, err := r.roleForApprovers(request)
+	if err != nil {
+		return err
+	}
+	current := &v1rbac.Role{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(role), current); err != nil && apierrors.IsNotFound(err) {
+		if err := r.Create(ctx, role); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	rb, err := r.roleBindingForApprovers(request, classroom)
+	if err != nil {
+		return err
+	}
+	currentBinding := &v1rbac.RoleBinding{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(rb), currentBinding); err != nil && apierrors.IsNotFound(err) {
+		return r.Create(ctx, rb)
+	} else if err != nil {
+		return err
+	}
+	// the staff of the classroom may change while the request is pending
+	if !equality.Semantic.DeepEqual(currentBinding.Subjects, rb.Subjects) {
+		currentBinding.Subjects = rb.Subjects
+		return r.Update(ctx, currentBinding)
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *EnrollmentRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &kubelabv1.EnrollmentRequest{}, enrollmentRequestClassroomKey, func(rawObj client.Object) []string {
+		request := rawObj.(*kubelabv1.EnrollmentRequest)
+		return []string{request.Spec.Classroom}
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubelabv1.EnrollmentRequest{}).
+		Owns(&v1rbac.Role{}).
+		Owns(&v1rbac.RoleBinding{}).
+		Watches(&source.Kind{Type: &kubelabv1.Classroom{}}, handler.EnqueueRequestsFromMapFunc(r.enrollmentRequestsForClassroom)).
+		Complete(r)
+}
+
+// enrollmentRequestsForClassroom enqueues all pending requests of a classroom, so changes of the join code or capacity are applied.
+func (r *EnrollmentRequestReconciler) enrollmentRequestsForClassroom(obj client.Object) []reconcile.Request {
+	requestList := &kubelabv1.EnrollmentRequestList{}
+	if err := r.List(context.Background(), requestList, client.MatchingFields{enrollmentRequestClassroomKey: obj.GetName()}); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, request := range requestList.Items {
+		if !isFinalEnrollment(&request) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: request.Name, Namespace: request.Namespace}})
+		}
+	}
+	return requests
+}
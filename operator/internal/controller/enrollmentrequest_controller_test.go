@@ -0,0 +1,139 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1rbac "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// reconcileEnrollment runs the reconciler several times and returns the phase of the request.
+func reconcileEnrollment(t *testing.T, r *EnrollmentRequestReconciler, student string) string {
+	t.Helper()
+	name := types.NamespacedName{Name: "java-" + student, Namespace: student}
+	for i := 0; i < 5; i++ {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: name}); err != nil {
+			t.Fatalf("reconcile of %s failed: %v", name, err)
+		}
+	}
+	request := &kubelabv1.EnrollmentRequest{}
+	if err := r.Get(context.Background(), name, request); err != nil {
+		t.Fatalf("failed to get %s: %v", name, err)
+	}
+	return request.Status.Phase
+}
+
+// Students with the join code are enrolled after approval, until the classroom is full.
+func TestEnrollmentRequestWithJoinCode(t *testing.T) {
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java"},
+		Spec: kubelabv1.ClassroomSpec{
+			Enrollment: &kubelabv1.ClassroomEnrollment{JoinCode: "k3x9", Capacity: 1, RequireApproval: true},
+			Staff: []kubelabv1.ClassroomStaff{
+				{Id: "owner", Role: staffOwner},
+				{Id: "teacher", Role: staffTeacher},
+				{Id: "assistant", Role: staffAssistant},
+			},
+		},
+	}
+	objects := []client.Object{classroom}
+	for _, id := range []string{"575103", "575104", "575105"} {
+		objects = append(objects,
+			&kubelabv1.KubelabUser{ObjectMeta: metav1.ObjectMeta{Name: id}, Spec: kubelabv1.KubelabUserSpec{Id: id}},
+			&kubelabv1.EnrollmentRequest{ObjectMeta: metav1.ObjectMeta{Name: "java-" + id, Namespace: id}, Spec: kubelabv1.EnrollmentRequestSpec{Classroom: "java", JoinCode: "k3x9"}},
+		)
+	}
+	r := &EnrollmentRequestReconciler{
+		Client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(objects...).
+			WithIndex(&kubelabv1.KubelabUser{}, userOwnerKey, func(obj client.Object) []string {
+				return []string{obj.(*kubelabv1.KubelabUser).Spec.Id}
+			}).Build(),
+		Scheme: testScheme,
+	}
+
+	request := &kubelabv1.EnrollmentRequest{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "java-575105", Namespace: "575105"}, request); err != nil {
+		t.Fatal(err)
+	}
+	request.Spec.JoinCode = "wrong"
+	if err := r.Update(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if phase := reconcileEnrollment(t, r, "575105"); phase != enrollmentRejected {
+		t.Errorf("request with a wrong join code was not rejected: %s", phase)
+	}
+
+	if phase := reconcileEnrollment(t, r, "575103"); phase != enrollmentPending {
+		t.Errorf("request was not waiting for approval: %s", phase)
+	}
+	reconcileEnrollment(t, r, "575104")
+
+	// Only the owners and teachers of the classroom may set the decision of this request
+	rb := &v1rbac.RoleBinding{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "java-575103-approvers", Namespace: "575103"}, rb); err != nil {
+		t.Fatalf("approvers of the request were not bound: %v", err)
+	}
+	approvers := []string{}
+	for _, subject := range rb.Subjects {
+		approvers = append(approvers, subject.Name)
+	}
+	if len(approvers) != 2 || !containsString(approvers, groupPrefix+"owner") || !containsString(approvers, groupPrefix+"teacher") {
+		t.Errorf("unexpected approvers %v", approvers)
+	}
+	role := &v1rbac.Role{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "java-575103-approvers", Namespace: "575103"}, role); err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range role.Rules {
+		if len(rule.ResourceNames) != 1 || rule.ResourceNames[0] != "java-575103" {
+			t.Errorf("approvers may access other requests: %v", rule)
+		}
+	}
+
+	for _, id := range []string{"575103", "575104"} {
+		if err := r.Get(context.Background(), client.ObjectKey{Name: "java-" + id, Namespace: id}, request); err != nil {
+			t.Fatal(err)
+		}
+		approved := true
+		request.Status.Approved = &approved
+		if err := r.Status().Update(context.Background(), request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if phase := reconcileEnrollment(t, r, "575103"); phase != enrollmentEnrolled {
+		t.Errorf("approved request was not enrolled: %s", phase)
+	}
+	if phase := reconcileEnrollment(t, r, "575104"); phase != enrollmentRejected {
+		t.Errorf("request exceeding the capacity was not rejected: %s", phase)
+	}
+
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "java"}, classroom); err != nil {
+		t.Fatal(err)
+	}
+	if len(classroom.Spec.EnrolledStudents) != 1 || !isEnrolled(classroom.Spec.EnrolledStudents, "575103") {
+		t.Errorf("students were not enrolled: %v", classroom.Spec.EnrolledStudents)
+	}
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	v1rbac "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Phases of an enrollment request
+const (
+	enrollmentPending  = "Pending"
+	enrollmentEnrolled = "Enrolled"
+	enrollmentRejected = "Rejected"
+)
+
+// isFinalEnrollment returns true if the request was enrolled or rejected, a rejected student has to create a new request
+func isFinalEnrollment(request *kubelabv1.EnrollmentRequest) bool {
+	return request.Status.Phase == enrollmentEnrolled || request.Status.Phase == enrollmentRejected
+}
+
+// approversName returns the name of the role and rolebinding of the staff approving the request
+func approversName(request *kubelabv1.EnrollmentRequest) string {
+	return request.Name + "-approvers"
+}
+
+// roleForApprovers returns role to read the request and to set the decision in its status.
+// The student may only create the request, so nobody but the approvers can change the decision.
+func (r *EnrollmentRequestReconciler) roleForApprovers(request *kubelabv1.EnrollmentRequest) (*v1rbac.Role, error) {
+
+	role := &v1rbac.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      approversName(request),
+			Namespace: request.Namespace,
+		},
+		Rules: []v1rbac.PolicyRule{
+			{
+				APIGroups:     []string{"kubelab.kubelab.local"},
+				Resources:     []string{"enrollmentrequests"},
+				ResourceNames: []string{request.Name},
+				Verbs:         []string{"get"},
+			},
+			{
+				APIGroups:     []string{"kubelab.kubelab.local"},
+				Resources:     []string{"enrollmentrequests/status"},
+				ResourceNames: []string{request.Name},
+				Verbs:         []string{"get", "update", "patch"},
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(request, role, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// roleBindingForApprovers returns rolebinding giving the owners and teachers of the requested classroom the role of the approvers.
+func (r *EnrollmentRequestReconciler) roleBindingForApprovers(request *kubelabv1.EnrollmentRequest, classroom *kubelabv1.Classroom) (*v1rbac.RoleBinding, error) {
+
+	rb := &v1rbac.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      approversName(request),
+			Namespace: request.Namespace,
+		},
+		Subjects: append(subjectsForClassroomStaff(classroom, staffOwner), subjectsForClassroomStaff(classroom, staffTeacher)...),
+		RoleRef: v1rbac.RoleRef{
+			Kind:     "Role",
+			Name:     approversName(request),
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}
+
+	if err := ctrl.SetControllerReference(request, rb, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return rb, nil
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// GradingRunReconciler reconciles a GradingRun object
+type GradingRunReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Clientset is needed to read the output of the graders, which the controller-runtime client does not support
+	Clientset kubernetes.Interface
+}
+
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=gradingruns,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=gradingruns/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=gradingruns/finalizers,verbs=update
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=assignments,verbs=get;list;watch
+
+//Custom RBAC
+//+kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods/log,verbs=get
+
+func (r *GradingRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Fetch the instance and check if it exist
+	run := &kubelabv1.GradingRun{}
+	if err := r.Get(ctx, req.NamespacedName, run); err != nil {
+		if apierrors.IsNotFound(err) {
+			// If the custom resource is not found then, it usually means that it was deleted or not created
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get GradingRun")
+		return ctrl.Result{}, err
+	}
+
+	// set the status as Unknown when no status are available
+	if run.Status.Conditions == nil || len(run.Status.Conditions) == 0 {
+		meta.SetStatusCondition(&run.Status.Conditions, metav1.Condition{Type: typeAvailable, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
+		if err := r.Status().Update(ctx, run); err != nil {
+			log.Error(err, "Failed to update grading run status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Jobs and results are owned by the grading run and get removed with it
+	if !run.ObjectMeta.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	assignment := &kubelabv1.Assignment{}
+	if err := r.Get(ctx, client.ObjectKey{Name: run.Spec.Assignment, Namespace: run.Namespace}, assignment); err != nil {
+		if apierrors.IsNotFound(err) {
+			meta.SetStatusCondition(&run.Status.Conditions, metav1.Condition{Type: typeAvailable,
+				Status: metav1.ConditionFalse, Reason: "Validating",
+				Message: fmt.Sprintf("Assignment does not exist: %s", run.Spec.Assignment)})
+			if err := r.Status().Update(ctx, run); err != nil {
+				log.Error(err, "Failed to update grading run status")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: time.Minute}, nil
+		}
+		log.Error(err, "Failed to get Assignment")
+		return ctrl.Result{}, err
+	}
+
+	// Finished results are kept, so the output only has to be read once
+	previous := make(map[string]kubelabv1.GradingResult, len(run.Status.Results))
+	for _, result := range run.Status.Results {
+		previous[result.Id] = result
+	}
+
+	results := make([]kubelabv1.GradingResult, 0, len(assignment.Status.Students))
+	finished := 0
+	for _, student := range assignment.Status.Students {
+		// only collected work can be graded
+		if !student.Collected {
+			continue
+		}
+
+		result, ok := previous[student.Id]
+		if !ok {
+			result = kubelabv1.GradingResult{Id: student.Id, Phase: gradingPending}
+		}
+		if isFinished(result) {
+			finished++
+			results = append(results, result)
+			continue
+		}
+
+		job := &batchv1.Job{}
+		err := r.Get(ctx, types.NamespacedName{Name: jobName(run.Name, "grade", student.Id), Namespace: run.Namespace}, job)
+		if err != nil && apierrors.IsNotFound(err) {
+			job, err := r.jobForGradingRun(run, assignment, student.Id)
+			if err != nil {
+				log.Error(err, "Failed to define new grading Job for GradingRun")
+				return ctrl.Result{}, err
+			}
+
+			if err = r.Create(ctx, job); err != nil {
+				log.Error(err, "Failed to create new grading Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+				return ctrl.Result{}, err
+			}
+		} else if err != nil {
+			log.Error(err, "Failed to get grading Job")
+			return ctrl.Result{}, err
+		} else if job.Status.Succeeded > 0 || failedCondition(job) != nil {
+			if err := r.readResult(ctx, run, job, &result); err != nil {
+				log.Error(err, "Failed to read grading result", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+				return ctrl.Result{}, err
+			}
+			if job.Status.Succeeded > 0 {
+				result.Phase = gradingSucceeded
+			} else {
+				result.Phase = gradingFailed
+			}
+			finished++
+		} else {
+			result.Phase = gradingRunning
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Id < results[j].Id })
+	run.Status.Results = results
+
+	// Export the results as CSV, so they can be imported into other tools
+	configMap := &v1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: resultsConfigMapName(run), Namespace: run.Namespace}, configMap)
+	if err != nil && apierrors.IsNotFound(err) {
+		cm, err := r.configMapForGradingRun(run, assignment)
+		if err != nil {
+			log.Error(err, "Failed to define new results ConfigMap for GradingRun")
+			return ctrl.Result{}, err
+		}
+
+		if err = r.Create(ctx, cm); err != nil {
+			log.Error(err, "Failed to create new results ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return ctrl.Result{}, err
+		}
+	} else if err != nil {
+		log.Error(err, "Failed to get results ConfigMap")
+		return ctrl.Result{}, err
+	} else if csv := resultsToCSV(results); configMap.Data[resultsKey] != csv {
+		configMap.Data = map[string]string{resultsKey: csv}
+		if err := r.Update(ctx, configMap); err != nil {
+			log.Error(err, "Failed to update results ConfigMap", "ConfigMap.Namespace", configMap.Namespace, "ConfigMap.Name", configMap.Name)
+			return ctrl.Result{}, err
+		}
+	}
+	run.Status.ResultsConfigMap = resultsConfigMapName(run)
+
+	meta.SetStatusCondition(&run.Status.Conditions, metav1.Condition{Type: typeAvailable,
+		Status: conditionStatus(finished == len(results)), Reason: "Grading",
+		Message: fmt.Sprintf("Graded %d of %d submissions", finished, len(results))})
+
+	if err := r.Status().Update(ctx, run); err != nil {
+		log.Error(err, "Failed to update grading run status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// readResult reads exit code and score of the grader from the pod of the job and keeps its output in a ConfigMap.
+func (r *GradingRunReconciler) readResult(ctx context.Context, run *kubelabv1.GradingRun, job *batchv1.Job, result *kubelabv1.GradingResult) error {
+	podList := &v1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return err
+	}
+	if len(podList.Items) == 0 {
+		return nil
+	}
+	pod := podList.Items[0]
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != graderContainer || status.State.Terminated == nil {
+			continue
+		}
+		exitCode := status.State.Terminated.ExitCode
+		result.ExitCode = &exitCode
+		// the score file is used as termination message, see jobForGradingRun
+		result.Score = status.State.Terminated.Message
+		if len(result.Score) > graderScoreBytes {
+			result.Score = result.Score[:graderScoreBytes]
+		}
+	}
+
+	tailLines := int64(graderOutputLines)
+	limitBytes := int64(graderOutputBytes)
+	output, err := r.Clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+		Container:  graderContainer,
+		TailLines:  &tailLines,
+		LimitBytes: &limitBytes,
+	}).DoRaw(ctx)
+	if err != nil {
+		// the output is only informational, the pod might already be gone
+		return nil
+	}
+	cm, err := r.configMapForOutput(run, result.Id, string(output))
+	if err != nil {
+		return err
+	}
+	// a result read again, e.g. after the status update failed, replaces the output
+	err = r.Create(ctx, cm)
+	if apierrors.IsAlreadyExists(err) {
+		err = r.Update(ctx, cm)
+	}
+	if err != nil {
+		return err
+	}
+	result.OutputConfigMap = cm.Name
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *GradingRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &kubelabv1.GradingRun{}, gradingRunAssignmentKey, func(rawObj client.Object) []string {
+		run := rawObj.(*kubelabv1.GradingRun)
+		return []string{run.Spec.Assignment}
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubelabv1.GradingRun{}).
+		Owns(&batchv1.Job{}).
+		Owns(&v1.ConfigMap{}).
+		Watches(&source.Kind{Type: &kubelabv1.Assignment{}}, handler.EnqueueRequestsFromMapFunc(r.gradingRunsForAssignment)).
+		Complete(r)
+}
+
+// gradingRunsForAssignment enqueues all grading runs of an assignment, so work collected later gets graded as well.
+func (r *GradingRunReconciler) gradingRunsForAssignment(obj client.Object) []reconcile.Request {
+	runList := &kubelabv1.GradingRunList{}
+	if err := r.List(context.Background(), runList, client.InNamespace(obj.GetNamespace()), client.MatchingFields{gradingRunAssignmentKey: obj.GetName()}); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(runList.Items))
+	for _, run := range runList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: run.Name, Namespace: run.Namespace}})
+	}
+	return requests
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// A grading run only grades the assignments of the classroom in whose namespace it was created.
+func TestGradingRunOfOtherClassroomIsRejected(t *testing.T) {
+	assignment := &kubelabv1.Assignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "exercise-1", Namespace: "java"},
+		Spec:       kubelabv1.AssignmentSpec{Source: "exercise-1"},
+		Status: kubelabv1.AssignmentStatus{Students: []kubelabv1.AssignmentStudentStatus{
+			{Id: "575103", HandedOut: true, Collected: true},
+		}},
+	}
+	condition := []metav1.Condition{{Type: typeAvailable, Status: metav1.ConditionUnknown, Reason: "Reconciling"}}
+	foreign := &kubelabv1.GradingRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "steal", Namespace: "python"},
+		Spec:       kubelabv1.GradingRunSpec{Assignment: "exercise-1", Image: "grader:1"},
+		Status:     kubelabv1.GradingRunStatus{Conditions: condition},
+	}
+	own := &kubelabv1.GradingRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "grade", Namespace: "java"},
+		Spec:       kubelabv1.GradingRunSpec{Assignment: "exercise-1", Image: "grader:1"},
+		Status:     kubelabv1.GradingRunStatus{Conditions: condition},
+	}
+	r := &GradingRunReconciler{Client: newTestClient(assignment, foreign, own), Scheme: testScheme}
+	ctx := context.Background()
+
+	for _, run := range []*kubelabv1.GradingRun{foreign, own} {
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(run)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.Get(ctx, client.ObjectKeyFromObject(foreign), foreign); err != nil {
+		t.Fatal(err)
+	}
+	if meta.IsStatusConditionTrue(foreign.Status.Conditions, typeAvailable) || len(foreign.Status.Results) > 0 {
+		t.Errorf("grading run of another classroom was accepted: %v", foreign.Status)
+	}
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 1 || jobs.Items[0].Namespace != "java" || jobs.Items[0].Labels["gradingrun"] != "grade" {
+		t.Errorf("expected only the grader of the own classroom, got %v", jobs.Items)
+	}
+}
+
+// The output of the grader is kept in a ConfigMap per student and the score is cut, so the status stays small for large classrooms.
+func TestGradingOutputIsKeptOutOfTheStatus(t *testing.T) {
+	assignment := &kubelabv1.Assignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "exercise-1", Namespace: "java"},
+		Spec:       kubelabv1.AssignmentSpec{Source: "exercise-1"},
+		Status: kubelabv1.AssignmentStatus{Students: []kubelabv1.AssignmentStudentStatus{
+			{Id: "575103", HandedOut: true, Collected: true},
+		}},
+	}
+	run := &kubelabv1.GradingRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "grade", Namespace: "java"},
+		Spec:       kubelabv1.GradingRunSpec{Assignment: "exercise-1", Image: "grader:1"},
+		Status: kubelabv1.GradingRunStatus{Conditions: []metav1.Condition{
+			{Type: typeAvailable, Status: metav1.ConditionUnknown, Reason: "Reconciling"},
+		}},
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: jobName("grade", "grade", "575103"), Namespace: "java"},
+		Status:     batchv1.JobStatus{Succeeded: 1},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-abcde", Namespace: "java", Labels: map[string]string{"job-name": job.Name}},
+		Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+			Name:  graderContainer,
+			State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Message: strings.Repeat("7", 4096)}},
+		}}},
+	}
+	r := &GradingRunReconciler{
+		Client:    newTestClient(assignment, run, job, pod),
+		Scheme:    testScheme,
+		Clientset: fake.NewSimpleClientset(pod),
+	}
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(run)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(run), run); err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Status.Results) != 1 {
+		t.Fatalf("unexpected results %v", run.Status.Results)
+	}
+	result := run.Status.Results[0]
+	if result.Phase != gradingSucceeded || len(result.Score) != graderScoreBytes {
+		t.Errorf("score of %d bytes was not cut: phase %s", len(result.Score), result.Phase)
+	}
+	output := &v1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Name: result.OutputConfigMap, Namespace: "java"}, output); err != nil {
+		t.Fatalf("output was not kept: %v", err)
+	}
+	if output.Data[outputKey] == "" || !metav1.IsControlledBy(output, run) {
+		t.Errorf("unexpected output ConfigMap %v", output)
+	}
+}
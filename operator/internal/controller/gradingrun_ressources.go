@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Phases of a grading result
+const (
+	gradingPending   = "Pending"
+	gradingRunning   = "Running"
+	gradingSucceeded = "Succeeded"
+	gradingFailed    = "Failed"
+)
+
+const graderContainer = "grader"
+const resultsKey = "results.csv"
+const outputKey = "output.log"
+
+// jobForGradingRun returns a job running the grader against the collected work of the student.
+func (r *GradingRunReconciler) jobForGradingRun(run *kubelabv1.GradingRun, assignment *kubelabv1.Assignment, student string) (*batchv1.Job, error) {
+	ls := map[string]string{
+		"app.kubernetes.io/name":       "KubelabGradingRun",
+		"app.kubernetes.io/instance":   run.Name,
+		"app.kubernetes.io/version":    "1",
+		"app.kubernetes.io/part-of":    "gradingrun-operator",
+		"app.kubernetes.io/created-by": "controller-manager",
+		"gradingrun":                   run.Name,
+		"student":                      student,
+	}
+	// the grader is only run once, a failing grader is a result as well
+	backoffLimit := int32(0)
+	deadline := int64(graderTimeout.Seconds())
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName(run.Name, "grade", student),
+			Namespace: run.Namespace,
+			Labels:    ls,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Image:   run.Spec.Image,
+						Name:    graderContainer,
+						Command: run.Spec.Command,
+						// the kubelet copies the score file into the status of the pod
+						TerminationMessagePath: graderScoreFile,
+						Env: []v1.EnvVar{
+							{
+								Name:  "SUBMISSION_DIR",
+								Value: "/submission",
+							},
+							{
+								Name:  "SCORE_FILE",
+								Value: graderScoreFile,
+							},
+							{
+								Name:  "STUDENT",
+								Value: student,
+							},
+						},
+						VolumeMounts: []v1.VolumeMount{
+							{
+								Name:      "submission",
+								MountPath: "/submission",
+								ReadOnly:  true,
+							},
+						},
+					}},
+					Volumes: []v1.Volume{
+						{
+							Name: "submission",
+							VolumeSource: v1.VolumeSource{
+								NFS: &v1.NFSVolumeSource{
+									Server:   nfsServer,
+									Path:     nfsPath + "/collected/" + run.Namespace + "/" + assignment.Name + "/" + student, // see collectJobForAssignment
+									ReadOnly: true,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(run, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// configMapForGradingRun returns a configmap containing the results as CSV.
+func (r *GradingRunReconciler) configMapForGradingRun(run *kubelabv1.GradingRun, assignment *kubelabv1.Assignment) (*v1.ConfigMap, error) {
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resultsConfigMapName(run),
+			Namespace: run.Namespace,
+		},
+		Data: map[string]string{
+			resultsKey: resultsToCSV(run.Status.Results),
+		},
+	}
+
+	if err := ctrl.SetControllerReference(run, cm, r.Scheme); err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+func resultsConfigMapName(run *kubelabv1.GradingRun) string {
+	return run.Name + "-results"
+}
+
+// configMapForOutput returns a configmap containing the tail of the output of the grader for one student.
+func (r *GradingRunReconciler) configMapForOutput(run *kubelabv1.GradingRun, student string, output string) (*v1.ConfigMap, error) {
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName(run.Name, "output", student),
+			Namespace: run.Namespace,
+		},
+		Data: map[string]string{
+			outputKey: output,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(run, cm, r.Scheme); err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+// resultsToCSV returns the results with one line per student.
+// If the score file is a JSON object, the fields score and maxScore get their own columns.
+func resultsToCSV(results []kubelabv1.GradingResult) string {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	_ = w.Write([]string{"student", "phase", "exitCode", "score", "maxScore", "raw"})
+	for _, result := range results {
+		exitCode := ""
+		if result.ExitCode != nil {
+			exitCode = fmt.Sprint(*result.ExitCode)
+		}
+		score := struct {
+			Score    json.Number `json:"score"`
+			MaxScore json.Number `json:"maxScore"`
+		}{}
+		_ = json.Unmarshal([]byte(result.Score), &score)
+		_ = w.Write([]string{result.Id, result.Phase, exitCode, score.Score.String(), score.MaxScore.String(), result.Score})
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func isFinished(result kubelabv1.GradingResult) bool {
+	return result.Phase == gradingSucceeded || result.Phase == gradingFailed
+}
@@ -1,21 +1,415 @@
 package controller
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
 	v1apps "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
 	kubelabv1 "kubelab.local/kubelab/api/v1"
+	"kubelab.local/kubelab/internal/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // Definitions to manage status conditions
 const (
 	typeAvailable = "Available"
 	typeDegraded  = "Degraded"
+	typeCollected = "Collected"
+	// the quota profile of a user exists and is valid
+	typeQuotaProfile = "QuotaProfile"
 )
 
-func isInClass(students []kubelabv1.KubelabUser, deployment v1apps.Deployment) bool {
+// clusterUserRoles are the roles of users, which get a ClusterRole shared by all users with the role
+var clusterUserRoles = []kubelabv1.UserRole{userRoleStudent, userRoleTeacher, userRoleAssistant, userRoleAdmin, userRoleAuditor}
+
+// staffRoles are the roles of the staff of a classroom, every role gets its own RBAC
+var staffRoles = []string{staffOwner, staffTeacher, staffAssistant}
+
+// replicasOfLab returns the replicas of the StatefulSet of a lab or of the Deployment of a lab created by an older version.
+// Unset replicas default to 1, like the API server does.
+func replicasOfLab(lab client.Object) *int32 {
+	var replicas **int32
+	switch lab := lab.(type) {
+	case *v1apps.StatefulSet:
+		replicas = &lab.Spec.Replicas
+	case *v1apps.Deployment:
+		replicas = &lab.Spec.Replicas
+	default:
+		return new(int32)
+	}
+	if *replicas == nil {
+		one := int32(1)
+		*replicas = &one
+	}
+	return *replicas
+}
+
+func hasVolume(lab *v1apps.StatefulSet, name string) bool {
+	for _, volume := range lab.Spec.Template.Spec.Volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func conditionStatus(ok bool) metav1.ConditionStatus {
+	if ok {
+		return metav1.ConditionTrue
+	}
+	return metav1.ConditionFalse
+}
+
+// isValidSource checks that the path stays inside the class share
+func isValidSource(source string) bool {
+	if source == "" || filepath.IsAbs(source) {
+		return false
+	}
+	clean := filepath.Clean(source)
+	return clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// jobName returns the name of the job of an assignment or grading run for one student.
+// The name is the job-name label of its pods, so long names are truncated and kept unique by a hash like the generated names of Kubernetes
+func jobName(name string, action string, student string) string {
+	full := name + "-" + action + "-" + student
+	if len(full) <= validation.LabelValueMaxLength {
+		return full
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(full))
+	suffix := fmt.Sprintf("-%08x", hash.Sum32())
+	return strings.TrimRight(full[:validation.LabelValueMaxLength-len(suffix)], "-.") + suffix
+}
+
+// jobState returns if the job succeeded and a message describing its state
+func jobState(job *batchv1.Job, done string) (bool, string) {
+	if job.Status.Succeeded > 0 {
+		return true, done
+	}
+	if condition := failedCondition(job); condition != nil {
+		return false, "Failed: " + condition.Message
+	}
+	return false, "Running"
+}
+
+// failedCondition returns the failed condition of the job if it exists
+func failedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == v1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// usersWithRole returns the users with the role, which are neither disabled nor being deleted
+func usersWithRole(users []kubelabv1.KubelabUser, role kubelabv1.UserRole) []kubelabv1.KubelabUser {
+	result := []kubelabv1.KubelabUser{}
+	for _, user := range users {
+		if hasRole(rolesOfUser(&user), role) && !user.Spec.Disabled && user.ObjectMeta.DeletionTimestamp.IsZero() {
+			result = append(result, user)
+		}
+	}
+	return result
+}
+
+// rolesOfUser returns the roles of the user, the teacher flag of older users is converted into the role teacher
+// and users without any role are students
+func rolesOfUser(user *kubelabv1.KubelabUser) []kubelabv1.UserRole {
+	roles := append([]kubelabv1.UserRole{}, user.Spec.Roles...)
+	if user.Spec.IsTeacher && !hasRole(roles, userRoleTeacher) {
+		roles = append(roles, userRoleTeacher)
+	}
+	if len(roles) == 0 {
+		roles = append(roles, userRoleStudent)
+	}
+	return roles
+}
+
+func hasRole(roles []kubelabv1.UserRole, role kubelabv1.UserRole) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// staffOfClassroom returns the staff of the classroom, the teacher of older classrooms is added as owner
+func staffOfClassroom(classroom *kubelabv1.Classroom) []kubelabv1.ClassroomStaff {
+	staff := []kubelabv1.ClassroomStaff{}
+	teacher := classroom.Spec.Teacher.Spec.Id
+	if teacher != "" && !isStaff(classroom.Spec.Staff, teacher) {
+		staff = append(staff, kubelabv1.ClassroomStaff{Id: teacher, Role: staffOwner})
+	}
+	return append(staff, classroom.Spec.Staff...)
+}
+
+// ownerOfClassroom returns the id of the first owner of the classroom
+func ownerOfClassroom(staff []kubelabv1.ClassroomStaff) string {
+	for _, member := range staff {
+		if member.Role == staffOwner {
+			return member.Id
+		}
+	}
+	return ""
+}
+
+func isStaff(staff []kubelabv1.ClassroomStaff, id string) bool {
+	for _, member := range staff {
+		if member.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// hasReadOnlyVolume checks if the NFS volume of the lab is read only
+func hasReadOnlyVolume(lab *v1apps.StatefulSet, name string) bool {
+	for _, volume := range lab.Spec.Template.Spec.Volumes {
+		if volume.Name == name && volume.NFS != nil {
+			return volume.NFS.ReadOnly
+		}
+	}
+	return false
+}
+
+// quotaExceeded returns a message if the pod of the stopped or failed lab does not fit into the ResourceQuota of its namespace.
+// A StatefulSet has no condition for rejected pods like a Deployment, so the requests of the pod are compared with the quota.
+func quotaExceeded(lab *v1apps.StatefulSet, quota *v1.ResourceQuota) string {
+	if quota == nil || lab.Status.Replicas > 0 {
+		// a created pod is already part of the used resources
+		return ""
+	}
+	requested := v1.ResourceList{v1.ResourcePods: resource.MustParse("1")}
+	add := func(name v1.ResourceName, quantity resource.Quantity) {
+		sum := requested[name]
+		sum.Add(quantity)
+		requested[name] = sum
+	}
+	for _, container := range lab.Spec.Template.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			add(name, quantity)
+			add(v1.ResourceName("requests."+name), quantity)
+		}
+		for name, quantity := range container.Resources.Limits {
+			add(v1.ResourceName("limits."+name), quantity)
+		}
+	}
+	names := []string{}
+	for name := range quota.Status.Hard {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hard, need, used := quota.Status.Hard[v1.ResourceName(name)], requested[v1.ResourceName(name)], quota.Status.Used[v1.ResourceName(name)]
+		if need.IsZero() {
+			continue
+		}
+		total := used.DeepCopy()
+		total.Add(need)
+		if total.Cmp(hard) > 0 {
+			return fmt.Sprintf("exceeded quota: %s, requested: %s=%s, used: %s=%s, limited: %s=%s",
+				quota.Name, name, need.String(), name, used.String(), name, hard.String())
+		}
+	}
+	return ""
+}
+
+// quotaOfLab returns the ResourceQuota the pod of the lab has to fit into or nil if the namespace has none.
+// A warm pod waiting for the lab is already part of the used resources, so no quota is returned then.
+func quotaOfLab(ctx context.Context, c client.Client, lab *v1apps.StatefulSet) (*v1.ResourceQuota, error) {
+	if err := c.Get(ctx, client.ObjectKey{Name: labPodName(lab), Namespace: lab.Namespace}, &v1.Pod{}); err == nil {
+		return nil, nil
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	quota := &v1.ResourceQuota{}
+	if err := c.Get(ctx, client.ObjectKey{Name: quotaName, Namespace: lab.Namespace}, quota); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return quota, nil
+}
+
+// labPodName returns the name of the only pod of the StatefulSet of a lab
+func labPodName(lab *v1apps.StatefulSet) string {
+	return lab.Name + "-0"
+}
+
+// podSecurityLevelOfUser returns the most permissive Pod Security Admission level of the labs of the user,
+// the namespace is shared by the labs of all classrooms of the user
+func podSecurityLevelOfUser(classroom *kubelabv1.Classroom, classrooms []kubelabv1.Classroom, id string) string {
+	permissive := map[string]int{"restricted": 0, "baseline": 1, "privileged": 2}
+	level := podSecurityLevel(securityProfileOfClassroom(classroom))
+	for i := range classrooms {
+		other := &classrooms[i]
+		member := containsString(other.Status.Students, id)
+		for _, staff := range staffOfClassroom(other) {
+			member = member || staff.Id == id
+		}
+		if other.Name != classroom.Name && member {
+			if otherLevel := podSecurityLevel(securityProfileOfClassroom(other)); permissive[otherLevel] > permissive[level] {
+				level = otherLevel
+			}
+		}
+	}
+	return level
+}
+
+// pullTemplateOutdated returns true if the image or the nodes of the pre-pull DaemonSet changed.
+// The API server adds defaults to the template, so only the fields set from the classroom are compared.
+// A template without the pull container, e.g. edited by hand, is outdated as well.
+func pullTemplateOutdated(current *v1.PodSpec, wanted *v1.PodSpec) bool {
+	if len(current.InitContainers) == 0 || len(wanted.InitContainers) == 0 {
+		return true
+	}
+	return current.InitContainers[0].Image != wanted.InitContainers[0].Image ||
+		!equality.Semantic.DeepEqual(current.NodeSelector, wanted.NodeSelector) ||
+		!equality.Semantic.DeepEqual(current.Tolerations, wanted.Tolerations) ||
+		!equality.Semantic.DeepEqual(current.ImagePullSecrets, wanted.ImagePullSecrets)
+}
+
+func isEnrolled(students []kubelabv1.KubelabUser, id string) bool {
 	for _, student := range students {
-		if student.Spec.Id == deployment.Namespace {
+		if student.Spec.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
 			return true
 		}
 	}
 	return false
 }
+
+func valueOrDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// studentsOfClassroom returns the enrolled students followed by the users selected by the student selector,
+// selected users are skipped if they are disabled or being deleted
+func studentsOfClassroom(ctx context.Context, c client.Reader, classroom *kubelabv1.Classroom) ([]kubelabv1.KubelabUser, error) {
+	students := append([]kubelabv1.KubelabUser{}, classroom.Spec.EnrolledStudents...)
+	if classroom.Spec.StudentSelector == nil {
+		return students, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(classroom.Spec.StudentSelector)
+	if err != nil {
+		return nil, err
+	}
+	userList := &kubelabv1.KubelabUserList{}
+	if err := c.List(ctx, userList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	for _, user := range userList.Items {
+		if !user.Spec.Disabled && user.ObjectMeta.DeletionTimestamp.IsZero() && !isEnrolled(students, user.Spec.Id) {
+			students = append(students, user)
+		}
+	}
+	return students, nil
+}
+
+// studentIds returns the ids of the students
+func studentIds(students []kubelabv1.KubelabUser) []string {
+	ids := make([]string, 0, len(students))
+	for _, student := range students {
+		ids = append(ids, student.Spec.Id)
+	}
+	return ids
+}
+
+// phaseOfClassroom returns the phase set in the spec, or the phase given by the start and end date
+func phaseOfClassroom(classroom *kubelabv1.Classroom, now time.Time) string {
+	if classroom.Spec.Phase != "" {
+		return classroom.Spec.Phase
+	}
+	if classroom.Spec.StartDate != nil && now.Before(classroom.Spec.StartDate.Time) {
+		return classroomDraft
+	}
+	if classroom.Spec.EndDate != nil && !now.Before(classroom.Spec.EndDate.Time) {
+		return classroomClosed
+	}
+	return classroomActive
+}
+
+// nextPhaseChange returns the duration until the start or end date changes the phase, 0 if there is none
+func nextPhaseChange(classroom *kubelabv1.Classroom, now time.Time) time.Duration {
+	if classroom.Spec.Phase != "" {
+		return 0
+	}
+	for _, date := range []*metav1.Time{classroom.Spec.StartDate, classroom.Spec.EndDate} {
+		if date != nil && now.Before(date.Time) {
+			return date.Sub(now)
+		}
+	}
+	return 0
+}
+
+// retentionOfUser returns the retention policy of the user and how long a retained volume is kept
+func retentionOfUser(user *kubelabv1.KubelabUser) (string, time.Duration) {
+	policy, days := retentionRetain, int32(userRetentionDays)
+	if retention := user.Spec.Retention; retention != nil {
+		policy = valueOrDefault(retention.Policy, retentionRetain)
+		if retention.Days > 0 {
+			days = retention.Days
+		}
+	}
+	return policy, time.Duration(days) * 24 * time.Hour
+}
+
+// recordEvent records the action (Create, Update or Delete) on a child resource as an Event of the owner
+// and returns the error of the action. Failed actions are recorded as a Warning, deleting a resource
+// which is already gone is not recorded at all.
+func recordEvent(recorder record.EventRecorder, owner runtime.Object, action string, child client.Object, err error) error {
+	kind := reflect.Indirect(reflect.ValueOf(child)).Type().Name()
+	name := child.GetName()
+	if child.GetNamespace() != "" {
+		name = child.GetNamespace() + "/" + name
+	}
+
+	if err == nil {
+		recorder.Eventf(owner, v1.EventTypeNormal, action+"d", "%sd %s %s", action, kind, name)
+	} else if action != "Delete" || !apierrors.IsNotFound(err) {
+		recordWarning(recorder, owner, "Failed"+action, fmt.Sprintf("Failed to %s %s %s: %s", strings.ToLower(action), kind, name, err))
+	}
+	return err
+}
+
+// recordNormal records a Normal Event of the owner, which is not related to an action on a child resource
+func recordNormal(recorder record.EventRecorder, owner runtime.Object, reason string, message string) {
+	recorder.Event(owner, v1.EventTypeNormal, reason, message)
+}
+
+// recordWarning records a Warning Event of the owner and counts it as a reconcile failure
+func recordWarning(recorder record.EventRecorder, owner runtime.Object, reason string, message string) {
+	recorder.Event(owner, v1.EventTypeWarning, reason, message)
+	metrics.ReconcileFailures.WithLabelValues(reflect.Indirect(reflect.ValueOf(owner)).Type().Name(), reason).Inc()
+}
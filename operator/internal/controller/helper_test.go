@@ -0,0 +1,74 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// testScheme knows the core and the kubelab types, it is shared by the tests using the fake client
+var testScheme = func() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(kubelabv1.AddToScheme(scheme))
+	return scheme
+}()
+
+// newTestClient returns a fake client holding the objects
+func newTestClient(objs ...client.Object) client.WithWatch {
+	return fake.NewClientBuilder().WithScheme(testScheme).WithObjects(objs...).Build()
+}
+
+// Long names of assignments, classrooms or students still result in a valid job-name label.
+func TestJobNameFitsLabel(t *testing.T) {
+	if name := jobName("java", "handout", "575103"); name != "java-handout-575103" {
+		t.Errorf("short name was changed: %s", name)
+	}
+
+	long := strings.Repeat("object-oriented-programming-", 3)
+	first, second := jobName(long, "collect", "575103"), jobName(long, "collect", "575104")
+	for _, name := range []string{first, second} {
+		if errs := validation.IsValidLabelValue(name); len(errs) > 0 {
+			t.Errorf("%s is no valid label value: %v", name, errs)
+		}
+	}
+	if first == second {
+		t.Errorf("truncated names of different students collide: %s", first)
+	}
+}
+
+// A pre-pull template without the pull container, e.g. edited by hand, is replaced.
+func TestPullTemplateWithoutPullContainer(t *testing.T) {
+	wanted := &v1.PodSpec{InitContainers: []v1.Container{{Name: "pull", Image: "java:1"}}}
+	if !pullTemplateOutdated(&v1.PodSpec{}, wanted) {
+		t.Error("template without the pull container is not outdated")
+	}
+	if pullTemplateOutdated(wanted.DeepCopy(), wanted) {
+		t.Error("unchanged template is outdated")
+	}
+}
@@ -21,17 +21,25 @@ import (
 	"fmt"
 	"time"
 
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	v1rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/yaml"
 
 	kubelabv1 "kubelab.local/kubelab/api/v1"
 )
@@ -39,7 +47,8 @@ import (
 // KubelabUserReconciler reconciles a KubelabUser object
 type KubelabUserReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=kubelabusers,verbs=get;list;watch;create;update;patch;delete
@@ -53,8 +62,15 @@ type KubelabUserReconciler struct {
 //+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=limitranges,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;update;patch;delete
 // to grant permissions the controller needs to have them as well
 //+kubebuilder:rbac:groups="apps",resources=deployments,verbs=get;list;watch;create;update;patch;delete;scale
+//+kubebuilder:rbac:groups="apps",resources=statefulsets;statefulsets/scale,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
 
 func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -104,34 +120,27 @@ func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				return ctrl.Result{}, err
 			}
 
+			// The private folder is retained, archived or deleted with the namespace
+			if done, err := r.retainUserData(ctx, user); err != nil {
+				log.Error(err, "Failed to retain data of user")
+				return ctrl.Result{}, err
+			} else if !done {
+				return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+			}
+
 			// Since the Owners Reference does not delete the Namespace the Finalizer is used
 			ns := &v1.Namespace{}
 			if err := r.Get(ctx, client.ObjectKey{Name: user.Spec.Id}, ns); err == nil {
-				err = r.Delete(ctx, ns)
+				err = recordEvent(r.Recorder, user, "Delete", ns, r.Delete(ctx, ns))
 				if err != nil {
 					log.Error(err, "Failed to delete Namespace", "Name", user.Spec.Id)
 					return ctrl.Result{}, err
 				}
 			}
-			if user.Spec.IsTeacher {
-				roleBinding := &v1rbac.ClusterRoleBinding{}
-				err := r.Get(ctx, client.ObjectKey{Name: kubelabPrefix + "teacher"}, roleBinding)
-				if err == nil {
-					err = r.Delete(ctx, roleBinding)
-					if err != nil {
-						log.Error(err, "Failed to delete Rolebinding", "Name", user.Spec.Id)
-						return ctrl.Result{}, err
-					}
-				}
-
-				role := &v1rbac.ClusterRole{}
-				if err := r.Get(ctx, client.ObjectKey{Name: kubelabPrefix + "teacher"}, role); err == nil {
-					err = r.Delete(ctx, role)
-					if err != nil {
-						log.Error(err, "Failed to delete Role", "Name", user.Spec.Id)
-						return ctrl.Result{}, err
-					}
-				}
+			// The shared roles are only removed with the last user having the role
+			if err := r.reconcileRoleRBAC(ctx); err != nil {
+				log.Error(err, "Failed to reconcile shared ClusterRoles")
+				return ctrl.Result{}, err
 			}
 
 			meta.SetStatusCondition(&user.Status.Conditions, metav1.Condition{Type: typeDegraded,
@@ -160,6 +169,17 @@ func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, nil
 	}
 
+	// Users created before roles existed only have the teacher flag, which is converted into roles
+	if user.Spec.IsTeacher || len(user.Spec.Roles) == 0 {
+		user.Spec.Roles = rolesOfUser(user)
+		user.Spec.IsTeacher = false
+		if err := r.Update(ctx, user); err != nil {
+			log.Error(err, "Failed to convert roles of user")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
 	// Check if the NS already exists, if not create a new one and assign role
 	ns := &v1.Namespace{}
 	err = r.Get(ctx, client.ObjectKey{Name: user.Spec.Id}, ns)
@@ -183,7 +203,7 @@ func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 
-		if err = r.Create(ctx, ns); err != nil {
+		if err = recordEvent(r.Recorder, user, "Create", ns, r.Create(ctx, ns)); err != nil {
 			log.Error(err, "Failed to create new Namespace", "Namespace Name", ns.Name)
 			return ctrl.Result{}, err
 		}
@@ -216,7 +236,7 @@ func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 
-		if err = r.Create(ctx, role); err != nil {
+		if err = recordEvent(r.Recorder, user, "Create", role, r.Create(ctx, role)); err != nil {
 			log.Error(err, "Failed to create new Role")
 			return ctrl.Result{}, err
 		}
@@ -226,12 +246,30 @@ func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		log.Error(err, "Failed to get Role")
 		// Return the error for the reconciliation be re-trigged again
 		return ctrl.Result{}, err
+	} else if desired, err := r.roleForUser(user); err == nil && !equality.Semantic.DeepEqual(role.Rules, desired.Rules) {
+		// Roles created before the user info existed can not read it
+		role.Rules = desired.Rules
+		if err := recordEvent(r.Recorder, user, "Update", role, r.Update(ctx, role)); err != nil {
+			log.Error(err, "Failed to update Role")
+			return ctrl.Result{}, err
+		}
 	}
 
 	// Check if the Role already exists, if not create a new one and add rolebinding
 	roleBinding := &v1rbac.RoleBinding{}
 	err = r.Get(ctx, types.NamespacedName{Name: roleBindingName, Namespace: user.Spec.Id}, roleBinding)
-	if err != nil && apierrors.IsNotFound(err) {
+	if user.Spec.Disabled {
+		// Disabled users keep their namespace and data, but lose access to it
+		if err == nil {
+			if err := recordEvent(r.Recorder, user, "Delete", roleBinding, r.Delete(ctx, roleBinding)); err != nil {
+				log.Error(err, "Failed to delete Rolebinding of disabled user")
+				return ctrl.Result{}, err
+			}
+		} else if !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to get Rolebinding")
+			return ctrl.Result{}, err
+		}
+	} else if err != nil && apierrors.IsNotFound(err) {
 		// Define a new Role
 		roleBinding, err := r.rolebindingForUser(user)
 
@@ -251,7 +289,7 @@ func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 
-		if err = r.Create(ctx, roleBinding); err != nil {
+		if err = recordEvent(r.Recorder, user, "Create", roleBinding, r.Create(ctx, roleBinding)); err != nil {
 			log.Error(err, "Failed to create new Rolebinding")
 			return ctrl.Result{}, err
 		}
@@ -262,80 +300,51 @@ func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, err
 	}
 
-	// if user is a teacher give them the rights to list classes and students
-	if user.Spec.IsTeacher {
-		clusteRole := &v1rbac.ClusterRole{}
-		if err := r.Get(ctx, client.ObjectKey{Name: kubelabPrefix + "teacher"}, clusteRole); err != nil && apierrors.IsNotFound(err) {
-			// Define a new Role
-			clusteRole, err := r.roleForTeacher(user)
-
-			if err != nil {
-				log.Error(err, "Failed to define new Role resource for user")
-
-				meta.SetStatusCondition(&user.Status.Conditions, metav1.Condition{Type: typeAvailable,
-					Status: metav1.ConditionFalse, Reason: "Reconciling",
-					Message: fmt.Sprintf("Failed to create Role for the custom resource (%s): (%s)", user.Name, err)})
-
-				if err := r.Status().Update(ctx, user); err != nil {
-					log.Error(err, "Failed to update user status")
-					return ctrl.Result{}, err
-				}
+	// keep the rights shared by all users with a role in sync, the roles of a user might have changed
+	if err := r.reconcileRoleRBAC(ctx); err != nil {
+		log.Error(err, "Failed to reconcile shared ClusterRoles")
 
-				return ctrl.Result{}, err
-			}
+		meta.SetStatusCondition(&user.Status.Conditions, metav1.Condition{Type: typeAvailable,
+			Status: metav1.ConditionFalse, Reason: "Reconciling",
+			Message: fmt.Sprintf("Failed to reconcile shared ClusterRoles for the custom resource (%s): (%s)", user.Name, err)})
 
-			if err = r.Create(ctx, clusteRole); err != nil {
-				log.Error(err, "Failed to create new Role")
-				return ctrl.Result{}, err
-			}
-			// Requeue after 2 seconds to create Rolebinding
-			return ctrl.Result{RequeueAfter: time.Second * 2}, nil
-		} else if err != nil {
-			log.Error(err, "Failed to get Role")
-			// Return the error for the reconciliation be re-trigged again
+		if err := r.Status().Update(ctx, user); err != nil {
+			log.Error(err, "Failed to update user status")
 			return ctrl.Result{}, err
 		}
 
-		// Check if the Role already exists, if not create a new one and add rolebinding
-		clusteRoleBinding := &v1rbac.ClusterRoleBinding{}
-		if err := r.Get(ctx, client.ObjectKey{Name: kubelabPrefix + "teacher"}, clusteRoleBinding); err != nil && apierrors.IsNotFound(err) {
-			// Define a new Role
-			clusteRoleBinding, err := r.roleBindingForTeacher(user)
-
-			if err != nil {
-				log.Error(err, "Failed to define new Rolebinding resource for user")
+		return ctrl.Result{}, err
+	}
 
-				// The following implementation will update the status
-				meta.SetStatusCondition(&user.Status.Conditions, metav1.Condition{Type: typeAvailable,
-					Status: metav1.ConditionFalse, Reason: "Reconciling",
-					Message: fmt.Sprintf("Failed to create Rolebinding for the custom resource (%s): (%s)", user.Name, err)})
+	// The quota limits the labs of the user, the limit range sizes containers without resources
+	if err := r.reconcileQuota(ctx, user); err != nil {
+		log.Error(err, "Failed to reconcile quota of user")
 
-				if err := r.Status().Update(ctx, user); err != nil {
-					log.Error(err, "Failed to update user status")
-					return ctrl.Result{}, err
-				}
+		meta.SetStatusCondition(&user.Status.Conditions, metav1.Condition{Type: typeAvailable,
+			Status: metav1.ConditionFalse, Reason: "Reconciling",
+			Message: fmt.Sprintf("Failed to reconcile quota for the custom resource (%s): (%s)", user.Name, err)})
 
-				return ctrl.Result{}, err
-			}
-
-			if err = r.Create(ctx, clusteRoleBinding); err != nil {
-				log.Error(err, "Failed to create new Rolebinding")
-				return ctrl.Result{}, err
-			}
-			return ctrl.Result{}, nil
-		} else if err != nil {
-			log.Error(err, "Failed to get Rolebinding")
-			// Return the error for the reconciliation be re-trigged again
+		if err := r.Status().Update(ctx, user); err != nil {
+			log.Error(err, "Failed to update user status")
 			return ctrl.Result{}, err
 		}
+
+		return ctrl.Result{}, err
 	}
 
 	// Check if the Claim already exists, if not create a new Claim
 	claim := &v1.PersistentVolumeClaim{}
 	err = r.Get(ctx, types.NamespacedName{Name: claimNameUser, Namespace: user.Spec.Id}, claim)
 	if err != nil && apierrors.IsNotFound(err) {
+		// A retained volume of a former user with the same id is re-attached instead of creating an empty folder
+		volumeName, err := r.retainedVolumeForUser(ctx, user)
+		if err != nil {
+			log.Error(err, "Failed to re-attach retained volume")
+			return ctrl.Result{}, err
+		}
+
 		// Define a new Role
-		claim, err := r.persistentVolumeClaimForUser(user)
+		claim, err := r.persistentVolumeClaimForUser(user, volumeName)
 
 		if err != nil {
 			log.Error(err, "Failed to define new PVC resource for user")
@@ -352,16 +361,49 @@ func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 
-		if err = r.Create(ctx, claim); err != nil {
+		if err = recordEvent(r.Recorder, user, "Create", claim, r.Create(ctx, claim)); err != nil {
 			log.Error(err, "Failed to create new PVC")
 			return ctrl.Result{}, err
 		}
+		if volumeName != "" {
+			log.Info("Re-attached retained volume", "Volume", volumeName)
+			user.Status.RestoredVolume = volumeName
+			if err := r.Status().Update(ctx, user); err != nil {
+				log.Error(err, "Failed to update user status")
+				return ctrl.Result{}, err
+			}
+		}
 		return ctrl.Result{}, nil
 	} else if err != nil {
 		log.Error(err, "Failed to get PVC")
 		return ctrl.Result{}, err
 	}
 
+	// The web app reads the roles and profile of the user from the user info
+	userInfo, err := r.configMapForUser(user)
+	if err != nil {
+		log.Error(err, "Failed to define new user info ConfigMap resource for user")
+		return ctrl.Result{}, err
+	}
+	existingUserInfo := &v1.ConfigMap{}
+	err = r.Get(ctx, types.NamespacedName{Name: userInfoName, Namespace: user.Spec.Id}, existingUserInfo)
+	if err != nil && apierrors.IsNotFound(err) {
+		if err = recordEvent(r.Recorder, user, "Create", userInfo, r.Create(ctx, userInfo)); err != nil {
+			log.Error(err, "Failed to create new user info ConfigMap")
+			return ctrl.Result{}, err
+		}
+	} else if err != nil {
+		log.Error(err, "Failed to get user info ConfigMap")
+		return ctrl.Result{}, err
+	} else if !equality.Semantic.DeepEqual(existingUserInfo.Data, userInfo.Data) {
+		existingUserInfo.Data = userInfo.Data
+		if err = recordEvent(r.Recorder, user, "Update", existingUserInfo, r.Update(ctx, existingUserInfo)); err != nil {
+			log.Error(err, "Failed to update user info ConfigMap")
+			return ctrl.Result{}, err
+		}
+	}
+
+	user.Status.Roles = rolesOfUser(user)
 	meta.SetStatusCondition(&user.Status.Conditions, metav1.Condition{Type: typeAvailable,
 		Status: metav1.ConditionTrue, Reason: "Reconciling",
 		Message: "Finished Reconciling"})
@@ -374,15 +416,268 @@ func (r *KubelabUserReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	return ctrl.Result{}, nil
 }
 
+// reconcileQuota creates or updates the ResourceQuota and LimitRange of the user namespace from the quota profile of the user
+func (r *KubelabUserReconciler) reconcileQuota(ctx context.Context, user *kubelabv1.KubelabUser) error {
+	name := quotaProfileOfUser(user)
+	profile, invalid, err := r.quotaProfile(ctx, name)
+	if err != nil {
+		return err
+	}
+	// the warning is only recorded once, the condition keeps the state until the profile is fixed
+	status, reason, message := metav1.ConditionTrue, "Reconciling", fmt.Sprintf("Quota profile %s", name)
+	if invalid != "" {
+		status, reason, message = metav1.ConditionFalse, "InvalidSpec", invalid
+		profile = defaultQuotaProfiles[userRoleStudent]
+	}
+	if condition := meta.FindStatusCondition(user.Status.Conditions, typeQuotaProfile); condition == nil ||
+		condition.Status != status || condition.Message != message {
+		if invalid != "" {
+			recordWarning(r.Recorder, user, "InvalidSpec", invalid)
+		}
+		meta.SetStatusCondition(&user.Status.Conditions, metav1.Condition{Type: typeQuotaProfile,
+			Status: status, Reason: reason, Message: message})
+		if err := r.Status().Update(ctx, user); err != nil {
+			return err
+		}
+	}
+
+	quota, err := r.resourceQuotaForUser(user, profile)
+	if err != nil {
+		return err
+	}
+	existingQuota := &v1.ResourceQuota{}
+	err = r.Get(ctx, types.NamespacedName{Name: quota.Name, Namespace: quota.Namespace}, existingQuota)
+	if err != nil && apierrors.IsNotFound(err) {
+		if err := recordEvent(r.Recorder, user, "Create", quota, r.Create(ctx, quota)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if !equality.Semantic.DeepEqual(existingQuota.Spec.Hard, quota.Spec.Hard) {
+		existingQuota.Spec.Hard = quota.Spec.Hard
+		if err := recordEvent(r.Recorder, user, "Update", existingQuota, r.Update(ctx, existingQuota)); err != nil {
+			return err
+		}
+	}
+
+	limitRange, err := r.limitRangeForUser(user, profile)
+	if err != nil {
+		return err
+	}
+	existingLimitRange := &v1.LimitRange{}
+	err = r.Get(ctx, types.NamespacedName{Name: limitRange.Name, Namespace: limitRange.Namespace}, existingLimitRange)
+	if err != nil && apierrors.IsNotFound(err) {
+		return recordEvent(r.Recorder, user, "Create", limitRange, r.Create(ctx, limitRange))
+	} else if err != nil {
+		return err
+	} else if !equality.Semantic.DeepEqual(existingLimitRange.Spec.Limits, limitRange.Spec.Limits) {
+		existingLimitRange.Spec.Limits = limitRange.Spec.Limits
+		return recordEvent(r.Recorder, user, "Update", existingLimitRange, r.Update(ctx, existingLimitRange))
+	}
+	return nil
+}
+
+// quotaProfile returns the quota profile from the ConfigMap of the operator or the default profile with the name.
+// If the profile does not exist or is invalid the reason is returned instead.
+func (r *KubelabUserReconciler) quotaProfile(ctx context.Context, name string) (quotaProfile, string, error) {
+	cm := &v1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: quotaProfilesName, Namespace: operatorNamespace}, cm); err != nil && !apierrors.IsNotFound(err) {
+		return quotaProfile{}, "", err
+	}
+	if value, ok := cm.Data[name]; ok {
+		profile := quotaProfile{}
+		if err := yaml.UnmarshalStrict([]byte(value), &profile); err != nil {
+			return quotaProfile{}, fmt.Sprintf("Quota profile %s is invalid: %s", name, err), nil
+		}
+		return profile, "", nil
+	}
+	if profile, ok := defaultQuotaProfiles[name]; ok {
+		return profile, "", nil
+	}
+	return quotaProfile{}, fmt.Sprintf("Quota profile %s does not exist", name), nil
+}
+
+// usersForQuotaProfiles enqueues all users if the quota profiles of the operator change.
+func (r *KubelabUserReconciler) usersForQuotaProfiles(obj client.Object) []reconcile.Request {
+	if obj.GetName() != quotaProfilesName || obj.GetNamespace() != operatorNamespace {
+		return nil
+	}
+	userList := &kubelabv1.KubelabUserList{}
+	if err := r.List(context.Background(), userList); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, user := range userList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: user.Name}})
+	}
+	return requests
+}
+
+// retainUserData applies the retention policy to the private folder before the namespace is deleted
+// and reports if the namespace may be deleted.
+func (r *KubelabUserReconciler) retainUserData(ctx context.Context, user *kubelabv1.KubelabUser) (bool, error) {
+	claim := &v1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: claimNameUser, Namespace: user.Spec.Id}, claim); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	policy, retention := retentionOfUser(user)
+	switch policy {
+	case retentionArchive:
+		// a failing archive blocks the deletion until the policy is changed
+		job := &batchv1.Job{}
+		if err := r.Get(ctx, types.NamespacedName{Name: userArchiveJob, Namespace: user.Spec.Id}, job); err != nil && apierrors.IsNotFound(err) {
+			job, err := r.jobForUserArchive(user)
+			if err != nil {
+				return false, err
+			}
+			return false, r.Create(ctx, job)
+		} else if err != nil {
+			return false, err
+		}
+		archived, _ := jobState(job, "Archived")
+		return archived, nil
+	case retentionRetain:
+		if claim.Spec.VolumeName == "" {
+			return true, nil
+		}
+		pv := &v1.PersistentVolume{}
+		if err := r.Get(ctx, client.ObjectKey{Name: claim.Spec.VolumeName}, pv); err != nil {
+			return false, err
+		}
+		if pv.Labels[retainedUserLabel] == user.Spec.Id {
+			return true, nil
+		}
+		// the volume outlives the claim and is released once the retention is over, see RetainedVolumeReconciler
+		pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+		if pv.Labels == nil {
+			pv.Labels = map[string]string{}
+		}
+		pv.Labels[retainedUserLabel] = user.Spec.Id
+		if pv.Annotations == nil {
+			pv.Annotations = map[string]string{}
+		}
+		pv.Annotations[retainUntilAnnotation] = time.Now().Add(retention).UTC().Format(time.RFC3339)
+		return true, recordEvent(r.Recorder, user, "Update", pv, r.Update(ctx, pv))
+	}
+	return true, nil
+}
+
+// retainedVolumeForUser reserves the retained volume of a former user with the same id for the private folder
+// and returns its name, or an empty name if there is none.
+func (r *KubelabUserReconciler) retainedVolumeForUser(ctx context.Context, user *kubelabv1.KubelabUser) (string, error) {
+	pvList := &v1.PersistentVolumeList{}
+	if err := r.List(ctx, pvList, client.MatchingLabels{retainedUserLabel: user.Spec.Id}); err != nil {
+		return "", err
+	}
+
+	for _, pv := range pvList.Items {
+		if pv.Status.Phase != v1.VolumeReleased && pv.Status.Phase != v1.VolumeAvailable {
+			continue
+		}
+		// the claim reference is replaced, so only the new claim can bind the volume
+		pv.Spec.ClaimRef = &v1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Namespace:  user.Spec.Id,
+			Name:       claimNameUser,
+		}
+		pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimDelete
+		delete(pv.Labels, retainedUserLabel)
+		delete(pv.Annotations, retainUntilAnnotation)
+		if err := recordEvent(r.Recorder, user, "Update", &pv, r.Update(ctx, &pv)); err != nil {
+			return "", err
+		}
+		return pv.Name, nil
+	}
+	return "", nil
+}
+
+// reconcileRoleRBAC keeps the ClusterRoles and ClusterRoleBindings shared by all users with a role.
+// They are owned by the operator instead of a single user and are reference counted by the
+// users with the role, so they are created with the first and only deleted with the last user.
+func (r *KubelabUserReconciler) reconcileRoleRBAC(ctx context.Context) error {
+	userList := &kubelabv1.KubelabUserList{}
+	if err := r.List(ctx, userList); err != nil {
+		return err
+	}
+
+	for _, userRole := range clusterUserRoles {
+		users := usersWithRole(userList.Items, userRole)
+		role := clusterRoleForUserRole(userRole)
+		roleBinding := clusterRoleBindingForUserRole(userRole, users)
+
+		if len(users) == 0 {
+			if err := r.Delete(ctx, roleBinding); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			if err := r.Delete(ctx, role); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+
+		existingRole := &v1rbac.ClusterRole{}
+		if err := r.Get(ctx, client.ObjectKey{Name: role.Name}, existingRole); err != nil && apierrors.IsNotFound(err) {
+			if err := r.Create(ctx, role); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if !equality.Semantic.DeepEqual(existingRole.Rules, role.Rules) || len(existingRole.OwnerReferences) > 0 {
+			// Older versions made the first teacher the owner, which deleted the role together with that teacher
+			existingRole.Rules = role.Rules
+			existingRole.OwnerReferences = nil
+			if err := r.Update(ctx, existingRole); err != nil {
+				return err
+			}
+		}
+
+		existingRoleBinding := &v1rbac.ClusterRoleBinding{}
+		if err := r.Get(ctx, client.ObjectKey{Name: roleBinding.Name}, existingRoleBinding); err != nil && apierrors.IsNotFound(err) {
+			if err := r.Create(ctx, roleBinding); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if !equality.Semantic.DeepEqual(existingRoleBinding.Subjects, roleBinding.Subjects) || len(existingRoleBinding.OwnerReferences) > 0 {
+			existingRoleBinding.Subjects = roleBinding.Subjects
+			existingRoleBinding.OwnerReferences = nil
+			if err := r.Update(ctx, existingRoleBinding); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *KubelabUserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+
+	// Reconcile the shared roles once at startup, which also migrates roles owned by a teacher
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if err := r.reconcileRoleRBAC(ctx); err != nil {
+			ctrl.Log.WithName("kubelabuser").Error(err, "Failed to reconcile shared ClusterRoles at startup")
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&kubelabv1.KubelabUser{}).
 		Owns(&v1.Namespace{}).
 		Owns(&v1rbac.Role{}).
 		Owns(&v1rbac.RoleBinding{}).
-		Owns(&v1rbac.ClusterRole{}).
-		Owns(&v1rbac.ClusterRoleBinding{}).
 		Owns(&v1.PersistentVolumeClaim{}).
+		Owns(&v1.ConfigMap{}).
+		Owns(&v1.ResourceQuota{}).
+		Owns(&v1.LimitRange{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &v1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.usersForQuotaProfiles)).
 		Complete(r)
 }
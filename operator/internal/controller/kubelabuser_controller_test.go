@@ -0,0 +1,375 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	v1rbac "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// reconcileUser runs the reconciler several times, like the manager would on every update of the user.
+func reconcileUser(t *testing.T, r *KubelabUserReconciler, name string) {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: name}}); err != nil {
+			t.Fatalf("reconcile of %s failed: %v", name, err)
+		}
+	}
+}
+
+func deleteUser(t *testing.T, r *KubelabUserReconciler, name string) {
+	t.Helper()
+	user := &kubelabv1.KubelabUser{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: name}, user); err != nil {
+		t.Fatalf("failed to get %s: %v", name, err)
+	}
+	if err := r.Delete(context.Background(), user); err != nil {
+		t.Fatalf("failed to delete %s: %v", name, err)
+	}
+	reconcileUser(t, r, name)
+}
+
+func teacherRoleExists(t *testing.T, r *KubelabUserReconciler) bool {
+	t.Helper()
+	role := &v1rbac.ClusterRole{}
+	err := r.Get(context.Background(), client.ObjectKey{Name: kubelabPrefix + "teacher"}, role)
+	if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatalf("failed to get teacher ClusterRole: %v", err)
+	}
+	roleBinding := &v1rbac.ClusterRoleBinding{}
+	bindingErr := r.Get(context.Background(), client.ObjectKey{Name: kubelabPrefix + "teacher"}, roleBinding)
+	if bindingErr != nil && !apierrors.IsNotFound(bindingErr) {
+		t.Fatalf("failed to get teacher ClusterRoleBinding: %v", bindingErr)
+	}
+	if len(role.OwnerReferences) > 0 {
+		t.Errorf("teacher ClusterRole must not be owned by a single teacher: %v", role.OwnerReferences)
+	}
+	return err == nil && bindingErr == nil
+}
+
+// Removing one teacher must not remove the access of all other teachers.
+func TestTeacherClusterRoleIsKeptUntilLastTeacherIsRemoved(t *testing.T) {
+	teacher1 := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "teacher1"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "t01", IsTeacher: true},
+	}
+	teacher2 := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "teacher2"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "t02", IsTeacher: true},
+	}
+	r := &KubelabUserReconciler{
+		Client:   newTestClient(teacher1, teacher2),
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+
+	reconcileUser(t, r, teacher1.Name)
+	reconcileUser(t, r, teacher2.Name)
+	if !teacherRoleExists(t, r) {
+		t.Fatal("teacher ClusterRole was not created")
+	}
+
+	deleteUser(t, r, teacher1.Name)
+	if !teacherRoleExists(t, r) {
+		t.Fatal("teacher ClusterRole was deleted although a teacher is left")
+	}
+
+	deleteUser(t, r, teacher2.Name)
+	if teacherRoleExists(t, r) {
+		t.Fatal("teacher ClusterRole was not deleted with the last teacher")
+	}
+}
+
+// Users created with the teacher flag get the role teacher and are bound to the shared teacher role.
+func TestIsTeacherIsConvertedIntoRoles(t *testing.T) {
+	teacher := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "teacher"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "t01", IsTeacher: true},
+	}
+	student := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "student"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "575103"},
+	}
+	r := &KubelabUserReconciler{
+		Client:   newTestClient(teacher, student),
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+
+	reconcileUser(t, r, teacher.Name)
+	reconcileUser(t, r, student.Name)
+
+	if err := r.Get(context.Background(), client.ObjectKey{Name: teacher.Name}, teacher); err != nil {
+		t.Fatal(err)
+	}
+	if teacher.Spec.IsTeacher || !hasRole(teacher.Spec.Roles, userRoleTeacher) {
+		t.Errorf("teacher flag was not converted: %+v", teacher.Spec)
+	}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: student.Name}, student); err != nil {
+		t.Fatal(err)
+	}
+	if len(student.Spec.Roles) != 1 || student.Spec.Roles[0] != userRoleStudent {
+		t.Errorf("user without roles did not become a student: %v", student.Spec.Roles)
+	}
+
+	roleBinding := &v1rbac.ClusterRoleBinding{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: kubelabPrefix + userRoleTeacher}, roleBinding); err != nil {
+		t.Fatal(err)
+	}
+	if len(roleBinding.Subjects) != 1 || roleBinding.Subjects[0].Name != groupPrefix+"t01" {
+		t.Errorf("teacher role is not bound to the teacher only: %v", roleBinding.Subjects)
+	}
+}
+
+// Created resources are recorded as Events of the user, deleting a missing resource is no Event.
+func TestUserActionsAreRecordedAsEvents(t *testing.T) {
+	student := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "student"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "575103", Roles: []kubelabv1.UserRole{userRoleStudent}},
+	}
+	recorder := record.NewFakeRecorder(100)
+	r := &KubelabUserReconciler{
+		Client:   newTestClient(student),
+		Scheme:   testScheme,
+		Recorder: recorder,
+	}
+	reconcileUser(t, r, student.Name)
+
+	missing := &v1rbac.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: "575103"}}
+	if err := recordEvent(recorder, student, "Delete", missing, r.Delete(context.Background(), missing)); !apierrors.IsNotFound(err) {
+		t.Errorf("error of the action was not returned: %v", err)
+	}
+
+	events := map[string]bool{}
+	for len(recorder.Events) > 0 {
+		events[<-recorder.Events] = true
+	}
+	for _, event := range []string{"Normal Created Created Namespace 575103", "Normal Created Created PersistentVolumeClaim 575103/" + claimNameUser} {
+		if !events[event] {
+			t.Errorf("event %q was not recorded: %v", event, events)
+		}
+	}
+	for event := range events {
+		if strings.Contains(event, "missing") {
+			t.Errorf("deleting a missing resource was recorded: %s", event)
+		}
+	}
+}
+
+// The private folder of a deleted user is retained and re-attached once a user with the same id is created again.
+func TestRetainedVolumeIsReattached(t *testing.T) {
+	student := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "student"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "575103"},
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-575103"},
+		Spec:       v1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete},
+		Status:     v1.PersistentVolumeStatus{Phase: v1.VolumeBound},
+	}
+	r := &KubelabUserReconciler{
+		Client:   newTestClient(student, pv),
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+	ctx := context.Background()
+
+	reconcileUser(t, r, student.Name)
+	claim := &v1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: claimNameUser, Namespace: "575103"}, claim); err != nil {
+		t.Fatal(err)
+	}
+	claim.Spec.VolumeName = pv.Name
+	if err := r.Update(ctx, claim); err != nil {
+		t.Fatal(err)
+	}
+
+	deleteUser(t, r, student.Name)
+	if err := r.Get(ctx, client.ObjectKey{Name: pv.Name}, pv); err != nil {
+		t.Fatal(err)
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain || pv.Labels[retainedUserLabel] != "575103" {
+		t.Fatalf("volume was not retained: %v %v", pv.Spec.PersistentVolumeReclaimPolicy, pv.Labels)
+	}
+
+	// the namespace deletion removes the claim and releases the volume
+	if err := r.Delete(ctx, claim); err != nil {
+		t.Fatal(err)
+	}
+	pv.Status.Phase = v1.VolumeReleased
+	if err := r.Update(ctx, pv); err != nil {
+		t.Fatal(err)
+	}
+
+	recreated := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "student-again"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "575103"},
+	}
+	if err := r.Create(ctx, recreated); err != nil {
+		t.Fatal(err)
+	}
+	reconcileUser(t, r, recreated.Name)
+
+	if err := r.Get(ctx, types.NamespacedName{Name: claimNameUser, Namespace: "575103"}, claim); err != nil {
+		t.Fatal(err)
+	}
+	if claim.Spec.VolumeName != pv.Name {
+		t.Errorf("retained volume was not re-attached: %q", claim.Spec.VolumeName)
+	}
+	if err := r.Get(ctx, client.ObjectKey{Name: pv.Name}, pv); err != nil {
+		t.Fatal(err)
+	}
+	if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != "575103" || pv.Labels[retainedUserLabel] != "" {
+		t.Errorf("volume was not reserved for the new claim: %v %v", pv.Spec.ClaimRef, pv.Labels)
+	}
+}
+
+// Retained volumes are handed back to the provisioner once the retention is over.
+func TestExpiredRetainedVolumeIsReleased(t *testing.T) {
+	expired := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-expired",
+			Labels:      map[string]string{retainedUserLabel: "575103"},
+			Annotations: map[string]string{retainUntilAnnotation: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)},
+		},
+		Spec: v1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimRetain},
+	}
+	kept := expired.DeepCopy()
+	kept.Name = "pvc-kept"
+	kept.Annotations[retainUntilAnnotation] = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	r := &RetainedVolumeReconciler{
+		Client: newTestClient(expired, kept),
+		Scheme: testScheme,
+	}
+
+	for _, pv := range []*v1.PersistentVolume{expired, kept} {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: pv.Name}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Get(context.Background(), client.ObjectKey{Name: pv.Name}, pv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expired.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+		t.Errorf("expired volume was not released")
+	}
+	if kept.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+		t.Errorf("volume was released before the retention was over")
+	}
+}
+
+func TestQuotaOfUserNamespace(t *testing.T) {
+	student := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "student"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "575103", Roles: []kubelabv1.UserRole{userRoleStudent}},
+	}
+	teacher := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "teacher"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "teacher", Roles: []kubelabv1.UserRole{userRoleTeacher}},
+	}
+	r := &KubelabUserReconciler{
+		Client:   newTestClient(student, teacher),
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+	reconcileUser(t, r, student.Name)
+	reconcileUser(t, r, teacher.Name)
+
+	for id, profile := range map[string]quotaProfile{"575103": defaultQuotaProfiles[userRoleStudent], "teacher": defaultQuotaProfiles[userRoleTeacher]} {
+		quota := &v1.ResourceQuota{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: quotaName, Namespace: id}, quota); err != nil {
+			t.Fatalf("quota of %s was not created: %v", id, err)
+		}
+		if cpu := quota.Spec.Hard[v1.ResourceLimitsCPU]; cpu.String() != profile.CPU {
+			t.Errorf("quota of %s limits cpu to %s, want %s", id, cpu.String(), profile.CPU)
+		}
+
+		limitRange := &v1.LimitRange{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: limitRangeName, Namespace: id}, limitRange); err != nil {
+			t.Fatalf("limit range of %s was not created: %v", id, err)
+		}
+		if memory := limitRange.Spec.Limits[0].Default[v1.ResourceMemory]; memory.String() != profile.DefaultMemory {
+			t.Errorf("limit range of %s defaults memory to %s, want %s", id, memory.String(), profile.DefaultMemory)
+		}
+	}
+}
+
+// Profiles are read from the ConfigMap of the operator, an unknown profile is warned about once and kept in a condition.
+func TestQuotaProfileFromConfigMap(t *testing.T) {
+	profiles := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: quotaProfilesName, Namespace: operatorNamespace},
+		Data: map[string]string{
+			"large": "cpu: \"4\"\nmemory: 8Gi\npods: \"20\"\nclaims: \"50\"\nstorage: 50Gi\ndefaultCPU: 100m\ndefaultMemory: 128Mi\n",
+		},
+	}
+	large := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "large"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "575103", QuotaProfile: "large"},
+	}
+	unknown := &kubelabv1.KubelabUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "unknown"},
+		Spec:       kubelabv1.KubelabUserSpec{Id: "575104", QuotaProfile: "huge"},
+	}
+	recorder := record.NewFakeRecorder(100)
+	r := &KubelabUserReconciler{
+		Client:   newTestClient(profiles, large, unknown),
+		Scheme:   testScheme,
+		Recorder: recorder,
+	}
+	for i := 0; i < 2; i++ {
+		reconcileUser(t, r, large.Name)
+		reconcileUser(t, r, unknown.Name)
+	}
+
+	quota := &v1.ResourceQuota{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: quotaName, Namespace: "575103"}, quota); err != nil {
+		t.Fatalf("quota was not created: %v", err)
+	}
+	if cpu := quota.Spec.Hard[v1.ResourceLimitsCPU]; cpu.String() != "4" {
+		t.Errorf("profile of the ConfigMap was not used, cpu is limited to %s", cpu.String())
+	}
+
+	if err := r.Get(context.Background(), types.NamespacedName{Name: unknown.Name}, unknown); err != nil {
+		t.Fatal(err)
+	}
+	if condition := meta.FindStatusCondition(unknown.Status.Conditions, typeQuotaProfile); condition == nil || condition.Status != metav1.ConditionFalse {
+		t.Errorf("unknown profile is not kept in a condition: %v", condition)
+	}
+	warnings := 0
+	for len(recorder.Events) > 0 {
+		if strings.Contains(<-recorder.Events, "InvalidSpec") {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("expected a single warning for the unknown profile, got %d", warnings)
+	}
+}
@@ -1,6 +1,11 @@
 package controller
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	v1rbac "k8s.io/api/rbac/v1"
 	resource "k8s.io/apimachinery/pkg/api/resource"
@@ -28,7 +33,7 @@ func (r *KubelabUserReconciler) namespaceForUser(user *kubelabv1.KubelabUser) (*
 	return ns, nil
 }
 
-// roleForUser returns role to scale and get ressources inside the namespace.
+// roleForUser returns role to start labs by LabSessions, to ask to join a classroom and get ressources inside the namespace.
 func (r *KubelabUserReconciler) roleForUser(user *kubelabv1.KubelabUser) (*v1rbac.Role, error) {
 
 	// Define the Role object
@@ -39,15 +44,33 @@ func (r *KubelabUserReconciler) roleForUser(user *kubelabv1.KubelabUser) (*v1rba
 		},
 		Rules: []v1rbac.PolicyRule{
 			{
+				APIGroups: []string{"kubelab.kubelab.local"},
+				Resources: []string{"labsessions"},
+				Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+			},
+			{
+				// the status holds the decision of the staff, so requests can not be changed after they were created
+				APIGroups: []string{"kubelab.kubelab.local"},
+				Resources: []string{"enrollmentrequests"},
+				Verbs:     []string{"get", "list", "watch", "create", "delete"},
+			},
+			{
+				// the labs are only listed, they are started and stopped by the operator
 				APIGroups: []string{"apps"},
-				Resources: []string{"deployments"},
-				Verbs:     []string{"list", "scale"},
+				Resources: []string{"statefulsets", "deployments"},
+				Verbs:     []string{"get", "list"},
 			},
 			{
 				APIGroups: []string{""},
 				Resources: []string{"services"},
 				Verbs:     []string{"get"},
 			},
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"configmaps"},
+				ResourceNames: []string{userInfoName},
+				Verbs:         []string{"get"},
+			},
 		},
 	}
 
@@ -99,8 +122,13 @@ func labelsForUser(name string) map[string]string {
 	}
 }
 
-// persistentVolumeClaimForUser returns pvc to have private folder.
-func (r *KubelabUserReconciler) persistentVolumeClaimForUser(user *kubelabv1.KubelabUser) (*v1.PersistentVolumeClaim, error) {
+// The script gets the id via environment variables, so the spec can not inject shell code
+const userArchiveScript = `set -e
+mkdir -p /nfs/archive/users
+tar -czf "/nfs/archive/users/$ARCHIVE" -C /nfs/student "$USER_ID"`
+
+// persistentVolumeClaimForUser returns pvc to have private folder, a retained volume is bound if its name is given.
+func (r *KubelabUserReconciler) persistentVolumeClaimForUser(user *kubelabv1.KubelabUser, volumeName string) (*v1.PersistentVolumeClaim, error) {
 	storageClassName := storageClass
 
 	claim := &v1.PersistentVolumeClaim{
@@ -125,6 +153,7 @@ func (r *KubelabUserReconciler) persistentVolumeClaimForUser(user *kubelabv1.Kub
 					v1.ResourceName(v1.ResourceStorage): resource.MustParse("100Mi"),
 				},
 			},
+			VolumeName: volumeName,
 		},
 	}
 
@@ -135,63 +164,290 @@ func (r *KubelabUserReconciler) persistentVolumeClaimForUser(user *kubelabv1.Kub
 	return claim, nil
 }
 
-// roleForUser returns role to scale and get ressources inside the namespace.
-func (r *KubelabUserReconciler) roleForTeacher(teacher *kubelabv1.KubelabUser) (*v1rbac.ClusterRole, error) {
+// quotaProfile sizes the ResourceQuota and LimitRange of a user namespace, see defaultQuotaProfiles
+type quotaProfile struct {
+	// Sum of the requests and limits of all pods in the namespace
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+	Pods   string `json:"pods"`
+	// Number of PVCs and the sum of their requests
+	Claims  string `json:"claims"`
+	Storage string `json:"storage"`
+	// Limits of containers without resources, like the jobs of the operator
+	DefaultCPU    string `json:"defaultCPU"`
+	DefaultMemory string `json:"defaultMemory"`
+}
 
-	// Define the Role object
-	role := &v1rbac.ClusterRole{
+// quotaProfileOfUser returns the name of the quota profile of the user
+func quotaProfileOfUser(user *kubelabv1.KubelabUser) string {
+	if user.Spec.QuotaProfile != "" {
+		return user.Spec.QuotaProfile
+	}
+	roles := rolesOfUser(user)
+	if hasRole(roles, userRoleTeacher) || hasRole(roles, userRoleAdmin) {
+		return userRoleTeacher
+	}
+	return userRoleStudent
+}
+
+// parseResources returns the quantities of the profile, which is part of the configuration and might be invalid
+func parseResources(quantities map[v1.ResourceName]string) (v1.ResourceList, error) {
+	resources := v1.ResourceList{}
+	for name, value := range quantities {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in quota profile: %w", name, err)
+		}
+		resources[name] = quantity
+	}
+	return resources, nil
+}
+
+// resourceQuotaForUser returns the quota limiting the resources of all pods and PVCs in the namespace of the user.
+func (r *KubelabUserReconciler) resourceQuotaForUser(user *kubelabv1.KubelabUser, profile quotaProfile) (*v1.ResourceQuota, error) {
+	hard, err := parseResources(map[v1.ResourceName]string{
+		v1.ResourceRequestsCPU:            profile.CPU,
+		v1.ResourceLimitsCPU:              profile.CPU,
+		v1.ResourceRequestsMemory:         profile.Memory,
+		v1.ResourceLimitsMemory:           profile.Memory,
+		v1.ResourcePods:                   profile.Pods,
+		v1.ResourcePersistentVolumeClaims: profile.Claims,
+		v1.ResourceRequestsStorage:        profile.Storage,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	quota := &v1.ResourceQuota{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: kubelabPrefix + "teacher",
+			Name:      quotaName,
+			Namespace: user.Spec.Id,
+			Labels:    labelsForUser(user.Spec.Id),
 		},
-		Rules: []v1rbac.PolicyRule{
+		Spec: v1.ResourceQuotaSpec{
+			Hard: hard,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(user, quota, r.Scheme); err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// limitRangeForUser returns the limits of containers without resources, which would be rejected by the quota otherwise.
+func (r *KubelabUserReconciler) limitRangeForUser(user *kubelabv1.KubelabUser, profile quotaProfile) (*v1.LimitRange, error) {
+	defaults, err := parseResources(map[v1.ResourceName]string{
+		v1.ResourceCPU:    profile.DefaultCPU,
+		v1.ResourceMemory: profile.DefaultMemory,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	limitRange := &v1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      limitRangeName,
+			Namespace: user.Spec.Id,
+			Labels:    labelsForUser(user.Spec.Id),
+		},
+		Spec: v1.LimitRangeSpec{
+			Limits: []v1.LimitRangeItem{{
+				Type:           v1.LimitTypeContainer,
+				Default:        defaults,
+				DefaultRequest: defaults,
+			}},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(user, limitRange, r.Scheme); err != nil {
+		return nil, err
+	}
+	return limitRange, nil
+}
+
+// jobForUserArchive returns a job writing the private folder of the user into a tarball on the NFS share.
+func (r *KubelabUserReconciler) jobForUserArchive(user *kubelabv1.KubelabUser) (*batchv1.Job, error) {
+	ls := labelsForUser(user.Spec.Id)
+	backoffLimit := int32(3)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      userArchiveJob,
+			Namespace: user.Spec.Id,
+			Labels:    ls,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Image:   jobImage,
+						Name:    "archive",
+						Command: []string{"sh", "-c", userArchiveScript},
+						Env: []v1.EnvVar{
+							{
+								Name:  "USER_ID",
+								Value: user.Spec.Id,
+							},
+							{
+								Name:  "ARCHIVE",
+								Value: user.Spec.Id + "-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz",
+							},
+						},
+						VolumeMounts: []v1.VolumeMount{{
+							Name:      "nfs",
+							MountPath: "/nfs",
+						}},
+					}},
+					Volumes: []v1.Volume{{
+						Name: "nfs",
+						VolumeSource: v1.VolumeSource{
+							NFS: &v1.NFSVolumeSource{
+								Server: nfsServer,
+								Path:   nfsPath, // the private folders are found at student/<id>, see persistentVolumeClaimForUser
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(user, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// configMapForUser returns configmap with the roles and profile of the user, which is read by the web app.
+func (r *KubelabUserReconciler) configMapForUser(user *kubelabv1.KubelabUser) (*v1.ConfigMap, error) {
+
+	roles := []string{}
+	for _, role := range rolesOfUser(user) {
+		roles = append(roles, string(role))
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      userInfoName,
+			Namespace: user.Spec.Id,
+			Labels:    labelsForUser(user.Name),
+		},
+		Data: map[string]string{
+			"roles":       strings.Join(roles, ","),
+			"displayName": user.Spec.DisplayName,
+			"email":       user.Spec.Email,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(user, cm, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return cm, nil
+}
+
+// clusterRoleForUserRole returns the role shared by all users with the role.
+func clusterRoleForUserRole(userRole kubelabv1.UserRole) *v1rbac.ClusterRole {
+
+	kubelabResources := []string{"classrooms", "kubelabusers", "assignments", "gradingruns", "enrollmentrequests", "labsnapshots", "labrestores", "labusages", "labsessions"}
+	labResources := []string{"namespaces", "services", "pods"}
+
+	// Access to the labs, classrooms, assignments, grading runs and snapshots of teachers and assistants is granted per classroom by the classroom controller.
+	// Students ask to join a classroom inside their own namespace and the approvers of a request are chosen by the enrollment request controller.
+	var rules []v1rbac.PolicyRule
+	switch userRole {
+	case userRoleAdmin:
+		rules = []v1rbac.PolicyRule{
+			{
+				APIGroups: []string{"kubelab.kubelab.local"},
+				Resources: kubelabResources,
+				Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+			},
+			{
+				// admins may approve every enrollment request
+				APIGroups: []string{"kubelab.kubelab.local"},
+				Resources: []string{"enrollmentrequests/status"},
+				Verbs:     []string{"get", "update", "patch"},
+			},
 			{
 				APIGroups: []string{"apps"},
-				Resources: []string{"deployments"},
-				Verbs:     []string{"list", "scale", "update", "get"},
+				Resources: []string{"statefulsets", "statefulsets/scale", "deployments", "deployments/scale"},
+				Verbs:     []string{"get", "list", "watch", "update", "patch"},
 			},
 			{
 				APIGroups: []string{""},
-				Resources: []string{"pods", "services"},
-				Verbs:     []string{"get", "list"},
+				Resources: labResources,
+				Verbs:     []string{"get", "list", "watch"},
 			},
+			{
+				// the lab hours report, served by the metrics server of the operator
+				NonResourceURLs: []string{"/usage"},
+				Verbs:           []string{"get"},
+			},
+		}
+	case userRoleAuditor:
+		rules = []v1rbac.PolicyRule{
 			{
 				APIGroups: []string{"kubelab.kubelab.local"},
-				Resources: []string{"classrooms"},
-				Verbs:     []string{"list"},
+				Resources: kubelabResources,
+				Verbs:     []string{"get", "list", "watch"},
 			},
-		},
+			{
+				APIGroups: []string{"apps"},
+				Resources: []string{"statefulsets", "deployments"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			{
+				APIGroups: []string{""},
+				Resources: labResources,
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			{
+				// the lab hours report, served by the metrics server of the operator
+				NonResourceURLs: []string{"/usage"},
+				Verbs:           []string{"get"},
+			},
+		}
 	}
 
-	if err := ctrl.SetControllerReference(teacher, role, r.Scheme); err != nil {
-		return nil, err
+	// Define the Role object, it is owned by the operator and not a single user
+	return &v1rbac.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   kubelabPrefix + string(userRole),
+			Labels: labelsForUser(string(userRole)),
+		},
+		Rules: rules,
 	}
-
-	return role, nil
 }
 
-// roleBindingForTeacher returns rolebinding to give teachers the needed rights.
-func (r *KubelabUserReconciler) roleBindingForTeacher(teacher *kubelabv1.KubelabUser) (*v1rbac.ClusterRoleBinding, error) {
+// clusterRoleBindingForUserRole returns rolebinding to give the users with the role the needed rights.
+func clusterRoleBindingForUserRole(userRole kubelabv1.UserRole, users []kubelabv1.KubelabUser) *v1rbac.ClusterRoleBinding {
+
+	subjects := []v1rbac.Subject{}
+	for _, user := range users {
+		subjects = append(subjects, v1rbac.Subject{
+			Kind:     "Group",
+			Name:     groupPrefix + user.Spec.Id,
+			APIGroup: "rbac.authorization.k8s.io",
+		})
+	}
 
-	rb := &v1rbac.ClusterRoleBinding{
+	return &v1rbac.ClusterRoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: kubelabPrefix + "teacher",
-		},
-		Subjects: []v1rbac.Subject{
-			{
-				Kind: "Group",
-				Name: groupPrefix + "teacher",
-			},
+			Name:   kubelabPrefix + string(userRole),
+			Labels: labelsForUser(string(userRole)),
 		},
+		Subjects: subjects,
 		RoleRef: v1rbac.RoleRef{
 			Kind:     "ClusterRole",
-			Name:     kubelabPrefix + "teacher",
+			Name:     kubelabPrefix + string(userRole),
 			APIGroup: "rbac.authorization.k8s.io",
 		},
 	}
-
-	if err := ctrl.SetControllerReference(teacher, rb, r.Scheme); err != nil {
-		return nil, err
-	}
-
-	return rb, nil
 }
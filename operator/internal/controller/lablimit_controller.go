@@ -0,0 +1,153 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+	"kubelab.local/kubelab/internal/metrics"
+)
+
+// LabLimits are the numbers of labs of students running at the same time, 0 means unlimited
+type LabLimits struct {
+	// Labs a student may run
+	PerStudent int
+	// Labs all students may run in the cluster
+	Total int
+}
+
+// LabLimitReconciler limits the number of labs running at the same time per student, per classroom and in the cluster.
+// Students may scale their labs themselves, so labs scaled up beyond a limit are scaled down again.
+type LabLimitReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Limits   LabLimits
+}
+
+//Custom RBAC
+//+kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=classrooms,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labsessions,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *LabLimitReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	lab := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, req.NamespacedName, lab); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get StatefulSet")
+		return ctrl.Result{}, err
+	}
+
+	// The start time orders the running labs, the first ones started keep running
+	_, started := lab.Annotations[labStartedAnnotation]
+	if !labRunning(lab) {
+		if started {
+			delete(lab.Annotations, labStartedAnnotation)
+			return ctrl.Result{}, r.Update(ctx, lab)
+		}
+		return ctrl.Result{}, nil
+	}
+	if !started {
+		if lab.Annotations == nil {
+			lab.Annotations = map[string]string{}
+		}
+		lab.Annotations[labStartedAnnotation] = time.Now().UTC().Format(time.RFC3339Nano)
+		delete(lab.Annotations, labStoppedAnnotation)
+		if err := r.Update(ctx, lab); err != nil {
+			log.Error(err, "Failed to mark lab as started")
+			return ctrl.Result{}, err
+		}
+	}
+
+	classroom := &kubelabv1.Classroom{}
+	if err := r.Get(ctx, client.ObjectKey{Name: lab.Labels["class"]}, classroom); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get Classroom")
+		return ctrl.Result{}, err
+	}
+	// The labs of the staff are never stopped
+	for _, member := range staffOfClassroom(classroom) {
+		if member.Id == lab.Labels["student"] {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// The classrooms are needed to leave out the labs of their staff
+	classrooms := &kubelabv1.ClassroomList{}
+	if err := r.List(ctx, classrooms); err != nil {
+		log.Error(err, "Failed to list classrooms")
+		return ctrl.Result{}, err
+	}
+	labs := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, labs, client.MatchingLabels{"app.kubernetes.io/name": "KubelabClassroom"}); err != nil {
+		log.Error(err, "Failed to list labs")
+		return ctrl.Result{}, err
+	}
+	message := labLimitExceeded(lab, labs.Items, classroom, classrooms.Items, r.Limits)
+	if message == "" {
+		return ctrl.Result{}, nil
+	}
+
+	// The reason is kept on the lab, so it can be shown to the student
+	replicas := int32(0)
+	lab.Spec.Replicas = &replicas
+	delete(lab.Annotations, labStartedAnnotation)
+	lab.Annotations[labStoppedAnnotation] = message
+	if err := recordEvent(r.Recorder, classroom, "Update", lab, r.Update(ctx, lab)); err != nil {
+		log.Error(err, "Failed to stop lab", "StatefulSet.Namespace", lab.Namespace, "StatefulSet.Name", lab.Name)
+		return ctrl.Result{}, err
+	}
+	// the session of the lab is stopped as well, so the student can start the lab again once it is allowed
+	if err := stopLabSession(ctx, r.Client, lab.Namespace, lab.Name); err != nil {
+		log.Error(err, "Failed to stop lab session", "Namespace", lab.Namespace, "Name", lab.Name)
+		return ctrl.Result{}, err
+	}
+	// enforcing a limit is no failure of the operator, the stops are counted by their own metric
+	recordNormal(r.Recorder, lab, "LabLimitExceeded", message)
+	recordNormal(r.Recorder, classroom, "LabLimitExceeded", "Stopped lab of "+lab.Labels["student"]+": "+message)
+	metrics.LabsStopped.WithLabelValues(classroom.Name).Inc()
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LabLimitReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("lablimit").
+		For(&appsv1.StatefulSet{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetLabels()["app.kubernetes.io/name"] == "KubelabClassroom"
+		}))).
+		Complete(r)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+	"kubelab.local/kubelab/internal/metrics"
+)
+
+func TestSecondLabOfStudentIsStopped(t *testing.T) {
+	lab := func(class string, started time.Time) *appsv1.StatefulSet {
+		replicas := int32(1)
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        class,
+				Namespace:   "575103",
+				Labels:      labelsForClassroom(class, "575103"),
+				Annotations: map[string]string{labStartedAnnotation: started.UTC().Format(time.RFC3339Nano)},
+			},
+			Spec: appsv1.StatefulSetSpec{Replicas: &replicas},
+		}
+	}
+	first := lab("java", time.Now().Add(-time.Hour))
+	second := lab("linux", time.Now())
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "linux"},
+		Spec:       kubelabv1.ClassroomSpec{Staff: []kubelabv1.ClassroomStaff{{Id: "teacher", Role: staffOwner}}},
+	}
+	session := &kubelabv1.LabSession{
+		ObjectMeta: metav1.ObjectMeta{Name: "linux", Namespace: "575103"},
+		Spec:       kubelabv1.LabSessionSpec{Classroom: "linux", State: sessionRunning},
+	}
+	r := &LabLimitReconciler{
+		Client:   newTestClient(first, second, classroom, session),
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(100),
+		Limits:   LabLimits{PerStudent: 1},
+	}
+
+	for _, name := range []string{"java", "linux"} {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "575103"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]int32{"java": 1, "linux": 0} {
+		statefulSet := &appsv1.StatefulSet{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "575103"}, statefulSet); err != nil {
+			t.Fatal(err)
+		}
+		if *statefulSet.Spec.Replicas != want {
+			t.Errorf("lab %s has %d replicas, want %d", name, *statefulSet.Spec.Replicas, want)
+		}
+		if _, stopped := statefulSet.Annotations[labStoppedAnnotation]; stopped != (want == 0) {
+			t.Errorf("lab %s has stopped annotation %v", name, statefulSet.Annotations)
+		}
+	}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: "linux", Namespace: "575103"}, session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Spec.State != sessionStopped {
+		t.Errorf("session of the stopped lab is %s", session.Spec.State)
+	}
+}
+
+// The labs of the staff do not count against the limits, stopping a lab is a Normal event and no reconcile failure.
+func TestStaffLabsDoNotCountAgainstLimits(t *testing.T) {
+	lab := func(id string, started time.Time) *appsv1.StatefulSet {
+		replicas := int32(1)
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "java",
+				Namespace:   id,
+				Labels:      labelsForClassroom("java", id),
+				Annotations: map[string]string{labStartedAnnotation: started.UTC().Format(time.RFC3339Nano)},
+			},
+			Spec: appsv1.StatefulSetSpec{Replicas: &replicas},
+		}
+	}
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java"},
+		Spec: kubelabv1.ClassroomSpec{
+			Staff:          []kubelabv1.ClassroomStaff{{Id: "t01", Role: staffOwner}},
+			MaxRunningLabs: 1,
+		},
+	}
+	recorder := record.NewFakeRecorder(100)
+	r := &LabLimitReconciler{
+		Client:   newTestClient(classroom, lab("t01", time.Now().Add(-time.Hour)), lab("575103", time.Now().Add(-time.Minute)), lab("575104", time.Now())),
+		Scheme:   testScheme,
+		Recorder: recorder,
+		Limits:   LabLimits{Total: 2},
+	}
+	failures := testutil.ToFloat64(metrics.ReconcileFailures.WithLabelValues("StatefulSet", "LabLimitExceeded"))
+	stops := testutil.ToFloat64(metrics.LabsStopped.WithLabelValues("java"))
+
+	for _, id := range []string{"t01", "575103", "575104"} {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "java", Namespace: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, want := range map[string]int32{"t01": 1, "575103": 1, "575104": 0} {
+		statefulSet := &appsv1.StatefulSet{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: "java", Namespace: id}, statefulSet); err != nil {
+			t.Fatal(err)
+		}
+		if *statefulSet.Spec.Replicas != want {
+			t.Errorf("lab of %s has %d replicas, want %d", id, *statefulSet.Spec.Replicas, want)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.LabsStopped.WithLabelValues("java")) - stops; got != 1 {
+		t.Errorf("expected a single stopped lab, got %v", got)
+	}
+	if testutil.ToFloat64(metrics.ReconcileFailures.WithLabelValues("StatefulSet", "LabLimitExceeded")) != failures {
+		t.Errorf("stopped lab was counted as reconcile failure")
+	}
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.HasPrefix(event, "Warning") {
+			t.Errorf("unexpected warning %s", event)
+		}
+	}
+}
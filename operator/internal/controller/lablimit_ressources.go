@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// labRunning returns true if the lab is scaled up
+func labRunning(lab *appsv1.StatefulSet) bool {
+	return lab.ObjectMeta.DeletionTimestamp.IsZero() && (lab.Spec.Replicas == nil || *lab.Spec.Replicas > 0)
+}
+
+// labStarted returns the time the lab was scaled up, labs which were not seen running yet are started now
+func labStarted(lab *appsv1.StatefulSet) time.Time {
+	started, err := time.Parse(time.RFC3339Nano, lab.Annotations[labStartedAnnotation])
+	if err != nil {
+		return time.Now()
+	}
+	return started
+}
+
+// staffLabs returns the labs of the staff of the classrooms by classroom and id, they do not count against the limits
+func staffLabs(classrooms []kubelabv1.Classroom) map[string]bool {
+	labs := map[string]bool{}
+	for i := range classrooms {
+		for _, member := range staffOfClassroom(&classrooms[i]) {
+			labs[classrooms[i].Name+"/"+member.Id] = true
+		}
+	}
+	return labs
+}
+
+// labLimitExceeded returns why the lab has to be stopped or an empty string if it may keep running.
+// The labs started first keep running, so a lab scaled up beyond a limit is the one stopped.
+// The labs of the staff of the classrooms are not counted.
+func labLimitExceeded(lab *appsv1.StatefulSet, labs []appsv1.StatefulSet, classroom *kubelabv1.Classroom, classrooms []kubelabv1.Classroom, limits LabLimits) string {
+	staff := staffLabs(classrooms)
+	running := []appsv1.StatefulSet{}
+	for _, l := range labs {
+		if labRunning(&l) && !staff[l.Labels["class"]+"/"+l.Labels["student"]] {
+			running = append(running, l)
+		}
+	}
+	sort.SliceStable(running, func(i, j int) bool {
+		si, sj := labStarted(&running[i]), labStarted(&running[j])
+		if !si.Equal(sj) {
+			return si.Before(sj)
+		}
+		return running[i].Namespace+"/"+running[i].Name < running[j].Namespace+"/"+running[j].Name
+	})
+
+	student, class, total := 0, 0, 0
+	for _, l := range running {
+		if l.Namespace == lab.Namespace && l.Name == lab.Name {
+			break
+		}
+		total++
+		if l.Labels["student"] == lab.Labels["student"] {
+			student++
+		}
+		if l.Labels["class"] == lab.Labels["class"] {
+			class++
+		}
+	}
+
+	switch {
+	case limits.PerStudent > 0 && student >= limits.PerStudent:
+		return fmt.Sprintf("Student %s may only run %d lab(s) at the same time", lab.Labels["student"], limits.PerStudent)
+	case classroom.Spec.MaxRunningLabs > 0 && class >= int(classroom.Spec.MaxRunningLabs):
+		return fmt.Sprintf("Classroom %s may only run %d lab(s) at the same time", classroom.Name, classroom.Spec.MaxRunningLabs)
+	case limits.Total > 0 && total >= limits.Total:
+		return fmt.Sprintf("The cluster may only run %d lab(s) at the same time", limits.Total)
+	}
+	return ""
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// LabRestoreReconciler reconciles a LabRestore object
+type LabRestoreReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Config and Clientset are needed to unpack the filesystem of the container into the restarted lab
+	Config    *rest.Config
+	Clientset kubernetes.Interface
+}
+
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labrestores,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labrestores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labrestores/finalizers,verbs=update
+
+//Custom RBAC
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labsnapshots,verbs=get;list;watch
+//+kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
+
+func (r *LabRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Fetch the instance and check if it exist
+	restore := &kubelabv1.LabRestore{}
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		if apierrors.IsNotFound(err) {
+			// If the custom resource is not found then, it usually means that it was deleted or not created
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get LabRestore")
+		return ctrl.Result{}, err
+	}
+
+	// set the status as Unknown when no status are available
+	if restore.Status.Conditions == nil || len(restore.Status.Conditions) == 0 {
+		meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{Type: typeAvailable, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
+		restore.Status.Phase = snapshotPending
+		if err := r.Status().Update(ctx, restore); err != nil {
+			log.Error(err, "Failed to update lab restore status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// The Job and the source PVC live in the namespace of the student, so the finalizer removes them with the restore
+	if restore.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(restore, labRestoreFinalizer) {
+			controllerutil.AddFinalizer(restore, labRestoreFinalizer)
+			if err := r.Update(ctx, restore); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+	} else {
+		if controllerutil.ContainsFinalizer(restore, labRestoreFinalizer) {
+			if err := r.deleteRestoreResources(ctx, restore); err != nil {
+				log.Error(err, "Failed to delete resources of lab restore")
+				return ctrl.Result{}, err
+			}
+			controllerutil.RemoveFinalizer(restore, labRestoreFinalizer)
+			if err := r.Update(ctx, restore); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if restore.Status.Phase == restoreRestored || restore.Status.Phase == snapshotFailed {
+		return ctrl.Result{}, nil
+	}
+
+	// Only snapshots of the same classroom can be restored, they were checked for enrolled students
+	snapshot := &kubelabv1.LabSnapshot{}
+	if err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.Snapshot, Namespace: restore.Namespace}, snapshot); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.setRestorePhase(ctx, restore, snapshotFailed, fmt.Sprintf("Snapshot %s does not exist", restore.Spec.Snapshot))
+		}
+		log.Error(err, "Failed to get LabSnapshot")
+		return ctrl.Result{}, err
+	}
+	if snapshot.Status.Phase == snapshotFailed {
+		return r.setRestorePhase(ctx, restore, snapshotFailed, fmt.Sprintf("Snapshot %s failed", snapshot.Name))
+	}
+	if snapshot.Status.Phase != snapshotReady {
+		meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{Type: typeAvailable,
+			Status: metav1.ConditionFalse, Reason: snapshotPending,
+			Message: fmt.Sprintf("Waiting for snapshot %s", snapshot.Name)})
+		if err := r.Status().Update(ctx, restore); err != nil {
+			log.Error(err, "Failed to update lab restore status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	}
+
+	lab := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: snapshot.Namespace, Namespace: snapshot.Spec.Student}, lab); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.setRestorePhase(ctx, restore, snapshotFailed, fmt.Sprintf("Lab of %s in %s does not exist", snapshot.Spec.Student, snapshot.Namespace))
+		}
+		log.Error(err, "Failed to get StatefulSet")
+		return ctrl.Result{}, err
+	}
+
+	switch restore.Status.Phase {
+	case snapshotPending:
+		// The lab is stopped, so the workspace is not changed during the restore
+		if lab.Spec.Replicas != nil {
+			restore.Status.Replicas = *lab.Spec.Replicas
+		}
+		if err := r.scaleLab(ctx, lab, 0); err != nil {
+			return ctrl.Result{}, err
+		}
+		return r.setRestorePhase(ctx, restore, restoreStopping, "Stopping lab")
+
+	case restoreStopping:
+		podList := &v1.PodList{}
+		if err := r.List(ctx, podList, client.InNamespace(snapshot.Spec.Student), labPodSelector(snapshot.Namespace, snapshot.Spec.Student)); err != nil {
+			log.Error(err, "Failed to list pods of lab")
+			return ctrl.Result{}, err
+		}
+		for i := range podList.Items {
+			// a warm pod waiting for the lab uses the workspace as well, the classroom keeps no warm pod during the restore
+			if podList.Items[i].Labels["app.kubernetes.io/name"] == "KubelabWarmPool" {
+				if err := r.Delete(ctx, &podList.Items[i]); client.IgnoreNotFound(err) != nil {
+					log.Error(err, "Failed to delete warm pod of lab")
+					return ctrl.Result{}, err
+				}
+			}
+		}
+		if len(podList.Items) > 0 {
+			return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+		}
+		return r.setRestorePhase(ctx, restore, restoreRestoring, "Restoring workspace")
+
+	case restoreRestoring:
+		if snapshot.Status.Method == snapshotMethodVolumeSnapshot {
+			workspace := &v1.PersistentVolumeClaim{}
+			if err := r.Get(ctx, types.NamespacedName{Name: snapshot.Namespace + "-" + claimNameWork, Namespace: snapshot.Spec.Student}, workspace); err != nil {
+				log.Error(err, "Failed to get workspace PVC")
+				return ctrl.Result{}, err
+			}
+			claim, err := r.sourceClaimForRestore(restore, snapshot, workspace)
+			if err != nil {
+				log.Error(err, "Failed to define new source PVC for LabRestore")
+				return ctrl.Result{}, err
+			}
+			if err := r.Create(ctx, claim); err != nil && !apierrors.IsAlreadyExists(err) {
+				log.Error(err, "Failed to create new source PVC", "PVC.Namespace", claim.Namespace, "PVC.Name", claim.Name)
+				return ctrl.Result{}, err
+			}
+		}
+
+		job := &batchv1.Job{}
+		if err := r.Get(ctx, types.NamespacedName{Name: restore.Name + "-restore", Namespace: snapshot.Spec.Student}, job); err != nil {
+			if !apierrors.IsNotFound(err) {
+				log.Error(err, "Failed to get restore Job")
+				return ctrl.Result{}, err
+			}
+			job, err := r.jobForRestore(restore, snapshot)
+			if err != nil {
+				log.Error(err, "Failed to define new restore Job for LabRestore")
+				return ctrl.Result{}, err
+			}
+			if err := r.Create(ctx, job); err != nil {
+				log.Error(err, "Failed to create new restore Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+		if condition := failedCondition(job); condition != nil {
+			return r.setRestorePhase(ctx, restore, snapshotFailed, "Failed: "+condition.Message)
+		}
+		if job.Status.Succeeded == 0 {
+			return ctrl.Result{}, nil
+		}
+
+		// the volume provisioned from the VolumeSnapshot is not needed anymore
+		if snapshot.Status.Method == snapshotMethodVolumeSnapshot {
+			claim := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: restore.Name + "-source", Namespace: snapshot.Spec.Student}}
+			if err := r.Delete(ctx, claim); err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "Failed to delete source PVC")
+				return ctrl.Result{}, err
+			}
+		}
+		if err := r.scaleLab(ctx, lab, restore.Status.Replicas); err != nil {
+			return ctrl.Result{}, err
+		}
+		if snapshot.Status.ContainerIncluded && restore.Status.Replicas > 0 {
+			return r.setRestorePhase(ctx, restore, restoreStarting, "Starting lab")
+		}
+		return r.setRestorePhase(ctx, restore, restoreRestored, fmt.Sprintf("Restored snapshot %s", snapshot.Name))
+
+	case restoreStarting:
+		pod, err := runningLabPod(ctx, r.Client, snapshot.Namespace, snapshot.Spec.Student)
+		if err != nil {
+			log.Error(err, "Failed to list pods of lab")
+			return ctrl.Result{}, err
+		}
+		if pod == nil {
+			return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+		}
+		if _, err := execInPod(ctx, r.Config, r.Clientset, pod, []string{"sh", "-c", unpackContainerScript, workspaceMountPath(pod), containerArchive}); err != nil {
+			return r.setRestorePhase(ctx, restore, snapshotFailed, fmt.Sprintf("Failed to unpack the container: %s", err))
+		}
+		return r.setRestorePhase(ctx, restore, restoreRestored, fmt.Sprintf("Restored snapshot %s", snapshot.Name))
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// scaleLab sets the replicas of the StatefulSet of the lab
+func (r *LabRestoreReconciler) scaleLab(ctx context.Context, lab *appsv1.StatefulSet, replicas int32) error {
+	lab.Spec.Replicas = &replicas
+	if err := r.Update(ctx, lab); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to scale StatefulSet", "StatefulSet.Namespace", lab.Namespace, "StatefulSet.Name", lab.Name)
+		return err
+	}
+	return nil
+}
+
+// setRestorePhase updates the phase and the condition of the restore
+func (r *LabRestoreReconciler) setRestorePhase(ctx context.Context, restore *kubelabv1.LabRestore, phase string, message string) (ctrl.Result, error) {
+	restore.Status.Phase = phase
+	meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{Type: typeAvailable,
+		Status: conditionStatus(phase == restoreRestored), Reason: phase,
+		Message: message})
+	if err := r.Status().Update(ctx, restore); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update lab restore status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: phase != restoreRestored && phase != snapshotFailed}, nil
+}
+
+// deleteRestoreResources deletes the Job and the source PVC of the restore inside the namespace of the student
+func (r *LabRestoreReconciler) deleteRestoreResources(ctx context.Context, restore *kubelabv1.LabRestore) error {
+	owner := client.MatchingLabels{labelLabRestore: restore.Name, "class": restore.Namespace}
+	jobList := &batchv1.JobList{}
+	if err := r.List(ctx, jobList, owner); err != nil {
+		return err
+	}
+	for i := range jobList.Items {
+		if err := r.Delete(ctx, &jobList.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	claimList := &v1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, claimList, owner); err != nil {
+		return err
+	}
+	for i := range claimList.Items {
+		if err := r.Delete(ctx, &claimList.Items[i]); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LabRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubelabv1.LabRestore{}).
+		Watches(&source.Kind{Type: &batchv1.Job{}}, handler.EnqueueRequestsFromMapFunc(requestsForLabelOwner(labelLabRestore))).
+		Complete(r)
+}
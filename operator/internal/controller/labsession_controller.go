@@ -0,0 +1,257 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// LabSessionReconciler starts and stops the lab of a student as requested by a LabSession in the namespace of the student.
+// The policy of the classroom is checked before the lab is started, so students do not need to scale the StatefulSets of their labs.
+type LabSessionReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Limits   LabLimits
+}
+
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labsessions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labsessions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labsessions/finalizers,verbs=update
+
+//Custom RBAC
+//+kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups="apps",resources=deployments,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=classrooms,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *LabSessionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Fetch the instance and check if it exist
+	session := &kubelabv1.LabSession{}
+	if err := r.Get(ctx, req.NamespacedName, session); err != nil {
+		if apierrors.IsNotFound(err) {
+			// If the custom resource is not found then, it usually means that it was deleted or not created
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get LabSession")
+		return ctrl.Result{}, err
+	}
+	if !session.ObjectMeta.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	// The student is given by the namespace, so students can only start their own labs
+	student := session.Namespace
+	classroom := &kubelabv1.Classroom{}
+	if err := r.Get(ctx, client.ObjectKey{Name: session.Spec.Classroom}, classroom); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.setSessionPhase(ctx, session, sessionDenied, "Classroom "+session.Spec.Classroom+" does not exist")
+		}
+		log.Error(err, "Failed to get Classroom")
+		return ctrl.Result{}, err
+	}
+	lab := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: classroom.Name, Namespace: student}, lab); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.reconcileLegacyLab(ctx, session, classroom)
+		}
+		log.Error(err, "Failed to get StatefulSet")
+		return ctrl.Result{}, err
+	}
+
+	// The lab is only scaled for a new session, if the spec of the session changed or a denied start is retried,
+	// so the session does not fight a restore, a reset, a limit or the staff starting or stopping the lab
+	requested := session.Status.Phase == "" || session.Status.ObservedGeneration != session.Generation || session.Status.Phase == sessionDenied
+	if !requested && (session.Spec.State == sessionRunning) != labRunning(lab) {
+		// the lab was started or stopped by someone else, the session follows it in every phase,
+		// so the next start or stop of the student changes the spec again and is not ignored
+		if err := syncLabSession(ctx, r.Client, session, labRunning(lab)); err != nil {
+			log.Error(err, "Failed to update lab session")
+			return ctrl.Result{}, err
+		}
+	} else if requested {
+		replicas := int32(0)
+		if session.Spec.State == sessionRunning {
+			classrooms := &kubelabv1.ClassroomList{}
+			if err := r.List(ctx, classrooms); err != nil {
+				log.Error(err, "Failed to list classrooms")
+				return ctrl.Result{}, err
+			}
+			labs := &appsv1.StatefulSetList{}
+			if err := r.List(ctx, labs, client.MatchingLabels{"app.kubernetes.io/name": "KubelabClassroom"}); err != nil {
+				log.Error(err, "Failed to list labs")
+				return ctrl.Result{}, err
+			}
+			quota, err := quotaOfLab(ctx, r.Client, lab)
+			if err != nil {
+				log.Error(err, "Failed to get ResourceQuota")
+				return ctrl.Result{}, err
+			}
+			if message := labSessionDenied(student, classroom, classrooms.Items, lab, labs.Items, quota, r.Limits); message != "" {
+				if session.Status.Phase != sessionDenied || session.Status.Message != message {
+					recordWarning(r.Recorder, session, "LabSessionDenied", message)
+				}
+				return r.setSessionPhase(ctx, session, sessionDenied, message)
+			}
+			replicas = 1
+		}
+
+		if lab.Spec.Replicas == nil || *lab.Spec.Replicas != replicas {
+			lab.Spec.Replicas = &replicas
+			if err := recordEvent(r.Recorder, session, "Update", lab, r.Update(ctx, lab)); err != nil {
+				log.Error(err, "Failed to scale StatefulSet", "StatefulSet.Namespace", lab.Namespace, "StatefulSet.Name", lab.Name)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	switch {
+	case !labRunning(lab):
+		return r.setSessionPhase(ctx, session, sessionStopped, "Lab is stopped")
+	case lab.Status.ReadyReplicas == 0:
+		return r.setSessionPhase(ctx, session, sessionStarting, "Lab is starting")
+	default:
+		return r.setSessionPhase(ctx, session, sessionRunning, "Lab is running")
+	}
+}
+
+// reconcileLegacyLab follows a lab, which still runs in the Deployment of an older version, so the student can stop it.
+// The classroom replaces the Deployment by a StatefulSet once it is stopped, the session waits for it to start the lab again.
+func (r *LabSessionReconciler) reconcileLegacyLab(ctx context.Context, session *kubelabv1.LabSession, classroom *kubelabv1.Classroom) (ctrl.Result, error) {
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: classroom.Name, Namespace: session.Namespace}, deployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.setSessionPhase(ctx, session, sessionDenied, "Lab of classroom "+classroom.Name+" does not exist")
+		}
+		log.FromContext(ctx).Error(err, "Failed to get Deployment")
+		return ctrl.Result{}, err
+	}
+	running := deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > 0
+	if running && session.Spec.State == sessionStopped {
+		replicas := int32(0)
+		deployment.Spec.Replicas = &replicas
+		if err := recordEvent(r.Recorder, session, "Update", deployment, r.Update(ctx, deployment)); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to scale Deployment", "Deployment.Namespace", deployment.Namespace, "Deployment.Name", deployment.Name)
+			return ctrl.Result{}, err
+		}
+		running = false
+	}
+	switch {
+	case !running:
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	case deployment.Status.ReadyReplicas == 0:
+		return r.setSessionPhase(ctx, session, sessionStarting, "Lab is starting")
+	default:
+		return r.setSessionPhase(ctx, session, sessionRunning, "Lab is running")
+	}
+}
+
+// stopLabSession sets the state of the session of the lab to Stopped, if the lab was stopped without the session.
+// Otherwise the session would keep the state Running and could not be started again by the student.
+func stopLabSession(ctx context.Context, c client.Client, student string, classroom string) error {
+	session := &kubelabv1.LabSession{}
+	if err := c.Get(ctx, types.NamespacedName{Name: classroom, Namespace: student}, session); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return syncLabSession(ctx, c, session, false)
+}
+
+// syncLabSession sets the state of the session to the one of the lab, which was started or stopped without the session
+func syncLabSession(ctx context.Context, c client.Client, session *kubelabv1.LabSession, running bool) error {
+	state := sessionStopped
+	if running {
+		state = sessionRunning
+	}
+	if session.Spec.State == state {
+		return nil
+	}
+	session.Spec.State = state
+	return c.Update(ctx, session)
+}
+
+// setSessionPhase updates the phase and the condition of the session
+func (r *LabSessionReconciler) setSessionPhase(ctx context.Context, session *kubelabv1.LabSession, phase string, message string) (ctrl.Result, error) {
+	if session.Status.Phase != phase || session.Status.Message != message || session.Status.ObservedGeneration != session.Generation {
+		session.Status.Phase = phase
+		session.Status.Message = message
+		session.Status.ObservedGeneration = session.Generation
+		meta.SetStatusCondition(&session.Status.Conditions, metav1.Condition{Type: typeAvailable,
+			Status: conditionStatus(phase == session.Spec.State), Reason: phase,
+			Message: message})
+		if err := r.Status().Update(ctx, session); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to update lab session status")
+			return ctrl.Result{}, err
+		}
+	}
+	// Denied sessions are checked again, the lab may start once the classroom or the other labs change
+	if phase == sessionDenied {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LabSessionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubelabv1.LabSession{}).
+		// The sessions follow the labs of the student, e.g. once a lab is ready or stopped by the classroom
+		Watches(
+			&source.Kind{Type: &appsv1.StatefulSet{}},
+			handler.EnqueueRequestsFromMapFunc(r.sessionsForLab),
+			builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				return obj.GetLabels()["app.kubernetes.io/name"] == "KubelabClassroom"
+			})),
+		).
+		Complete(r)
+}
+
+// sessionsForLab returns all sessions of the student, a denied session may start once another lab of the student is stopped
+func (r *LabSessionReconciler) sessionsForLab(obj client.Object) []reconcile.Request {
+	sessions := &kubelabv1.LabSessionList{}
+	if err := r.List(context.Background(), sessions, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, session := range sessions.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: session.Name, Namespace: session.Namespace}})
+	}
+	return requests
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+func TestLabSessionStartsLabOfEnrolledStudent(t *testing.T) {
+	lab := func(student string) *appsv1.StatefulSet {
+		replicas := int32(0)
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "linux", Namespace: student, Labels: labelsForClassroom("linux", student)},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		}
+	}
+	session := func(student string) *kubelabv1.LabSession {
+		return &kubelabv1.LabSession{
+			ObjectMeta: metav1.ObjectMeta{Name: "linux", Namespace: student},
+			Spec:       kubelabv1.LabSessionSpec{Classroom: "linux", State: sessionRunning},
+		}
+	}
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "linux"},
+		Status:     kubelabv1.ClassroomStatus{Students: []string{"575103"}, Phase: classroomActive},
+	}
+	r := &LabSessionReconciler{
+		Client: fake.NewClientBuilder().WithScheme(testScheme).
+			WithObjects(classroom, lab("575103"), session("575103"), lab("575104"), session("575104")).Build(),
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+
+	for student, want := range map[string]struct {
+		phase    string
+		replicas int32
+	}{"575103": {sessionStarting, 1}, "575104": {sessionDenied, 0}} {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "linux", Namespace: student}}); err != nil {
+			t.Fatal(err)
+		}
+		s := &kubelabv1.LabSession{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: "linux", Namespace: student}, s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Status.Phase != want.phase {
+			t.Errorf("session of %s is %s (%s), want %s", student, s.Status.Phase, s.Status.Message, want.phase)
+		}
+		statefulSet := &appsv1.StatefulSet{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: "linux", Namespace: student}, statefulSet); err != nil {
+			t.Fatal(err)
+		}
+		if *statefulSet.Spec.Replicas != want.replicas {
+			t.Errorf("lab of %s has %d replicas, want %d", student, *statefulSet.Spec.Replicas, want.replicas)
+		}
+	}
+}
+
+// A lab stopped by a restore, a reset, a limit or the staff is not started again until the student changes the session.
+func TestLabSessionDoesNotFightStops(t *testing.T) {
+	stopped := int32(0)
+	lab := func(student string) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "linux", Namespace: student, Labels: labelsForClassroom("linux", student)},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &stopped},
+		}
+	}
+	session := func(student string, phase string) *kubelabv1.LabSession {
+		return &kubelabv1.LabSession{
+			ObjectMeta: metav1.ObjectMeta{Name: "linux", Namespace: student, Generation: 1},
+			Spec:       kubelabv1.LabSessionSpec{Classroom: "linux", State: sessionRunning},
+			Status:     kubelabv1.LabSessionStatus{Phase: phase, ObservedGeneration: 1},
+		}
+	}
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "linux"},
+		Status:     kubelabv1.ClassroomStatus{Students: []string{"575103", "575104"}, Phase: classroomActive},
+	}
+	r := &LabSessionReconciler{
+		Client: fake.NewClientBuilder().WithScheme(testScheme).
+			WithObjects(classroom, lab("575103"), session("575103", sessionStarting), lab("575104"), session("575104", sessionRunning)).Build(),
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+	ctx := context.Background()
+	reconcile := func(student string) (*kubelabv1.LabSession, int32) {
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "linux", Namespace: student}}); err != nil {
+			t.Fatal(err)
+		}
+		s := &kubelabv1.LabSession{}
+		if err := r.Get(ctx, types.NamespacedName{Name: "linux", Namespace: student}, s); err != nil {
+			t.Fatal(err)
+		}
+		statefulSet := &appsv1.StatefulSet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: "linux", Namespace: student}, statefulSet); err != nil {
+			t.Fatal(err)
+		}
+		return s, *statefulSet.Spec.Replicas
+	}
+
+	// stopped while starting, e.g. by a restore, which starts the lab again itself
+	s, replicas := reconcile("575103")
+	if replicas != 0 || s.Status.Phase != sessionStopped || s.Spec.State != sessionStopped {
+		t.Errorf("stopped lab was started again: %d replicas, phase %s, state %s", replicas, s.Status.Phase, s.Spec.State)
+	}
+	// the web app sends the start as a new spec, which is not ignored
+	s.Spec.State = sessionRunning
+	s.Generation++
+	if err := r.Update(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if s, replicas := reconcile("575103"); replicas != 1 || s.Status.Phase != sessionStarting {
+		t.Errorf("lab stopped while starting was not started again: %d replicas, phase %s", replicas, s.Status.Phase)
+	}
+
+	// stopped after it was running, e.g. by the staff, the session follows the lab
+	s, replicas = reconcile("575104")
+	if replicas != 0 || s.Spec.State != sessionStopped {
+		t.Errorf("session did not follow the stopped lab: %d replicas, state %s", replicas, s.Spec.State)
+	}
+
+	// the student starts the lab again
+	s.Spec.State = sessionRunning
+	s.Generation++
+	if err := r.Update(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if s, replicas := reconcile("575104"); replicas != 1 || s.Status.Phase != sessionStarting {
+		t.Errorf("lab was not started again: %d replicas, phase %s", replicas, s.Status.Phase)
+	}
+
+	// the student stops the lab, a teacher starts it again, the session follows the lab, so the student can stop it
+	s, _ = reconcile("575103")
+	s.Spec.State = sessionStopped
+	s.Generation++
+	if err := r.Update(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if _, replicas := reconcile("575103"); replicas != 0 {
+		t.Fatalf("lab was not stopped: %d replicas", replicas)
+	}
+	started := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "linux", Namespace: "575103"}, started); err != nil {
+		t.Fatal(err)
+	}
+	running := int32(1)
+	started.Spec.Replicas = &running
+	if err := r.Update(ctx, started); err != nil {
+		t.Fatal(err)
+	}
+	if s, replicas := reconcile("575103"); replicas != 1 || s.Spec.State != sessionRunning {
+		t.Errorf("session did not follow the lab started by a teacher: %d replicas, state %s", replicas, s.Spec.State)
+	}
+}
+
+// A lab still running in the Deployment of an older version can be stopped by its session, it is never started again.
+func TestLabSessionStopsLegacyLab(t *testing.T) {
+	running := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "linux", Namespace: "575103", Labels: labelsForClassroom("linux", "575103")},
+		Spec:       appsv1.DeploymentSpec{Replicas: &running},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1},
+	}
+	session := &kubelabv1.LabSession{
+		ObjectMeta: metav1.ObjectMeta{Name: "linux", Namespace: "575103"},
+		Spec:       kubelabv1.LabSessionSpec{Classroom: "linux", State: sessionRunning},
+	}
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "linux"},
+		Status:     kubelabv1.ClassroomStatus{Students: []string{"575103"}, Phase: classroomActive},
+	}
+	r := &LabSessionReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(testScheme).WithObjects(classroom, deployment, session).Build(),
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "linux", Namespace: "575103"}
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, key, session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Status.Phase != sessionRunning {
+		t.Errorf("session of the legacy lab is %s (%s)", session.Status.Phase, session.Status.Message)
+	}
+
+	session.Spec.State = sessionStopped
+	if err := r.Update(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, key, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if *deployment.Spec.Replicas != 0 {
+		t.Errorf("legacy lab has %d replicas after the session was stopped", *deployment.Spec.Replicas)
+	}
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// States and phases of a lab session
+const (
+	sessionRunning  = "Running"
+	sessionStopped  = "Stopped"
+	sessionStarting = "Starting"
+	sessionDenied   = "Denied"
+)
+
+// labSessionDenied returns why the student may not start the lab or an empty string if the lab may be started.
+// The classrooms are needed to check the exams of the student, the labs to check the running-lab limits
+// and the quota of the namespace of the student to check that the pod of the lab fits.
+func labSessionDenied(student string, classroom *kubelabv1.Classroom, classrooms []kubelabv1.Classroom, lab *appsv1.StatefulSet, labs []appsv1.StatefulSet, quota *v1.ResourceQuota, limits LabLimits) string {
+	for _, member := range staffOfClassroom(classroom) {
+		if member.Id == student {
+			return ""
+		}
+	}
+	if !containsString(classroom.Status.Students, student) {
+		return fmt.Sprintf("%s is not enrolled in classroom %s", student, classroom.Name)
+	}
+	if classroom.Status.Phase != classroomActive {
+		return fmt.Sprintf("Classroom %s is %s", classroom.Name, strings.ToLower(classroom.Status.Phase))
+	}
+	// During an exam only the lab of the exam may run
+	for _, other := range classrooms {
+		if other.Name != classroom.Name && strings.ToLower(other.Spec.EnableExamMode) == "true" &&
+			other.Status.Phase == classroomActive && containsString(other.Status.Students, student) {
+			return fmt.Sprintf("The exam of classroom %s is running", other.Name)
+		}
+	}
+	if message := quotaExceeded(lab, quota); message != "" {
+		return message
+	}
+	if !labRunning(lab) {
+		return labLimitExceeded(lab, labs, classroom, classrooms, limits)
+	}
+	return ""
+}
@@ -0,0 +1,353 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// LabSnapshotReconciler reconciles a LabSnapshot object
+type LabSnapshotReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Config and Clientset are needed to pack the filesystem of a running lab, which the controller-runtime client does not support
+	Config    *rest.Config
+	Clientset kubernetes.Interface
+}
+
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labsnapshots,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labsnapshots/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labsnapshots/finalizers,verbs=update
+
+//Custom RBAC
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=classrooms,verbs=get;list;watch
+//+kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
+//+kubebuilder:rbac:groups="snapshot.storage.k8s.io",resources=volumesnapshots,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups="snapshot.storage.k8s.io",resources=volumesnapshotclasses,verbs=get;list;watch
+
+func (r *LabSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Fetch the instance and check if it exist
+	snapshot := &kubelabv1.LabSnapshot{}
+	if err := r.Get(ctx, req.NamespacedName, snapshot); err != nil {
+		if apierrors.IsNotFound(err) {
+			// If the custom resource is not found then, it usually means that it was deleted or not created
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get LabSnapshot")
+		return ctrl.Result{}, err
+	}
+
+	// set the status as Unknown when no status are available
+	if snapshot.Status.Conditions == nil || len(snapshot.Status.Conditions) == 0 {
+		meta.SetStatusCondition(&snapshot.Status.Conditions, metav1.Condition{Type: typeAvailable, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
+		snapshot.Status.Phase = snapshotPending
+		if err := r.Status().Update(ctx, snapshot); err != nil {
+			log.Error(err, "Failed to update lab snapshot status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// The VolumeSnapshot and the Job live in the namespace of the student, so the finalizer removes them with the snapshot
+	if snapshot.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(snapshot, labSnapshotFinalizer) {
+			controllerutil.AddFinalizer(snapshot, labSnapshotFinalizer)
+			if err := r.Update(ctx, snapshot); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+	} else {
+		if controllerutil.ContainsFinalizer(snapshot, labSnapshotFinalizer) {
+			if err := r.deleteSnapshotResources(ctx, snapshot); err != nil {
+				log.Error(err, "Failed to delete resources of lab snapshot")
+				return ctrl.Result{}, err
+			}
+			controllerutil.RemoveFinalizer(snapshot, labSnapshotFinalizer)
+			if err := r.Update(ctx, snapshot); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// A snapshot is only taken once
+	if snapshot.Status.Phase == snapshotReady || snapshot.Status.Phase == snapshotFailed {
+		return ctrl.Result{}, nil
+	}
+
+	// The namespace of the snapshot is the one of its classroom, only the labs of its students can be snapshotted
+	classroom := &kubelabv1.Classroom{}
+	if err := r.Get(ctx, client.ObjectKey{Name: snapshot.Namespace}, classroom); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.setSnapshotPhase(ctx, snapshot, snapshotFailed, fmt.Sprintf("Classroom does not exist: %s", snapshot.Namespace))
+		}
+		log.Error(err, "Failed to get Classroom")
+		return ctrl.Result{}, err
+	}
+	if !containsString(classroom.Status.Students, snapshot.Spec.Student) {
+		return r.setSnapshotPhase(ctx, snapshot, snapshotFailed, fmt.Sprintf("Student %s is not enrolled in %s", snapshot.Spec.Student, snapshot.Namespace))
+	}
+
+	workspace := &v1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: snapshot.Namespace + "-" + claimNameWork, Namespace: snapshot.Spec.Student}, workspace); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.setSnapshotPhase(ctx, snapshot, snapshotFailed, fmt.Sprintf("Workspace of %s in %s does not exist", snapshot.Spec.Student, snapshot.Namespace))
+		}
+		log.Error(err, "Failed to get workspace PVC")
+		return ctrl.Result{}, err
+	}
+
+	// The filesystem of the container is packed into the workspace, so it is part of the snapshot
+	if snapshot.Spec.IncludeContainer && !snapshot.Status.ContainerIncluded {
+		pod, err := runningLabPod(ctx, r.Client, snapshot.Namespace, snapshot.Spec.Student)
+		if err != nil {
+			log.Error(err, "Failed to list pods of lab")
+			return ctrl.Result{}, err
+		}
+		if pod == nil {
+			return r.setSnapshotPhase(ctx, snapshot, snapshotFailed, "The lab has to run to include the container")
+		}
+		if _, err := execInPod(ctx, r.Config, r.Clientset, pod, []string{"sh", "-c", packContainerScript, workspaceMountPath(pod), containerArchive}); err != nil {
+			return r.setSnapshotPhase(ctx, snapshot, snapshotFailed, fmt.Sprintf("Failed to pack the container: %s", err))
+		}
+		snapshot.Status.ContainerIncluded = true
+		if err := r.Status().Update(ctx, snapshot); err != nil {
+			log.Error(err, "Failed to update lab snapshot status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// Volumes of a CSI driver with a snapshot class are snapshotted, all others are copied by a Job
+	if snapshot.Status.Method == "" {
+		className, err := r.snapshotClassForWorkspace(ctx, snapshot, workspace)
+		if err != nil {
+			log.Error(err, "Failed to find VolumeSnapshotClass")
+			return ctrl.Result{}, err
+		}
+		if className != "" {
+			vs := volumeSnapshotForLab(snapshot, className)
+			if err := r.Create(ctx, vs); err != nil && !apierrors.IsAlreadyExists(err) {
+				log.Error(err, "Failed to create VolumeSnapshot", "VolumeSnapshot.Namespace", vs.GetNamespace(), "VolumeSnapshot.Name", vs.GetName())
+				return ctrl.Result{}, err
+			}
+			snapshot.Status.Method = snapshotMethodVolumeSnapshot
+			snapshot.Status.VolumeSnapshot = vs.GetName()
+		} else {
+			job, err := r.copyJobForSnapshot(snapshot)
+			if err != nil {
+				log.Error(err, "Failed to define new snapshot Job for LabSnapshot")
+				return ctrl.Result{}, err
+			}
+			if err := r.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+				log.Error(err, "Failed to create new snapshot Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+				return ctrl.Result{}, err
+			}
+			snapshot.Status.Method = snapshotMethodCopy
+			snapshot.Status.Path = snapshotPath(snapshot)
+		}
+		return r.setSnapshotPhase(ctx, snapshot, snapshotPending, fmt.Sprintf("Taking snapshot by %s", snapshot.Status.Method))
+	}
+
+	if snapshot.Status.Method == snapshotMethodVolumeSnapshot {
+		vs := &unstructured.Unstructured{}
+		vs.SetGroupVersionKind(volumeSnapshotGVK)
+		if err := r.Get(ctx, types.NamespacedName{Name: snapshot.Status.VolumeSnapshot, Namespace: snapshot.Spec.Student}, vs); err != nil {
+			if apierrors.IsNotFound(err) {
+				return r.setSnapshotPhase(ctx, snapshot, snapshotFailed, "VolumeSnapshot was deleted")
+			}
+			log.Error(err, "Failed to get VolumeSnapshot")
+			return ctrl.Result{}, err
+		}
+		if message, _, _ := unstructured.NestedString(vs.Object, "status", "error", "message"); message != "" {
+			return r.setSnapshotPhase(ctx, snapshot, snapshotFailed, message)
+		}
+		// VolumeSnapshots are not watched, since the snapshot API is optional
+		if ready, _, _ := unstructured.NestedBool(vs.Object, "status", "readyToUse"); !ready {
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		return r.setSnapshotPhase(ctx, snapshot, snapshotReady, fmt.Sprintf("VolumeSnapshot %s is ready", vs.GetName()))
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: snapshot.Name + "-snapshot", Namespace: snapshot.Spec.Student}, job); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.setSnapshotPhase(ctx, snapshot, snapshotFailed, "Snapshot Job was deleted")
+		}
+		log.Error(err, "Failed to get snapshot Job")
+		return ctrl.Result{}, err
+	}
+	if condition := failedCondition(job); condition != nil {
+		return r.setSnapshotPhase(ctx, snapshot, snapshotFailed, "Failed: "+condition.Message)
+	}
+	if job.Status.Succeeded == 0 {
+		return ctrl.Result{}, nil
+	}
+	return r.setSnapshotPhase(ctx, snapshot, snapshotReady, fmt.Sprintf("Copied to %s", snapshot.Status.Path))
+}
+
+// setSnapshotPhase updates the phase and the condition of the snapshot
+func (r *LabSnapshotReconciler) setSnapshotPhase(ctx context.Context, snapshot *kubelabv1.LabSnapshot, phase string, message string) (ctrl.Result, error) {
+	snapshot.Status.Phase = phase
+	meta.SetStatusCondition(&snapshot.Status.Conditions, metav1.Condition{Type: typeAvailable,
+		Status: conditionStatus(phase == snapshotReady), Reason: phase,
+		Message: message})
+	if err := r.Status().Update(ctx, snapshot); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update lab snapshot status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// deleteSnapshotResources deletes the VolumeSnapshot and the Job of the snapshot inside the namespace of the student
+func (r *LabSnapshotReconciler) deleteSnapshotResources(ctx context.Context, snapshot *kubelabv1.LabSnapshot) error {
+	if snapshot.Status.VolumeSnapshot != "" {
+		vs := &unstructured.Unstructured{}
+		vs.SetGroupVersionKind(volumeSnapshotGVK)
+		vs.SetName(snapshot.Status.VolumeSnapshot)
+		vs.SetNamespace(snapshot.Spec.Student)
+		if err := r.Delete(ctx, vs); err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return err
+		}
+	}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: snapshot.Name + "-snapshot", Namespace: snapshot.Spec.Student}}
+	if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// snapshotClassForWorkspace returns the VolumeSnapshotClass for the workspace, or an empty name if it can not be snapshotted.
+// The default class of the CSI driver is preferred.
+func (r *LabSnapshotReconciler) snapshotClassForWorkspace(ctx context.Context, snapshot *kubelabv1.LabSnapshot, workspace *v1.PersistentVolumeClaim) (string, error) {
+	if snapshot.Spec.VolumeSnapshotClassName != "" {
+		return snapshot.Spec.VolumeSnapshotClassName, nil
+	}
+	if workspace.Spec.VolumeName == "" {
+		return "", nil
+	}
+	pv := &v1.PersistentVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: workspace.Spec.VolumeName}, pv); err != nil {
+		return "", err
+	}
+	if pv.Spec.CSI == nil {
+		return "", nil
+	}
+
+	classList := &unstructured.UnstructuredList{}
+	classList.SetGroupVersionKind(volumeSnapshotClassListGVK)
+	if err := r.List(ctx, classList); err != nil {
+		// the snapshot API is not installed
+		if meta.IsNoMatchError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	className := ""
+	for _, class := range classList.Items {
+		if driver, _, _ := unstructured.NestedString(class.Object, "driver"); driver != pv.Spec.CSI.Driver {
+			continue
+		}
+		if class.GetAnnotations()["snapshot.storage.kubernetes.io/is-default-class"] == "true" {
+			return class.GetName(), nil
+		}
+		if className == "" {
+			className = class.GetName()
+		}
+	}
+	return className, nil
+}
+
+// runningLabPod returns the running pod of the lab, or nil if the lab is not running
+func runningLabPod(ctx context.Context, c client.Reader, classroom string, student string) (*v1.Pod, error) {
+	podList := &v1.PodList{}
+	if err := c.List(ctx, podList, client.InNamespace(student), labPodSelector(classroom, student)); err != nil {
+		return nil, err
+	}
+	for i, pod := range podList.Items {
+		if pod.Status.Phase == v1.PodRunning && pod.ObjectMeta.DeletionTimestamp.IsZero() {
+			return &podList.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// execInPod runs the command inside the lab container and returns its output.
+// The command blocks the reconciler, so it is given up after containerArchiveTimeout.
+func execInPod(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, pod *v1.Pod, command []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, containerArchiveTimeout)
+	defer cancel()
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: pod.Spec.Containers[0].Name,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	if err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr}); err != nil {
+		return stdout.String(), fmt.Errorf("%w: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LabSnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubelabv1.LabSnapshot{}).
+		Watches(&source.Kind{Type: &batchv1.Job{}}, handler.EnqueueRequestsFromMapFunc(requestsForLabelOwner(labelLabSnapshot))).
+		Complete(r)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// Workspaces without a CSI driver are copied onto the NFS share by a Job.
+func TestLabSnapshotFallsBackToCopy(t *testing.T) {
+	snapshot := &kubelabv1.LabSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "java-575103-before-exam", Namespace: "java"},
+		Spec:       kubelabv1.LabSnapshotSpec{Student: "575103"},
+	}
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "java"},
+		Status:     kubelabv1.ClassroomStatus{Students: []string{"575103"}},
+	}
+	workspace := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "java-" + claimNameWork, Namespace: "575103"}}
+	r := &LabSnapshotReconciler{
+		Client: newTestClient(snapshot, classroom, workspace),
+		Scheme: testScheme,
+	}
+
+	reconcile := func() {
+		for i := 0; i < 4; i++ {
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(snapshot)}); err != nil {
+				t.Fatalf("reconcile failed: %v", err)
+			}
+		}
+		if err := r.Get(context.Background(), client.ObjectKeyFromObject(snapshot), snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcile()
+	if snapshot.Status.Method != snapshotMethodCopy || snapshot.Status.Phase != snapshotPending {
+		t.Fatalf("snapshot was not copied: %s %s", snapshot.Status.Method, snapshot.Status.Phase)
+	}
+	if snapshot.Status.Path != "snapshots/java/575103/java-575103-before-exam" {
+		t.Errorf("unexpected snapshot path %s", snapshot.Status.Path)
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: snapshot.Name + "-snapshot", Namespace: "575103"}, job); err != nil {
+		t.Fatalf("snapshot job was not created: %v", err)
+	}
+	job.Status.Succeeded = 1
+	if err := r.Status().Update(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcile()
+	if snapshot.Status.Phase != snapshotReady {
+		t.Errorf("snapshot is not ready after the job succeeded: %s", snapshot.Status.Phase)
+	}
+
+	// The job lives in the namespace of the student and is removed by the finalizer
+	if err := r.Delete(context.Background(), snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(snapshot)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(job), job); !apierrors.IsNotFound(err) {
+		t.Errorf("snapshot job was not removed with the snapshot: %v", err)
+	}
+}
+
+// Only the labs of students enrolled in the classroom of the namespace can be snapshotted.
+func TestLabSnapshotOfOtherClassroomFails(t *testing.T) {
+	snapshot := &kubelabv1.LabSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "steal", Namespace: "python", Finalizers: []string{labSnapshotFinalizer}},
+		Spec:       kubelabv1.LabSnapshotSpec{Student: "575103"},
+		Status:     kubelabv1.LabSnapshotStatus{Conditions: []metav1.Condition{{Type: typeAvailable, Status: metav1.ConditionUnknown, Reason: "Reconciling"}}, Phase: snapshotPending},
+	}
+	classroom := &kubelabv1.Classroom{
+		ObjectMeta: metav1.ObjectMeta{Name: "python"},
+		Status:     kubelabv1.ClassroomStatus{Students: []string{"575104"}},
+	}
+	workspace := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "python-" + claimNameWork, Namespace: "575103"}}
+	r := &LabSnapshotReconciler{Client: newTestClient(snapshot, classroom, workspace), Scheme: testScheme}
+
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(snapshot)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(snapshot), snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Status.Phase != snapshotFailed || snapshot.Status.Method != "" {
+		t.Errorf("snapshot of a student of another classroom was taken: %s %s", snapshot.Status.Phase, snapshot.Status.Method)
+	}
+}
@@ -0,0 +1,264 @@
+package controller
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Phases of a snapshot and a restore
+const (
+	snapshotPending  = "Pending"
+	snapshotReady    = "Ready"
+	snapshotFailed   = "Failed"
+	restoreStopping  = "Stopping"
+	restoreRestoring = "Restoring"
+	restoreStarting  = "Starting"
+	restoreRestored  = "Restored"
+)
+
+// Labels of the resources inside the namespace of the student pointing to their snapshot or restore
+const (
+	labelLabSnapshot = "labsnapshot"
+	labelLabRestore  = "labrestore"
+)
+
+// Methods of a snapshot
+const (
+	snapshotMethodVolumeSnapshot = "VolumeSnapshot"
+	snapshotMethodCopy           = "Copy"
+)
+
+// The snapshot API is optional, so VolumeSnapshots are handled as unstructured objects
+var (
+	volumeSnapshotGVK          = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshot"}
+	volumeSnapshotClassListGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshotClassList"}
+)
+
+// The scripts get the paths via environment variables, so the spec can not inject shell code
+const snapshotCopyScript = `set -e
+mkdir -p "/nfs/$SNAPSHOT_PATH"
+cp -a /work/. "/nfs/$SNAPSHOT_PATH/"`
+
+const restoreScript = `set -e
+find /work -mindepth 1 -delete
+cp -a /source/. /work/`
+
+// packContainerScript packs the filesystem of the lab container into the workspace, mounted volumes are skipped.
+// GNU tar exits with 1 if files changed while reading, which is fine for a running lab.
+// The archive is written to a temporary file first, so a pack which timed out never replaces the archive of an earlier snapshot.
+const packContainerScript = `mkdir -p "$(dirname "$0/$1")" && { tar -czpf "$0/$1.tmp" --one-file-system --exclude="$0" / ; [ $? -le 1 ]; } && mv "$0/$1.tmp" "$0/$1"`
+
+const unpackContainerScript = `tar -xzpf "$0/$1" -C /`
+
+// volumeSnapshotForLab returns a VolumeSnapshot of the workspace of the student.
+func volumeSnapshotForLab(snapshot *kubelabv1.LabSnapshot, className string) *unstructured.Unstructured {
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(volumeSnapshotGVK)
+	vs.SetName(snapshot.Name)
+	vs.SetNamespace(snapshot.Spec.Student)
+	vs.SetLabels(labelsForClassroom(snapshot.Namespace, snapshot.Spec.Student))
+	labelOwner(vs, labelLabSnapshot, snapshot.Name)
+	vs.Object["spec"] = map[string]interface{}{
+		"volumeSnapshotClassName": className,
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": snapshot.Namespace + "-" + claimNameWork,
+		},
+	}
+	return vs
+}
+
+// snapshotPath returns the folder of a copied snapshot on the NFS share.
+func snapshotPath(snapshot *kubelabv1.LabSnapshot) string {
+	return "snapshots/" + snapshot.Namespace + "/" + snapshot.Spec.Student + "/" + snapshot.Name
+}
+
+// copyJobForSnapshot returns a job copying the workspace of the student onto the NFS share.
+func (r *LabSnapshotReconciler) copyJobForSnapshot(snapshot *kubelabv1.LabSnapshot) (*batchv1.Job, error) {
+	volumes := []v1.Volume{
+		{
+			Name: "work-data",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: snapshot.Namespace + "-" + claimNameWork,
+					ReadOnly:  true,
+				},
+			},
+		},
+		{
+			Name: "nfs",
+			VolumeSource: v1.VolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server: nfsServer,
+					Path:   nfsPath,
+				},
+			},
+		},
+	}
+	mounts := []v1.VolumeMount{
+		{
+			Name:      "work-data",
+			MountPath: "/work",
+			ReadOnly:  true,
+		},
+		{
+			Name:      "nfs",
+			MountPath: "/nfs",
+		},
+	}
+
+	job := labJob(snapshot.Name+"-snapshot", snapshot.Namespace, snapshot.Spec.Student, snapshotCopyScript, []v1.EnvVar{{Name: "SNAPSHOT_PATH", Value: snapshotPath(snapshot)}}, mounts, volumes)
+	labelOwner(job, labelLabSnapshot, snapshot.Name)
+	return job, nil
+}
+
+// sourceClaimForRestore returns a pvc provisioned from the VolumeSnapshot, which is copied into the workspace.
+func (r *LabRestoreReconciler) sourceClaimForRestore(restore *kubelabv1.LabRestore, snapshot *kubelabv1.LabSnapshot, workspace *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
+	apiGroup := volumeSnapshotGVK.Group
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      restore.Name + "-source",
+			Namespace: snapshot.Spec.Student,
+			Labels:    labelsForClassroom(snapshot.Namespace, snapshot.Spec.Student),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: workspace.Spec.StorageClassName,
+			AccessModes:      workspace.Spec.AccessModes,
+			Resources:        workspace.Spec.Resources,
+			DataSource: &v1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     volumeSnapshotGVK.Kind,
+				Name:     snapshot.Status.VolumeSnapshot,
+			},
+		},
+	}
+
+	labelOwner(claim, labelLabRestore, restore.Name)
+	return claim, nil
+}
+
+// jobForRestore returns a job replacing the workspace of the student with the content of the snapshot.
+func (r *LabRestoreReconciler) jobForRestore(restore *kubelabv1.LabRestore, snapshot *kubelabv1.LabSnapshot) (*batchv1.Job, error) {
+	source := v1.VolumeSource{
+		NFS: &v1.NFSVolumeSource{
+			Server:   nfsServer,
+			Path:     nfsPath + "/" + snapshotPath(snapshot),
+			ReadOnly: true,
+		},
+	}
+	if snapshot.Status.Method == snapshotMethodVolumeSnapshot {
+		source = v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: restore.Name + "-source",
+				ReadOnly:  true,
+			},
+		}
+	}
+	volumes := []v1.Volume{
+		{
+			Name: "work-data",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: snapshot.Namespace + "-" + claimNameWork,
+				},
+			},
+		},
+		{
+			Name:         "source",
+			VolumeSource: source,
+		},
+	}
+	mounts := []v1.VolumeMount{
+		{
+			Name:      "work-data",
+			MountPath: "/work",
+		},
+		{
+			Name:      "source",
+			MountPath: "/source",
+			ReadOnly:  true,
+		},
+	}
+
+	job := labJob(restore.Name+"-restore", snapshot.Namespace, snapshot.Spec.Student, restoreScript, nil, mounts, volumes)
+	labelOwner(job, labelLabRestore, restore.Name)
+	return job, nil
+}
+
+// labelOwner labels a resource inside the namespace of the student with the snapshot or restore it belongs to.
+// An owner reference can not point into the classroom namespace, so the resources are removed by the finalizer.
+func labelOwner(obj metav1.Object, label string, name string) {
+	labels := obj.GetLabels()
+	labels[label] = name
+	obj.SetLabels(labels)
+}
+
+// requestsForLabelOwner maps a resource inside the namespace of the student to the snapshot or restore it belongs to.
+func requestsForLabelOwner(label string) func(client.Object) []reconcile.Request {
+	return func(obj client.Object) []reconcile.Request {
+		name, ok := obj.GetLabels()[label]
+		if !ok {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetLabels()["class"]}}}
+	}
+}
+
+// labJob returns a job running the script inside the namespace of the student.
+func labJob(name string, classroom string, student string, script string, env []v1.EnvVar, mounts []v1.VolumeMount, volumes []v1.Volume) *batchv1.Job {
+	ls := labelsForClassroom(classroom, student)
+	// the labels of the lab would select the job pods as well
+	ls["app.kubernetes.io/name"] = "KubelabLabJob"
+	backoffLimit := int32(3)
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: student,
+			Labels:    ls,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Image:        jobImage,
+						Name:         "lab",
+						Command:      []string{"sh", "-c", script},
+						Env:          env,
+						VolumeMounts: mounts,
+					}},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+}
+
+// labPodSelector selects the pods of a lab, see labelsForClassroom, and a warm pod waiting for the lab, see podForWarmPool
+func labPodSelector(classroom string, student string) client.MatchingLabelsSelector {
+	names, _ := labels.NewRequirement("app.kubernetes.io/name", selection.In, []string{"KubelabClassroom", "KubelabWarmPool"})
+	return client.MatchingLabelsSelector{Selector: labels.SelectorFromSet(labels.Set{"class": classroom, "student": student}).Add(*names)}
+}
+
+// workspaceMountPath returns the path of the workspace inside the lab container.
+func workspaceMountPath(pod *v1.Pod) string {
+	for _, mount := range pod.Spec.Containers[0].VolumeMounts {
+		if mount.Name == "work-data" {
+			return mount.MountPath
+		}
+	}
+	return ""
+}
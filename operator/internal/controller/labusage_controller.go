@@ -0,0 +1,116 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+	"kubelab.local/kubelab/internal/usage"
+)
+
+// LabUsageReconciler records the time the labs are scaled up into a LabUsage per lab.
+// It reconciles the StatefulSets of the labs, which are named like the classroom inside the namespace of the user.
+type LabUsageReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labusages,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labusages/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubelab.kubelab.local,resources=labusages/finalizers,verbs=update
+
+//Custom RBAC
+//+kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch
+
+func (r *LabUsageReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// A deleted lab is scaled down
+	scaledUp := false
+	lab := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, req.NamespacedName, lab); err == nil {
+		scaledUp = lab.ObjectMeta.DeletionTimestamp.IsZero() && (lab.Spec.Replicas == nil || *lab.Spec.Replicas > 0)
+	} else if !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get StatefulSet")
+		return ctrl.Result{}, err
+	}
+
+	labUsage := &kubelabv1.LabUsage{}
+	if err := r.Get(ctx, client.ObjectKey{Name: labUsageName(req.Name, req.Namespace)}, labUsage); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to get LabUsage")
+			return ctrl.Result{}, err
+		}
+		if !scaledUp {
+			return ctrl.Result{}, nil
+		}
+		labUsage = labUsageForLab(req.Name, req.Namespace)
+		if err := r.Create(ctx, labUsage); err != nil {
+			log.Error(err, "Failed to create new LabUsage", "LabUsage.Name", labUsage.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Running sessions are added every hour, so the report lags behind at most an hour even if the operator is stopped
+	now := time.Now()
+	since := labUsage.Status.Since
+	switch {
+	case scaledUp && since == nil:
+		labUsage.Status.Since = &metav1.Time{Time: now}
+	case since != nil && (!scaledUp || now.Sub(since.Time) >= usageRollup):
+		usage.Add(&labUsage.Status, since.Time, now)
+		labUsage.Status.Since = nil
+		if scaledUp {
+			labUsage.Status.Since = &metav1.Time{Time: now}
+		}
+	case scaledUp:
+		return ctrl.Result{RequeueAfter: usageRollup - now.Sub(since.Time)}, nil
+	default:
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.Status().Update(ctx, labUsage); err != nil {
+		log.Error(err, "Failed to update lab usage status")
+		return ctrl.Result{}, err
+	}
+	if scaledUp {
+		return ctrl.Result{RequeueAfter: usageRollup}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LabUsageReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("labusage").
+		For(&appsv1.StatefulSet{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetLabels()["app.kubernetes.io/name"] == "KubelabClassroom"
+		}))).
+		Complete(r)
+}
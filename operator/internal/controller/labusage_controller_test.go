@@ -0,0 +1,95 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+	"kubelab.local/kubelab/internal/usage"
+)
+
+// The time a lab is scaled up is added to the usage once it is scaled down and reported per day.
+func TestLabUsageIsRecordedUntilScaleDown(t *testing.T) {
+	replicas := int32(1)
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "java", Namespace: "575103", Labels: labelsForClassroom("java", "575103")},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+	r := &LabUsageReconciler{
+		Client: newTestClient(statefulSet),
+		Scheme: testScheme,
+	}
+	ctx := context.Background()
+	reconcile := func() *kubelabv1.LabUsage {
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "java", Namespace: "575103"}}); err != nil {
+			t.Fatalf("reconcile failed: %v", err)
+		}
+		labUsage := &kubelabv1.LabUsage{}
+		if err := r.Get(ctx, client.ObjectKey{Name: labUsageName("java", "575103")}, labUsage); err != nil {
+			t.Fatal(err)
+		}
+		return labUsage
+	}
+
+	labUsage := reconcile()
+	if labUsage.Status.Since == nil {
+		t.Fatal("session of the scaled up lab was not started")
+	}
+
+	// the session started 30 minutes ago
+	labUsage.Status.Since = &metav1.Time{Time: time.Now().Add(-30 * time.Minute)}
+	if err := r.Status().Update(ctx, labUsage); err != nil {
+		t.Fatal(err)
+	}
+	replicas = 0
+	if err := r.Update(ctx, statefulSet); err != nil {
+		t.Fatal(err)
+	}
+
+	labUsage = reconcile()
+	if labUsage.Status.Since != nil {
+		t.Errorf("session of the scaled down lab was not ended")
+	}
+	if labUsage.Status.TotalSeconds < 1799 || labUsage.Status.TotalSeconds > 1801 {
+		t.Errorf("unexpected usage of %d seconds", labUsage.Status.TotalSeconds)
+	}
+
+	report := usage.Report([]kubelabv1.LabUsage{*labUsage}, usage.Filter{Classroom: "java", By: "classroom"}, time.Now())
+	if len(report) != 1 || report[0].Seconds != labUsage.Status.TotalSeconds {
+		t.Errorf("unexpected report %v", report)
+	}
+}
+
+// Sessions over midnight are split into both days.
+func TestLabUsageIsSplitAtMidnight(t *testing.T) {
+	status := &kubelabv1.LabUsageStatus{}
+	from := time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC)
+	usage.Add(status, from, from.Add(2*time.Hour))
+
+	if len(status.Days) != 2 || status.Days[0].Seconds != 3600 || status.Days[1].Date != "2026-10-20" || status.TotalSeconds != 7200 {
+		t.Errorf("session was not split at midnight: %+v", status)
+	}
+}
@@ -0,0 +1,26 @@
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// labUsageName returns the name of the usage of a lab, the dot can not be part of a classroom or user id
+func labUsageName(classroom string, student string) string {
+	return classroom + "." + student
+}
+
+// labUsageForLab returns an empty usage of the lab of the student.
+// It is not owned by the classroom, so the usage can still be reported once the classroom is deleted.
+func labUsageForLab(classroom string, student string) *kubelabv1.LabUsage {
+	return &kubelabv1.LabUsage{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   labUsageName(classroom, student),
+			Labels: map[string]string{"class": classroom, "student": student},
+		},
+		Spec: kubelabv1.LabUsageSpec{
+			Classroom: classroom,
+			Student:   student,
+		},
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// RetainedVolumeReconciler releases the volumes of deleted users once their retention is over
+type RetainedVolumeReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;update;patch
+
+func (r *RetainedVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	pv := &v1.PersistentVolume{}
+	if err := r.Get(ctx, req.NamespacedName, pv); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get PersistentVolume")
+		return ctrl.Result{}, err
+	}
+
+	// the label is removed once the volume is re-attached to a new user
+	if pv.Labels[retainedUserLabel] == "" {
+		return ctrl.Result{}, nil
+	}
+
+	// volumes with an unreadable date are kept, deleting data by mistake can not be undone
+	until, err := time.Parse(time.RFC3339, pv.Annotations[retainUntilAnnotation])
+	if err != nil {
+		log.Error(err, "Invalid retention of PersistentVolume", "PersistentVolume.Name", pv.Name)
+		return ctrl.Result{}, nil
+	}
+	if remaining := time.Until(until); remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	// The provisioner removes released volumes with the reclaim policy Delete, see archiveOnDelete of the storage class
+	log.Info("Releasing retained volume", "PersistentVolume.Name", pv.Name, "User", pv.Labels[retainedUserLabel])
+	pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimDelete
+	delete(pv.Labels, retainedUserLabel)
+	delete(pv.Annotations, retainUntilAnnotation)
+	if err := r.Update(ctx, pv); err != nil {
+		log.Error(err, "Failed to release retained PersistentVolume", "PersistentVolume.Name", pv.Name)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RetainedVolumeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("retainedvolume").
+		For(&v1.PersistentVolume{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetLabels()[retainedUserLabel] != ""
+		}))).
+		Complete(r)
+}
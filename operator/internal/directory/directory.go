@@ -0,0 +1,39 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package directory reads the members of groups from an identity provider.
+package directory
+
+import "context"
+
+// User is a member of a group inside the directory
+type User struct {
+	// Id used for the KubelabUser, normally the StudentID
+	Id          string
+	DisplayName string
+	Email       string
+	// Id of the user inside the directory
+	Subject string
+	Enabled bool
+}
+
+// Directory returns the members of the groups of an identity provider
+type Directory interface {
+	// Provider returns the name of the identity provider
+	Provider() string
+	// Members returns the members of the group
+	Members(ctx context.Context, group string) ([]User, error)
+}
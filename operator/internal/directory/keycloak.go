@@ -0,0 +1,189 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keycloakPageSize is the number of members requested at once
+const keycloakPageSize = 100
+
+// keycloakTimeout is the timeout of every request, if no client is set
+const keycloakTimeout = 30 * time.Second
+
+var keycloakClient = &http.Client{Timeout: keycloakTimeout}
+
+// Keycloak reads the groups of a realm through the admin API, the client needs the roles view-users and query-groups
+type Keycloak struct {
+	URL          string
+	Realm        string
+	ClientID     string
+	ClientSecret string
+	// Attribute of the user used as id, the username if empty
+	IdAttribute string
+	// Client of the requests, a client with keycloakTimeout if nil
+	Client *http.Client
+
+	token string
+}
+
+type keycloakGroup struct {
+	Id        string          `json:"id"`
+	Name      string          `json:"name"`
+	Path      string          `json:"path"`
+	SubGroups []keycloakGroup `json:"subGroups"`
+}
+
+type keycloakUser struct {
+	Id         string              `json:"id"`
+	Username   string              `json:"username"`
+	Email      string              `json:"email"`
+	FirstName  string              `json:"firstName"`
+	LastName   string              `json:"lastName"`
+	Enabled    bool                `json:"enabled"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+func (k *Keycloak) Provider() string {
+	return "keycloak"
+}
+
+// Members returns the members of the group, which is found by its name or path
+func (k *Keycloak) Members(ctx context.Context, group string) ([]User, error) {
+	if k.token == "" {
+		if err := k.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	groups := []keycloakGroup{}
+	if err := k.get(ctx, "/groups", url.Values{"search": {strings.TrimPrefix(group, "/")}}, &groups); err != nil {
+		return nil, err
+	}
+	found := findGroup(groups, group)
+	if found == nil {
+		return nil, fmt.Errorf("group does not exist: %s", group)
+	}
+
+	users := []User{}
+	for first := 0; ; first += keycloakPageSize {
+		members := []keycloakUser{}
+		query := url.Values{"first": {strconv.Itoa(first)}, "max": {strconv.Itoa(keycloakPageSize)}}
+		if err := k.get(ctx, "/groups/"+found.Id+"/members", query, &members); err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			users = append(users, k.userFor(member))
+		}
+		if len(members) < keycloakPageSize {
+			return users, nil
+		}
+	}
+}
+
+func (k *Keycloak) userFor(member keycloakUser) User {
+	id := member.Username
+	if k.IdAttribute != "" && len(member.Attributes[k.IdAttribute]) > 0 {
+		id = member.Attributes[k.IdAttribute][0]
+	}
+	return User{
+		Id:          id,
+		DisplayName: strings.TrimSpace(member.FirstName + " " + member.LastName),
+		Email:       member.Email,
+		Subject:     member.Id,
+		Enabled:     member.Enabled,
+	}
+}
+
+// findGroup searches the group and its subgroups, since the search of Keycloak returns the parents of a match
+func findGroup(groups []keycloakGroup, name string) *keycloakGroup {
+	for i, group := range groups {
+		if group.Name == name || group.Path == name {
+			return &groups[i]
+		}
+		if found := findGroup(group.SubGroups, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// login requests a token with the client credentials of the service account
+func (k *Keycloak) login(ctx context.Context) error {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {k.ClientID},
+		"client_secret": {k.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(k.URL, "/")+"/realms/"+k.Realm+"/protocol/openid-connect/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := k.do(req, &token); err != nil {
+		return err
+	}
+	if token.AccessToken == "" {
+		return errors.New("keycloak did not return an access token")
+	}
+	k.token = token.AccessToken
+	return nil
+}
+
+// get requests a path of the admin API of the realm
+func (k *Keycloak) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(k.URL, "/")+"/admin/realms/"+k.Realm+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	return k.do(req, out)
+}
+
+func (k *Keycloak) do(req *http.Request, out interface{}) error {
+	client := k.Client
+	if client == nil {
+		client = keycloakClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		// the token expired, it is requested again with the next sync
+		k.token = ""
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("keycloak returned %s for %s", res.Status, req.URL.Path)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
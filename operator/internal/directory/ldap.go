@@ -0,0 +1,117 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapTimeout is used if the context has no deadline
+const ldapTimeout = 30 * time.Second
+
+// LDAP reads groups with a list of member DNs, e.g. groupOfNames, from an LDAP server
+type LDAP struct {
+	// URL of the server, e.g. ldaps://ldap.example.com:636
+	URL string
+	// An anonymous bind is used if empty
+	BindDN   string
+	Password string
+	// Base DN below which the groups are searched by their cn
+	GroupBaseDN string
+	// Attribute of the group containing the DN of its members, member if empty
+	MemberAttribute string
+	// Attribute of the user used as id, uid if empty
+	IdAttribute string
+}
+
+func (l *LDAP) Provider() string {
+	return "ldap"
+}
+
+// Members returns the members of the group, which is found by its cn below the group base DN
+func (l *LDAP) Members(ctx context.Context, group string) ([]User, error) {
+	memberAttribute := valueOrDefault(l.MemberAttribute, "member")
+	idAttribute := valueOrDefault(l.IdAttribute, "uid")
+
+	timeout := ldapTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	conn, err := ldap.DialURL(l.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(timeout)
+
+	if l.BindDN != "" {
+		if err := conn.Bind(l.BindDN, l.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := conn.Search(ldap.NewSearchRequest(
+		l.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false,
+		fmt.Sprintf("(cn=%s)", ldap.EscapeFilter(group)), []string{memberAttribute}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	if len(groups.Entries) == 0 {
+		return nil, fmt.Errorf("group does not exist: %s", group)
+	}
+
+	users := []User{}
+	for _, dn := range groups.Entries[0].GetAttributeValues(memberAttribute) {
+		result, err := conn.Search(ldap.NewSearchRequest(
+			dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+			"(objectClass=*)", []string{idAttribute, "cn", "displayName", "mail"}, nil,
+		))
+		// members might be deleted users
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// members might be groups without an id
+		if len(result.Entries) == 0 || result.Entries[0].GetAttributeValue(idAttribute) == "" {
+			continue
+		}
+		entry := result.Entries[0]
+		users = append(users, User{
+			Id:          entry.GetAttributeValue(idAttribute),
+			DisplayName: valueOrDefault(entry.GetAttributeValue("displayName"), entry.GetAttributeValue("cn")),
+			Email:       entry.GetAttributeValue("mail"),
+			Subject:     entry.DN,
+			Enabled:     true,
+		})
+	}
+	return users, nil
+}
+
+func valueOrDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
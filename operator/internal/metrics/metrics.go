@@ -0,0 +1,184 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exposes the state of the classrooms, labs and users to Prometheus.
+package metrics
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+var (
+	// ReconcileFailures counts the failed actions and invalid specs of the controllers
+	ReconcileFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubelab_reconcile_failures_total",
+		Help: "Failed actions and invalid specs of the controllers by reason",
+	}, []string{"controller", "reason"})
+
+	// LabsStopped counts the labs stopped because a running-lab limit was exceeded
+	LabsStopped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubelab_labs_stopped_total",
+		Help: "Labs stopped because a running-lab limit was exceeded",
+	}, []string{"classroom"})
+
+	// LabReadySeconds observes the time from scaling up a lab until its container is ready
+	LabReadySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubelab_lab_ready_seconds",
+		Help:    "Time from scaling up a lab until its container is ready",
+		Buckets: []float64{5, 10, 20, 30, 60, 120, 300, 600},
+	}, []string{"classroom", "pool"})
+)
+
+var (
+	labsDesc     = prometheus.NewDesc("kubelab_classroom_labs", "Labs of the classroom, including the labs of the staff", []string{"classroom"}, nil)
+	runningDesc  = prometheus.NewDesc("kubelab_classroom_labs_running", "Labs of the classroom with a ready container", []string{"classroom"}, nil)
+	cpuDesc      = prometheus.NewDesc("kubelab_classroom_cpu_requests_cores", "CPU reserved by the scaled up labs of the classroom", []string{"classroom"}, nil)
+	memoryDesc   = prometheus.NewDesc("kubelab_classroom_memory_requests_bytes", "Memory reserved by the scaled up labs of the classroom", []string{"classroom"}, nil)
+	examModeDesc = prometheus.NewDesc("kubelab_classroom_exam_mode", "1 if the exam mode of the classroom is active", []string{"classroom"}, nil)
+	usersDesc    = prometheus.NewDesc("kubelab_users", "Users with the role, a user with several roles is counted for each", []string{"role"}, nil)
+)
+
+// processStarted is the start of the operator, the time to ready of labs started before is unknown
+var processStarted = time.Now()
+
+// labReadiness remembers the scale-up of every lab, whose time to ready was recorded
+var labReadiness = struct {
+	sync.Mutex
+	observed map[types.NamespacedName]time.Time
+}{observed: map[types.NamespacedName]time.Time{}}
+
+// ObserveLab records the time to ready of the lab, once its container is ready after a scale-up.
+// started is the time the lab was scaled up, zero if it is not known yet, so a lab which was already ready
+// when it was seen first, e.g. an adopted warm pod, is recorded as well.
+// It has to be called whenever the StatefulSet of the lab changed, pool is warm if the classroom has a warm pool and cold otherwise.
+// The time to ready is returned once it was recorded.
+func ObserveLab(lab *appsv1.StatefulSet, pool string, started time.Time) (time.Duration, bool) {
+	key := types.NamespacedName{Name: lab.Name, Namespace: lab.Namespace}
+	wanted := lab.Spec.Replicas == nil || *lab.Spec.Replicas > 0
+
+	labReadiness.Lock()
+	defer labReadiness.Unlock()
+	switch {
+	case !wanted:
+		delete(labReadiness.observed, key)
+	case lab.Status.ReadyReplicas == 0 || started.IsZero() || started.Before(processStarted) || labReadiness.observed[key].Equal(started):
+	default:
+		ready := time.Since(started)
+		LabReadySeconds.WithLabelValues(lab.Labels["class"], pool).Observe(ready.Seconds())
+		labReadiness.observed[key] = started
+		return ready, true
+	}
+	return 0, false
+}
+
+// Collector reads the classrooms, labs and users from the cache of the manager on every scrape
+type Collector struct {
+	client client.Reader
+}
+
+// NewCollector returns a collector reading from the client, which should be backed by the cache
+func NewCollector(c client.Reader) *Collector {
+	return &Collector{client: c}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{labsDesc, runningDesc, cpuDesc, memoryDesc, examModeDesc, usersDesc} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	classroomList := &kubelabv1.ClassroomList{}
+	if err := c.client.List(ctx, classroomList); err != nil {
+		ch <- prometheus.NewInvalidMetric(labsDesc, err)
+		return
+	}
+	labList := &appsv1.StatefulSetList{}
+	if err := c.client.List(ctx, labList, client.MatchingLabels{"app.kubernetes.io/name": "KubelabClassroom"}); err != nil {
+		ch <- prometheus.NewInvalidMetric(labsDesc, err)
+		return
+	}
+
+	labs, running := map[string]float64{}, map[string]float64{}
+	cpu, memory := map[string]float64{}, map[string]float64{}
+	for _, classroom := range classroomList.Items {
+		labs[classroom.Name] = 0
+		examMode := 0.0
+		if strings.ToLower(classroom.Spec.EnableExamMode) == "true" {
+			examMode = 1
+		}
+		ch <- prometheus.MustNewConstMetric(examModeDesc, prometheus.GaugeValue, examMode, classroom.Name)
+	}
+	for _, lab := range labList.Items {
+		class := lab.Labels["class"]
+		labs[class]++
+		if lab.Status.ReadyReplicas > 0 {
+			running[class]++
+		}
+		replicas := int32(1)
+		if lab.Spec.Replicas != nil {
+			replicas = *lab.Spec.Replicas
+		}
+		for _, container := range lab.Spec.Template.Spec.Containers {
+			cpu[class] += float64(replicas) * container.Resources.Requests.Cpu().AsApproximateFloat64()
+			memory[class] += float64(replicas) * container.Resources.Requests.Memory().AsApproximateFloat64()
+		}
+	}
+	for class, count := range labs {
+		ch <- prometheus.MustNewConstMetric(labsDesc, prometheus.GaugeValue, count, class)
+		ch <- prometheus.MustNewConstMetric(runningDesc, prometheus.GaugeValue, running[class], class)
+		ch <- prometheus.MustNewConstMetric(cpuDesc, prometheus.GaugeValue, cpu[class], class)
+		ch <- prometheus.MustNewConstMetric(memoryDesc, prometheus.GaugeValue, memory[class], class)
+	}
+
+	userList := &kubelabv1.KubelabUserList{}
+	if err := c.client.List(ctx, userList); err != nil {
+		ch <- prometheus.NewInvalidMetric(usersDesc, err)
+		return
+	}
+	users := map[string]float64{}
+	for _, user := range userList.Items {
+		roles := user.Status.Roles
+		if len(roles) == 0 {
+			roles = user.Spec.Roles
+		}
+		for _, role := range roles {
+			users[string(role)]++
+		}
+	}
+	for role, count := range users {
+		ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, count, role)
+	}
+}
+
+// Register registers the collector and the metrics of the controllers with the registry of the manager
+func Register(registry prometheus.Registerer, c client.Reader) {
+	registry.MustRegister(NewCollector(c), ReconcileFailures, LabsStopped, LabReadySeconds)
+}
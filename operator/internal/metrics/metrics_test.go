@@ -0,0 +1,148 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+// The time to ready of a lab is observed once per scale-up.
+func TestObserveLab(t *testing.T) {
+	replicas := int32(1)
+	lab := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "java", Namespace: "575103", Labels: map[string]string{"class": "java"}},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+	LabReadySeconds.Reset()
+	started := time.Now()
+
+	if _, ok := ObserveLab(lab, "cold", started); ok {
+		t.Errorf("lab which is not ready was observed")
+	}
+	lab.Status.ReadyReplicas = 1
+	if _, ok := ObserveLab(lab, "cold", started); !ok {
+		t.Errorf("lab which got ready was not observed")
+	}
+	if _, ok := ObserveLab(lab, "cold", started); ok {
+		t.Errorf("lab was observed twice")
+	}
+	if count := testutil.CollectAndCount(LabReadySeconds, "kubelab_lab_ready_seconds"); count != 1 {
+		t.Errorf("expected a histogram for the classroom, got %d", count)
+	}
+
+	// a lab ready when it is seen first, e.g. an adopted warm pod, is observed from its scale-up
+	adopted := lab.DeepCopy()
+	adopted.Namespace = "575104"
+	if ready, ok := ObserveLab(adopted, "warm", started); !ok || ready <= 0 {
+		t.Errorf("lab which was ready when seen first was not observed: %s", ready)
+	}
+
+	// a lab scaled down before it got ready is not observed
+	lab.Status.ReadyReplicas = 0
+	stopped := int32(0)
+	lab.Spec.Replicas = &stopped
+	ObserveLab(lab, "cold", time.Time{})
+	lab.Spec.Replicas = &replicas
+	if _, ok := ObserveLab(lab, "cold", time.Time{}); ok {
+		t.Errorf("lab without a known scale-up was observed")
+	}
+
+	// labs started before the operator are not observed, the time they got ready is unknown
+	lab.Status.ReadyReplicas = 1
+	if _, ok := ObserveLab(lab, "cold", processStarted.Add(-time.Hour)); ok {
+		t.Errorf("lab started before the operator was observed")
+	}
+}
+
+// The collector reports the labs, requests and exam mode per classroom and the users per role.
+func TestCollector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(kubelabv1.AddToScheme(scheme))
+
+	lab := func(namespace string, replicas int32, ready int32) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "java", Namespace: namespace,
+				Labels: map[string]string{"app.kubernetes.io/name": "KubelabClassroom", "class": "java"},
+			},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: &replicas,
+				Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{
+					Name: "lab",
+					Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+						v1.ResourceCPU:    resource.MustParse("500m"),
+						v1.ResourceMemory: resource.MustParse("1Gi"),
+					}},
+				}}}},
+			},
+			Status: appsv1.StatefulSetStatus{ReadyReplicas: ready},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "java"}, Spec: kubelabv1.ClassroomSpec{EnableExamMode: "true"}},
+		&kubelabv1.Classroom{ObjectMeta: metav1.ObjectMeta{Name: "python"}},
+		lab("575103", 1, 1), lab("575104", 0, 0),
+		&kubelabv1.KubelabUser{ObjectMeta: metav1.ObjectMeta{Name: "575103"}, Status: kubelabv1.KubelabUserStatus{Roles: []kubelabv1.UserRole{"student"}}},
+		&kubelabv1.KubelabUser{ObjectMeta: metav1.ObjectMeta{Name: "t01"}, Spec: kubelabv1.KubelabUserSpec{Roles: []kubelabv1.UserRole{"teacher", "assistant"}}},
+	).Build()
+
+	expected := `
+# HELP kubelab_classroom_cpu_requests_cores CPU reserved by the scaled up labs of the classroom
+# TYPE kubelab_classroom_cpu_requests_cores gauge
+kubelab_classroom_cpu_requests_cores{classroom="java"} 0.5
+kubelab_classroom_cpu_requests_cores{classroom="python"} 0
+# HELP kubelab_classroom_exam_mode 1 if the exam mode of the classroom is active
+# TYPE kubelab_classroom_exam_mode gauge
+kubelab_classroom_exam_mode{classroom="java"} 1
+kubelab_classroom_exam_mode{classroom="python"} 0
+# HELP kubelab_classroom_labs Labs of the classroom, including the labs of the staff
+# TYPE kubelab_classroom_labs gauge
+kubelab_classroom_labs{classroom="java"} 2
+kubelab_classroom_labs{classroom="python"} 0
+# HELP kubelab_classroom_labs_running Labs of the classroom with a ready container
+# TYPE kubelab_classroom_labs_running gauge
+kubelab_classroom_labs_running{classroom="java"} 1
+kubelab_classroom_labs_running{classroom="python"} 0
+# HELP kubelab_classroom_memory_requests_bytes Memory reserved by the scaled up labs of the classroom
+# TYPE kubelab_classroom_memory_requests_bytes gauge
+kubelab_classroom_memory_requests_bytes{classroom="java"} 1.073741824e+09
+kubelab_classroom_memory_requests_bytes{classroom="python"} 0
+# HELP kubelab_users Users with the role, a user with several roles is counted for each
+# TYPE kubelab_users gauge
+kubelab_users{role="assistant"} 1
+kubelab_users{role="student"} 1
+kubelab_users{role="teacher"} 1
+`
+	if err := testutil.CollectAndCompare(NewCollector(c), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package usage adds up the lab hours recorded in the LabUsages and reports them.
+package usage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubelabv1 "kubelab.local/kubelab/api/v1"
+)
+
+const dateFormat = "2006-01-02"
+
+// Add adds the time between from and to to the days of the usage, split at midnight UTC
+func Add(status *kubelabv1.LabUsageStatus, from time.Time, to time.Time) {
+	from, to = from.UTC(), to.UTC()
+	for from.Before(to) {
+		end := time.Date(from.Year(), from.Month(), from.Day()+1, 0, 0, 0, 0, time.UTC)
+		if end.After(to) {
+			end = to
+		}
+		date := from.Format(dateFormat)
+		seconds := int64(end.Sub(from).Seconds())
+
+		found := false
+		for i := range status.Days {
+			if status.Days[i].Date == date {
+				status.Days[i].Seconds += seconds
+				found = true
+				break
+			}
+		}
+		if !found {
+			status.Days = append(status.Days, kubelabv1.LabUsageDay{Date: date, Seconds: seconds})
+		}
+		status.TotalSeconds += seconds
+		from = end
+	}
+}
+
+// Filter selects and groups the usages of a report
+type Filter struct {
+	// Only the usage of this classroom or student, all if empty
+	Classroom string
+	Student   string
+	// First and last day of the report, formatted as 2006-01-02 and included
+	From string
+	To   string
+	// classroom, student or day
+	By string
+}
+
+// Row is the usage of a classroom, of a student in a classroom or of a student on a day
+type Row struct {
+	Classroom string  `json:"classroom"`
+	Student   string  `json:"student,omitempty"`
+	Date      string  `json:"date,omitempty"`
+	Seconds   int64   `json:"seconds"`
+	Hours     float64 `json:"hours"`
+}
+
+// Report adds up the usages selected by the filter, the current sessions are counted until now
+func Report(usages []kubelabv1.LabUsage, filter Filter, now time.Time) []Row {
+	rows := map[Row]int64{}
+	for _, usage := range usages {
+		if (filter.Classroom != "" && usage.Spec.Classroom != filter.Classroom) || (filter.Student != "" && usage.Spec.Student != filter.Student) {
+			continue
+		}
+		status := usage.Status.DeepCopy()
+		if status.Since != nil {
+			Add(status, status.Since.Time, now)
+		}
+		for _, day := range status.Days {
+			if (filter.From != "" && day.Date < filter.From) || (filter.To != "" && day.Date > filter.To) {
+				continue
+			}
+			key := Row{Classroom: usage.Spec.Classroom}
+			switch filter.By {
+			case "classroom":
+			case "day":
+				key.Student, key.Date = usage.Spec.Student, day.Date
+			default:
+				key.Student = usage.Spec.Student
+			}
+			rows[key] += day.Seconds
+		}
+	}
+
+	report := []Row{}
+	for row, seconds := range rows {
+		row.Seconds = seconds
+		row.Hours = float64(seconds) / 3600
+		report = append(report, row)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.Classroom != b.Classroom {
+			return a.Classroom < b.Classroom
+		}
+		if a.Student != b.Student {
+			return a.Student < b.Student
+		}
+		return a.Date < b.Date
+	})
+	return report
+}
+
+// Handler serves the report as JSON, or as CSV with format=csv.
+// The query parameters classroom, student, from, to and by are passed as Filter.
+func Handler(c client.Reader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		filter := Filter{
+			Classroom: query.Get("classroom"),
+			Student:   query.Get("student"),
+			From:      query.Get("from"),
+			To:        query.Get("to"),
+			By:        query.Get("by"),
+		}
+		for _, date := range []string{filter.From, filter.To} {
+			if _, err := time.Parse(dateFormat, date); date != "" && err != nil {
+				http.Error(w, fmt.Sprintf("invalid date %q, expected YYYY-MM-DD", date), http.StatusBadRequest)
+				return
+			}
+		}
+		switch filter.By {
+		case "", "classroom", "student", "day":
+		default:
+			http.Error(w, fmt.Sprintf("invalid grouping %q, expected classroom, student or day", filter.By), http.StatusBadRequest)
+			return
+		}
+
+		usageList := &kubelabv1.LabUsageList{}
+		if err := c.List(context.Background(), usageList); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		report := Report(usageList.Items, filter, time.Now())
+
+		if strings.ToLower(query.Get("format")) != "csv" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(report)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="lab-usage.csv"`)
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"classroom", "student", "date", "hours"})
+		for _, row := range report {
+			writer.Write([]string{row.Classroom, row.Student, row.Date, fmt.Sprintf("%.2f", row.Hours)})
+		}
+		writer.Flush()
+	})
+}
@@ -1,7 +1,7 @@
 import { SvelteKitAuth } from "@auth/sveltekit";
 import Keycloak from "@auth/core/providers/keycloak";
 import { env } from '$env/dynamic/private';
-import { decode } from '$lib/helpers.js';
+import { decode, getUserInfo } from '$lib/helpers.js';
 
 // needed since env variables are a string
 if (env.ALLOW_UNTRUSTED_CERTS === "true" || env.ALLOW_UNTRUSTED_CERTS === "TRUE") {
@@ -20,13 +20,16 @@ export const handle = SvelteKitAuth({
     async jwt({ token, user, account, profile, isNewUser }) {
       if (account) {
         const token = decode(account.access_token)
+        // roles of the KubelabUser are added to the groups of the identity provider
+        const userInfo = await getUserInfo(account.id_token, token.user_id, env.KUBERNETES_SERVER_URL, env.KUBERNETES_CA_Path);
+        const roles = (userInfo.roles || '').split(',').filter((role) => role);
         let newToken = {
-          "name": token.name,
-          "email": token.email,
+          "name": userInfo.displayName || token.name,
+          "email": userInfo.email || token.email,
           "given_name": token.given_name,
           "family_name": token.family_name,
           "id_token": account.id_token,
-          "roles": token.groups, // This is possible thanks to the mapping done before for Kubernetes to identify the roles
+          "roles": [...new Set([...(token.groups || []), ...roles])], // This is possible thanks to the mapping done before for Kubernetes to identify the roles
           "user_id": token.user_id,
           "username": token.preferred_username
         }
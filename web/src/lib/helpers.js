@@ -34,3 +34,47 @@ export const getKubeConfig = (idToken, server, caFile) => {
 
     return kc;
 }
+
+// The operator publishes the roles and profile of the KubelabUser inside the namespace of the user
+export const getUserInfo = async (idToken, userId, server, caFile) => {
+    const kc = getKubeConfig(idToken, server, caFile);
+    const coreApi = kc.makeApiClient(k8s.CoreV1Api);
+    try {
+        const res = await coreApi.readNamespacedConfigMap('kubelab-user', userId);
+        return res.body.data || {};
+    } catch (err) {
+        return {};
+    }
+}
+
+// Labs run as StatefulSets, labs started before the upgrade keep running as Deployments until they are stopped
+export const listLabs = async (appsApi, namespace, labelSelector) => {
+    const labs = await appsApi.listNamespacedStatefulSet(namespace, undefined, undefined, undefined, undefined, labelSelector);
+    const legacy = await appsApi.listNamespacedDeployment(namespace, undefined, undefined, undefined, undefined, labelSelector);
+    const names = labs.body.items.map((lab) => lab.metadata.name);
+    labs.body.items = labs.body.items.concat(legacy.body.items.filter((lab) => !names.includes(lab.metadata.name)));
+    return labs;
+}
+
+export const readLab = async (appsApi, name, namespace) => {
+    try {
+        const lab = await appsApi.readNamespacedStatefulSet(name, namespace);
+        lab.body.kind = 'StatefulSet';
+        return lab;
+    } catch (err) {
+        if (err.statusCode !== 404) {
+            throw err;
+        }
+        const lab = await appsApi.readNamespacedDeployment(name, namespace);
+        lab.body.kind = 'Deployment';
+        return lab;
+    }
+}
+
+// Labs are started and stopped through the scale subresource, which assistants may change without changing the lab itself
+export const scaleLab = (appsApi, lab, replicas) => {
+    const scale = { metadata: { name: lab.metadata.name, namespace: lab.metadata.namespace }, spec: { replicas: replicas } };
+    return lab.kind === 'Deployment'
+        ? appsApi.replaceNamespacedDeploymentScale(lab.metadata.name, lab.metadata.namespace, scale)
+        : appsApi.replaceNamespacedStatefulSetScale(lab.metadata.name, lab.metadata.namespace, scale);
+}
@@ -35,3 +35,7 @@ export async function getFiles(token, name) {
 export async function deleteFiles(token, data, name) {
     return deleteReq(token, data, '/api/kubelab/classes/files/delete/' + name);
 }
+
+export async function joinClass(token, data, className) {
+    return post(token, data, '/api/kubelab/classes/join/' + className);
+}
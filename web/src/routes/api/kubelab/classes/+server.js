@@ -1,7 +1,7 @@
 import * as k8s from '@kubernetes/client-node';
 import { env } from '$env/dynamic/private';
 import { json } from '@sveltejs/kit';
-import { decode, getKubeConfig } from '$lib/helpers.js';
+import { decode, getKubeConfig, listLabs } from '$lib/helpers.js';
 
 
 export async function GET({ request }) {
@@ -17,9 +17,14 @@ export async function GET({ request }) {
     if (id_token) {
         let kc = getKubeConfig(id_token, env.KUBERNETES_SERVER_URL, env.KUBERNETES_CA_Path);
         let k8sApi = kc.makeApiClient(k8s.CustomObjectsApi);
-        await k8sApi.listClusterCustomObject('kubelab.kubelab.local', 'v1', 'classrooms', null, null, null, null, `teacher=${user_id}`)
+        let appsApi = kc.makeApiClient(k8s.AppsV1Api);
+        // teachers may only read their own classrooms, which are found through the teacher labs in their namespace
+        await listLabs(appsApi, user_id, 'class')
+            .then((res) => Promise.all(res.body.items.map((deploy) =>
+                k8sApi.getClusterCustomObject('kubelab.kubelab.local', 'v1', 'classrooms', deploy.metadata.labels['class'])
+            )))
             .then((res) => {
-                response = json(res.body.items, { status: 200, statusText: 'Success' });
+                response = json(res.map((classroom) => classroom.body), { status: 200, statusText: 'Success' });
             })
             .catch((err) => {
                 response = json({ message: err.body.message }, { status: err.statusCode, statusText: err.body.message });
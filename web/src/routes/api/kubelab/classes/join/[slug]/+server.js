@@ -0,0 +1,37 @@
+import * as k8s from '@kubernetes/client-node';
+import { env } from '$env/dynamic/private';
+import { json } from '@sveltejs/kit';
+import { decode, getKubeConfig } from '$lib/helpers.js';
+
+export async function POST({ request, params }) {
+    let id_token = request.headers.get('Authorization');
+    let className = params.slug;
+    let body = JSON.parse(await request.text());
+    let user_id = '';
+    try {
+        user_id = decode(id_token).user_id;
+    } catch (err) {
+        return json({ message: 'Invalid token' }, { status: 401, statusText: 'Invalid token' });
+    }
+
+    let response = json({ message: 'Internal server error' }, { status: 500, statusText: 'Internal Server Error' });
+    if (id_token) {
+        let kc = getKubeConfig(id_token, env.KUBERNETES_SERVER_URL, env.KUBERNETES_CA_Path);
+        let customApi = kc.makeApiClient(k8s.CustomObjectsApi);
+        // the operator validates the join code and enrolls the owner of the namespace, students may only create requests in their own namespace
+        let enrollmentRequest = {
+            apiVersion: 'kubelab.kubelab.local/v1',
+            kind: 'EnrollmentRequest',
+            metadata: { generateName: className + '-' },
+            spec: { classroom: className, joinCode: body.joinCode },
+        };
+        await customApi.createNamespacedCustomObject('kubelab.kubelab.local', 'v1', user_id, 'enrollmentrequests', enrollmentRequest)
+            .then((res) => {
+                response = json({ name: res.body.metadata.name }, { status: 201, statusText: 'Created' });
+            })
+            .catch((err) => {
+                response = json({ message: err.body.message }, { status: err.statusCode, statusText: err.body.message });
+            });
+    }
+    return response;
+}
@@ -1,7 +1,7 @@
 import * as k8s from '@kubernetes/client-node';
 import { env } from '$env/dynamic/private';
 import { json } from '@sveltejs/kit';
-import { decode, getKubeConfig } from '$lib/helpers.js';
+import { decode, getKubeConfig, readLab } from '$lib/helpers.js';
 
 export async function GET({ request, params }) {
     let id_token = request.headers.get('Authorization');
@@ -17,9 +17,15 @@ export async function GET({ request, params }) {
     if (id_token) {
         let kc = getKubeConfig(id_token, env.KUBERNETES_SERVER_URL, env.KUBERNETES_CA_Path);
         let k8sApi = kc.makeApiClient(k8s.AppsV1Api);
-        await k8sApi.listDeploymentForAllNamespaces(undefined, undefined, undefined, `class=` + className)
+        let customApi = kc.makeApiClient(k8s.CustomObjectsApi);
+        // teachers are only allowed to read the labs of their own students
+        await customApi.getClusterCustomObject('kubelab.kubelab.local', 'v1', 'classrooms', className)
+            // the status also lists the students enrolled through the student selector
+            .then((res) => Promise.all((res.body.status?.students || (res.body.spec.enrolledStudents || []).map((student) => student.spec.id)).map((id) =>
+                readLab(k8sApi, className, id)
+            )))
             .then((res) => {
-                response = json(res.body, { status: 200, statusText: 'Success' });
+                response = json({ items: res.map((deploy) => deploy.body) }, { status: 200, statusText: 'Success' });
             }).catch((err) => {
                 response = json({ message: err.body.message }, { status: err.statusCode, statusText: err.body.message });
             });
@@ -1,7 +1,7 @@
 import * as k8s from '@kubernetes/client-node';
 import { env } from '$env/dynamic/private';
 import { json } from '@sveltejs/kit';
-import { decode, getKubeConfig } from '$lib/helpers.js';
+import { decode, getKubeConfig, listLabs } from '$lib/helpers.js';
 
 
 export async function GET({ request }) {
@@ -17,8 +17,7 @@ export async function GET({ request }) {
     if (id_token) {
         let kc = getKubeConfig(id_token, env.KUBERNETES_SERVER_URL, env.KUBERNETES_CA_Path);
         let k8sApi = kc.makeApiClient(k8s.AppsV1Api);
-        await k8sApi
-            .listNamespacedDeployment(user_id)
+        await listLabs(k8sApi, user_id)
             .then((res) => {
                 response = json(res.body, { status: 200, statusText: 'Success' });
             })
@@ -1,7 +1,7 @@
 import * as k8s from '@kubernetes/client-node';
 import { env } from '$env/dynamic/private';
 import { json } from '@sveltejs/kit';
-import { decode, getKubeConfig } from '$lib/helpers.js';
+import { decode, getKubeConfig, listLabs, readLab, scaleLab } from '$lib/helpers.js';
 
 export async function PUT({ request, params }) {
     let id_token = request.headers.get('Authorization');
@@ -17,12 +17,11 @@ export async function PUT({ request, params }) {
         let k8sApi = kc.makeApiClient(k8s.AppsV1Api);
 
         if (body.isTeacher) {
-            let deploy = await k8sApi.readNamespacedDeployment(deployName, user_id);
+            let deploy = await readLab(k8sApi, deployName, user_id);
             deploy = deploy.body;
-            deploy.spec.replicas = deploy.spec.replicas === 0 ? 1 : 0;
 
             try {
-                const res = await k8sApi.replaceNamespacedDeployment(deploy.metadata.name, user_id, deploy);
+                const res = await scaleLab(k8sApi, deploy, deploy.spec.replicas === 0 ? 1 : 0);
                 response = json({}, { status: 200, statusText: 'Success' });
             } catch (err) {
                 console.log(err)
@@ -31,18 +30,38 @@ export async function PUT({ request, params }) {
 
         } else {
 
-            let allDeploys = await k8sApi.listNamespacedDeployment(user_id);
+            let allDeploys = await listLabs(k8sApi, user_id);
+            let customApi = kc.makeApiClient(k8s.CustomObjectsApi);
 
-            // shut all down except for the one we want to use
-            for (const deploy of allDeploys.body.items) {
+            // students request their labs by a LabSession named like the classroom, the operator checks the policy and scales the lab
+            // shut all down except for the one we want to use, the one we want to use is switched last
+            const deploys = allDeploys.body.items.filter(deploy => deploy.metadata.name !== deployName)
+                .concat(allDeploys.body.items.filter(deploy => deploy.metadata.name === deployName));
+            for (const deploy of deploys) {
+                let state = 'Stopped';
                 if (deploy.metadata.name === deployName) {
                     // Switch off or on depending on state
-                    deploy.spec.replicas = deploy.spec.replicas === 0 ? 1 : 0;
-                } else {
-                    deploy.spec.replicas = 0;
+                    state = deploy.spec.replicas === 0 ? 'Running' : 'Stopped';
+                } else if (deploy.spec.replicas === 0) {
+                    continue;
                 }
+                let session = {
+                    apiVersion: 'kubelab.kubelab.local/v1',
+                    kind: 'LabSession',
+                    metadata: { name: deploy.metadata.name, namespace: user_id },
+                    spec: { classroom: deploy.metadata.name, state: state },
+                };
                 try {
-                    const res = await k8sApi.replaceNamespacedDeployment(deploy.metadata.name, user_id, deploy);
+                    try {
+                        const existing = await customApi.getNamespacedCustomObject('kubelab.kubelab.local', 'v1', user_id, 'labsessions', deploy.metadata.name);
+                        session.metadata.resourceVersion = existing.body.metadata.resourceVersion;
+                        await customApi.replaceNamespacedCustomObject('kubelab.kubelab.local', 'v1', user_id, 'labsessions', deploy.metadata.name, session);
+                    } catch (err) {
+                        if (err.statusCode !== 404) {
+                            throw err;
+                        }
+                        await customApi.createNamespacedCustomObject('kubelab.kubelab.local', 'v1', user_id, 'labsessions', session);
+                    }
                     response = json({}, { status: 200, statusText: 'Success' });
                 } catch (err) {
                     console.log(err)
@@ -34,7 +34,7 @@ export async function POST({ request, fetch }) {
             // write file
             fs.writeFileSync(filePath, data);
 
-            // scale down all deployments, so the key is properly added
+            // stop all labs, so the key is properly added
             await fetch('/api/kubelab/deploy/scale/null', {
                 method: 'PUT',
                 headers: {